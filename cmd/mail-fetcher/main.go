@@ -5,7 +5,10 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/kexi/mail-to-tg/internal/attachments"
+	"github.com/kexi/mail-to-tg/internal/blobstore"
 	"github.com/kexi/mail-to-tg/internal/fetcher"
 	"github.com/kexi/mail-to-tg/internal/queue"
 	"github.com/kexi/mail-to-tg/internal/storage"
@@ -58,8 +61,15 @@ func main() {
 	// Create publisher
 	publisher := queue.NewPublisher(redis)
 
+	// Content-addressed attachment storage
+	blobs, err := blobstore.New(&cfg.Storage)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize blob storage")
+	}
+	attachmentStore := attachments.NewStore(db, blobs, &cfg.Storage)
+
 	// Create fetch manager
-	manager, err := fetcher.NewManager(db, publisher, cfg)
+	manager, err := fetcher.NewManager(db, publisher, attachmentStore, cfg)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create fetch manager")
 	}
@@ -69,6 +79,26 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to start fetch manager")
 	}
 
+	// Start attachment garbage collector
+	collector := attachments.NewCollector(
+		attachmentStore,
+		time.Duration(cfg.Storage.GCIntervalMinutes)*time.Minute,
+		time.Duration(cfg.Storage.RetentionDays)*24*time.Hour,
+	)
+	go func() {
+		if err := collector.Start(); err != nil {
+			log.Error().Err(err).Msg("Attachment garbage collector stopped")
+		}
+	}()
+
+	// Run actions telegram-service asks for on the mail servers
+	actionConsumer := queue.NewActionConsumer(redis, manager.HandleMailAction)
+	go func() {
+		if err := actionConsumer.Start(); err != nil {
+			log.Error().Err(err).Msg("Mail action consumer stopped")
+		}
+	}()
+
 	log.Info().Msg("Mail fetcher service started successfully")
 
 	// Wait for shutdown signal
@@ -80,6 +110,8 @@ func main() {
 	log.Info().Msg("Shutdown signal received, stopping service...")
 
 	// Graceful shutdown
+	actionConsumer.Stop()
+	collector.Stop()
 	manager.Stop()
 
 	log.Info().Msg("Mail fetcher service stopped")
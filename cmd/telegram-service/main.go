@@ -7,11 +7,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/kexi/mail-to-tg/internal/blobstore"
 	"github.com/kexi/mail-to-tg/internal/bot"
 	"github.com/kexi/mail-to-tg/internal/notifier"
+	"github.com/kexi/mail-to-tg/internal/smtp"
 	"github.com/kexi/mail-to-tg/internal/storage"
 	"github.com/kexi/mail-to-tg/internal/web"
 	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/crypto"
 	"github.com/kexi/mail-to-tg/pkg/llm"
 	"github.com/kexi/mail-to-tg/pkg/logger"
 	"github.com/rs/zerolog/log"
@@ -83,17 +86,41 @@ func main() {
 		log.Info().Msg("LLM summarization disabled")
 	}
 
+	// Attachment blob storage, shared with mail-fetcher
+	blobs, err := blobstore.New(&cfg.Storage)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize blob storage")
+	}
+
+	// Signer for expiring download links
+	if cfg.Security.JWTSecret == "" {
+		log.Fatal().Msg("security.jwt_secret is required for signed download links")
+	}
+	signer := crypto.NewSigner([]byte(cfg.Security.JWTSecret))
+
 	// Create notification consumer
+	attachmentLinkTTL := time.Duration(cfg.Web.AttachmentLinkTTLHours) * time.Hour
+	var maxUploadSize int64
+	if cfg.Telegram.SendAttachments {
+		maxUploadSize = int64(cfg.Telegram.MaxUploadSizeMB) << 20
+	}
 	llmTimeout := time.Duration(cfg.LLM.TimeoutSeconds) * time.Second
 	cacheTTL := time.Duration(cfg.LLM.CacheTTLHours) * time.Hour
 	consumer := notifier.NewNotificationConsumer(
 		redis,
 		db,
 		telegramBot.GetBot(),
+		blobs,
 		cfg.Web.BaseURL,
+		signer,
+		attachmentLinkTTL,
+		maxUploadSize,
 		llmClient,
 		llmTimeout,
 		cacheTTL,
+		&cfg.Queue,
+		&cfg.Telegram,
+		smtp.NewClient(cfg, db),
 	)
 
 	// Start consumer in goroutine
@@ -105,8 +132,24 @@ func main() {
 
 	log.Info().Msg("Notification consumer started")
 
+	// Queue emails again whose notification never happened
+	sweeper := notifier.NewSweeper(db, redis, &cfg.Queue)
+	go func() {
+		if err := sweeper.Start(); err != nil {
+			log.Error().Err(err).Msg("Unnotified email sweeper stopped")
+		}
+	}()
+
+	// Send digests when they are due
+	digester := notifier.NewDigester(consumer, redis)
+	go func() {
+		if err := digester.Start(); err != nil {
+			log.Error().Err(err).Msg("Digest sender stopped")
+		}
+	}()
+
 	// Start web server in goroutine
-	webServer := web.NewServer(&cfg.Web, db)
+	webServer := web.NewServer(&cfg.Web, db, blobs, signer)
 	go func() {
 		if err := webServer.Start(); err != nil {
 			log.Error().Err(err).Msg("Web server stopped")
@@ -133,6 +176,8 @@ func main() {
 	log.Info().Msg("Shutdown signal received, stopping service...")
 
 	// Graceful shutdown
+	sweeper.Stop()
+	digester.Stop()
 	consumer.Stop()
 	telegramBot.Stop()
 
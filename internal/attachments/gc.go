@@ -0,0 +1,122 @@
+package attachments
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Blobs are written before the email that references them is saved, so
+	// give fresh blobs time to be linked before treating them as garbage
+	gcGracePeriod = time.Hour
+
+	gcBatchSize = 500
+)
+
+// Collector applies attachment retention and deletes blobs no email references
+type Collector struct {
+	store     *Store
+	interval  time.Duration
+	retention time.Duration
+	stopped   bool
+}
+
+func NewCollector(store *Store, interval, retention time.Duration) *Collector {
+	return &Collector{
+		store:     store,
+		interval:  interval,
+		retention: retention,
+	}
+}
+
+func (c *Collector) Start() error {
+	log.Info().
+		Dur("interval", c.interval).
+		Dur("retention", c.retention).
+		Msg("Starting attachment garbage collector")
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for !c.stopped {
+		c.collect()
+		<-ticker.C
+	}
+
+	return nil
+}
+
+func (c *Collector) Stop() {
+	log.Info().Msg("Stopping attachment garbage collector")
+	c.stopped = true
+}
+
+func (c *Collector) collect() {
+	db := c.store.db
+
+	if c.retention > 0 {
+		removed, err := db.DeleteEmailAttachmentsBefore(time.Now().Add(-c.retention))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to apply attachment retention")
+		} else if removed > 0 {
+			log.Info().Int64("count", removed).Msg("Dropped expired attachment references")
+		}
+
+		cleared, err := db.ClearRawMessagesBefore(time.Now().Add(-c.retention))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to apply raw message retention")
+		} else if cleared > 0 {
+			log.Info().Int64("count", cleared).Msg("Dropped expired raw messages")
+		}
+	}
+
+	if err := db.RecountAttachmentBlobRefs(); err != nil {
+		log.Error().Err(err).Msg("Failed to recount attachment references")
+		return
+	}
+
+	cutoff := time.Now().Add(-gcGracePeriod)
+	blobs, err := db.GetUnreferencedAttachmentBlobs(cutoff, gcBatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load unreferenced attachment blobs")
+		return
+	}
+
+	var freed int64
+	deleted := 0
+	for _, blob := range blobs {
+		ok, err := c.deleteBlob(blob.SHA256, cutoff)
+		if err != nil {
+			log.Error().Err(err).Str("sha256", blob.SHA256).Msg("Failed to delete attachment blob")
+			continue
+		}
+		if ok {
+			deleted++
+			freed += blob.Size
+		}
+	}
+
+	if deleted > 0 {
+		log.Info().
+			Int("count", deleted).
+			Int64("bytes", freed).
+			Msg("Deleted unreferenced attachment blobs")
+	}
+}
+
+func (c *Collector) deleteBlob(sha256 string, cutoff time.Time) (bool, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	ok, err := c.store.db.DeleteAttachmentBlob(sha256, cutoff)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if err := c.store.blobs.Delete(BlobKey(sha256)); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
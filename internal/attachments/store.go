@@ -0,0 +1,193 @@
+package attachments
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/blobstore"
+	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrQuotaExceeded is returned for an original message that doesn't fit in
+// the user's storage quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Store keeps attachment contents in the blob store addressed by their
+// SHA-256, so the same file received many times is stored once
+type Store struct {
+	db    *storage.MariaDB
+	blobs blobstore.Store
+	quota int64
+
+	// Serializes blob writes with garbage collection
+	mu sync.Mutex
+}
+
+func NewStore(db *storage.MariaDB, blobs blobstore.Store, cfg *config.StorageConfig) *Store {
+	return &Store{
+		db:    db,
+		blobs: blobs,
+		quota: int64(cfg.QuotaMB) << 20,
+	}
+}
+
+// Put writes the attachment contents for a user and sets their paths.
+// Attachments that would exceed the user's quota are marked as skipped.
+func (s *Store) Put(userID string, attachments []*models.Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	var usage int64
+	if s.quota > 0 {
+		var err error
+		usage, err = s.db.GetUserAttachmentUsage(userID)
+		if err != nil {
+			return fmt.Errorf("failed to get attachment usage: %w", err)
+		}
+	}
+
+	for _, attachment := range attachments {
+		if attachment.Content == nil || attachment.Skipped != "" {
+			continue
+		}
+
+		if s.quota > 0 {
+			referenced, err := s.db.UserReferencesAttachmentBlob(userID, attachment.SHA256)
+			if err != nil {
+				return fmt.Errorf("failed to check attachment blob: %w", err)
+			}
+
+			if !referenced && usage+attachment.Size > s.quota {
+				attachment.Skipped = "storage quota exceeded"
+				log.Warn().
+					Str("user_id", userID).
+					Str("filename", attachment.Filename).
+					Int64("size", attachment.Size).
+					Msg("Attachment not stored, quota exceeded")
+				continue
+			}
+
+			if !referenced {
+				usage += attachment.Size
+			}
+		}
+
+		if err := s.putBlob(attachment); err != nil {
+			log.Error().Err(err).Str("filename", attachment.Filename).Msg("Failed to store attachment")
+			attachment.Skipped = "storage error"
+			continue
+		}
+
+		log.Debug().
+			Str("filename", attachment.Filename).
+			Str("sha256", attachment.SHA256).
+			Int64("size", attachment.Size).
+			Msg("Stored attachment")
+	}
+
+	return nil
+}
+
+// Link records the references from an email to its stored attachments
+func (s *Store) Link(emailID string, attachments []*models.Attachment) error {
+	for i, attachment := range attachments {
+		if attachment.Path == "" {
+			continue
+		}
+
+		contentType := attachment.ContentType
+		ref := &models.EmailAttachment{
+			ID:          uuid.New().String(),
+			EmailID:     emailID,
+			BlobSHA256:  attachment.SHA256,
+			Filename:    attachment.Filename,
+			ContentType: &contentType,
+			Size:        attachment.Size,
+			Position:    i,
+		}
+
+		if err := s.db.CreateEmailAttachment(ref); err != nil {
+			return fmt.Errorf("failed to link attachment %s: %w", attachment.Filename, err)
+		}
+	}
+
+	return nil
+}
+
+// PutRaw stores a user's original message in the blob store and returns
+// its SHA-256, which the email references in raw_sha256. It counts against
+// the user's quota like attachments.
+func (s *Store) PutRaw(userID string, raw []byte) (string, error) {
+	sum := sha256.Sum256(raw)
+	blob := &models.Attachment{
+		ContentType: "message/rfc822",
+		Size:        int64(len(raw)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Content:     raw,
+	}
+
+	if s.quota > 0 {
+		usage, err := s.db.GetUserAttachmentUsage(userID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get attachment usage: %w", err)
+		}
+		referenced, err := s.db.UserReferencesAttachmentBlob(userID, blob.SHA256)
+		if err != nil {
+			return "", fmt.Errorf("failed to check attachment blob: %w", err)
+		}
+		if !referenced && usage+blob.Size > s.quota {
+			return "", ErrQuotaExceeded
+		}
+	}
+
+	if err := s.putBlob(blob); err != nil {
+		return "", fmt.Errorf("failed to store raw message: %w", err)
+	}
+	return blob.SHA256, nil
+}
+
+func (s *Store) putBlob(attachment *models.Attachment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contentType := attachment.ContentType
+	blob := &models.AttachmentBlob{
+		SHA256:      attachment.SHA256,
+		Size:        attachment.Size,
+		ContentType: &contentType,
+	}
+	if err := s.db.UpsertAttachmentBlob(blob); err != nil {
+		return fmt.Errorf("failed to save attachment blob: %w", err)
+	}
+
+	key := BlobKey(attachment.SHA256)
+	if _, err := s.blobs.Stat(key); err == nil {
+		attachment.Path = key
+		return nil
+	} else if err != blobstore.ErrNotFound {
+		return fmt.Errorf("failed to check attachment blob: %w", err)
+	}
+
+	err := s.blobs.Put(key, bytes.NewReader(attachment.Content), attachment.Size, attachment.ContentType)
+	if err != nil {
+		return fmt.Errorf("failed to write attachment: %w", err)
+	}
+
+	attachment.Path = key
+	return nil
+}
+
+// BlobKey fans blobs out over two directory levels
+func BlobKey(sha256 string) string {
+	return path.Join(sha256[:2], sha256[2:4], sha256)
+}
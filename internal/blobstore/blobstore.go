@@ -0,0 +1,50 @@
+package blobstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kexi/mail-to-tg/pkg/config"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store is a flat key/value store for binary objects such as attachments
+type Store interface {
+	// Put stores the content of r under key, replacing any existing object
+	Put(key string, r io.Reader, size int64, contentType string) error
+
+	// Open returns a seekable reader for the object stored under key
+	Open(key string) (Object, *ObjectInfo, error)
+
+	// Stat returns the object's metadata, or ErrNotFound
+	Stat(key string) (*ObjectInfo, error)
+
+	// Delete removes the object; deleting a missing object is not an error
+	Delete(key string) error
+}
+
+type Object interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type ObjectInfo struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// New creates the store selected by storage.backend
+func New(cfg *config.StorageConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocalStore(cfg.AttachmentsPath), nil
+	case "s3":
+		return NewS3Store(&cfg.S3)
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %s", cfg.Backend)
+	}
+}
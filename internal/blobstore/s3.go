@@ -0,0 +1,116 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Store keeps blobs in an S3-compatible bucket (AWS S3, MinIO, ...)
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+	ctx    context.Context
+}
+
+func NewS3Store(cfg *config.S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("S3 endpoint and bucket are required")
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check S3 bucket: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("S3 bucket %s does not exist", cfg.Bucket)
+	}
+
+	return &S3Store{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: cfg.Prefix,
+		ctx:    ctx,
+	}, nil
+}
+
+func (s *S3Store) Put(key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(s.ctx, s.bucket, s.objectName(key), r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload blob: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Store) Open(key string) (Object, *ObjectInfo, error) {
+	obj, err := s.client.GetObject(s.ctx, s.bucket, s.objectName(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s.mapError(err)
+	}
+
+	// GetObject is lazy, Stat performs the request
+	stat, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, nil, s.mapError(err)
+	}
+
+	return obj, s.info(stat), nil
+}
+
+func (s *S3Store) Stat(key string) (*ObjectInfo, error) {
+	stat, err := s.client.StatObject(s.ctx, s.bucket, s.objectName(key), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s.mapError(err)
+	}
+	return s.info(stat), nil
+}
+
+func (s *S3Store) Delete(key string) error {
+	err := s.client.RemoveObject(s.ctx, s.bucket, s.objectName(key), minio.RemoveObjectOptions{})
+	if err != nil && s.mapError(err) != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (s *S3Store) objectName(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return path.Join(s.prefix, key)
+}
+
+func (s *S3Store) mapError(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *S3Store) info(stat minio.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Size:        stat.Size,
+		ContentType: stat.ContentType,
+		ModTime:     stat.LastModified,
+	}
+}
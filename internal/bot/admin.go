@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/kexi/mail-to-tg/internal/queue"
+	"github.com/kexi/mail-to-tg/pkg/textutil"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+// How many dead letters /dlq lists
+const deadLetterPageSize = 10
+
+// isAdmin reports whether the sender is listed in telegram.admin_ids
+func (b *Bot) isAdmin(c telebot.Context) bool {
+	if c.Sender() == nil {
+		return false
+	}
+	for _, id := range b.cfg.Telegram.AdminIDs {
+		if id == c.Sender().ID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleDeadLetters lists notifications that could not be delivered, with
+// buttons to replay or drop them
+func (b *Bot) handleDeadLetters(c telebot.Context) error {
+	if !b.isAdmin(c) {
+		return c.Send("This command is only available to administrators.")
+	}
+
+	letters, err := queue.NewPublisher(b.redis).DeadLetters()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get dead letters")
+		return c.Send("Failed to load the dead-letter queue. Please try again.")
+	}
+
+	if len(letters) == 0 {
+		return c.Send("The dead-letter queue is empty.")
+	}
+
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("<b>Undelivered notifications: %d</b>\n\n", len(letters)))
+
+	selector := &telebot.ReplyMarkup{}
+	var rows []telebot.Row
+
+	for i, letter := range letters {
+		if i == deadLetterPageSize {
+			message.WriteString(fmt.Sprintf("… and %d more\n", len(letters)-i))
+			break
+		}
+
+		subject := "(email deleted)"
+		if email, err := b.db.GetEmailMessageByID(letter.Event.EmailID); err == nil && email != nil {
+			subject = "(no subject)"
+			if email.Subject != nil && *email.Subject != "" {
+				subject = *email.Subject
+			}
+		}
+
+		message.WriteString(fmt.Sprintf("%d. %s\n   user %s, %d attempts, %s\n   <i>%s</i>\n",
+			i+1,
+			html.EscapeString(textutil.Truncate(subject, 60, "…")),
+			html.EscapeString(letter.Event.UserID),
+			letter.Attempts,
+			letter.FailedAt.UTC().Format("2006-01-02 15:04 UTC"),
+			html.EscapeString(textutil.Truncate(letter.Error, 120, "…"))))
+
+		rows = append(rows, selector.Row(
+			selector.Data(fmt.Sprintf("🔁 Replay %d", i+1), "dlq_replay_"+letter.Event.EmailID),
+			selector.Data(fmt.Sprintf("🗑 Drop %d", i+1), "dlq_drop_"+letter.Event.EmailID),
+		))
+	}
+
+	rows = append(rows, selector.Row(selector.Data("🔁 Replay all", "dlq_replay_all")))
+	selector.Inline(rows...)
+
+	return c.Send(message.String(), &telebot.SendOptions{
+		ParseMode:   telebot.ModeHTML,
+		ReplyMarkup: selector,
+	})
+}
+
+// handleDeadLetterAction replays or drops dead letters from /dlq buttons
+func (b *Bot) handleDeadLetterAction(c telebot.Context, data string) error {
+	if !b.isAdmin(c) {
+		return c.Respond(&telebot.CallbackResponse{Text: "Not allowed"})
+	}
+
+	publisher := queue.NewPublisher(b.redis)
+
+	switch {
+	case data == "replay_all":
+		letters, err := publisher.DeadLetters()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get dead letters")
+			return c.Respond(&telebot.CallbackResponse{Text: "Failed to load dead letters"})
+		}
+
+		replayed := 0
+		for _, letter := range letters {
+			ok, err := publisher.ReplayDeadLetter(letter.Event.EmailID)
+			if err != nil {
+				log.Error().Err(err).Str("email_id", letter.Event.EmailID).Msg("Failed to replay dead letter")
+				continue
+			}
+			if ok {
+				replayed++
+			}
+		}
+
+		log.Info().Int("count", replayed).Msg("Replayed dead letters")
+		return c.Edit(fmt.Sprintf("Replayed %d notifications.", replayed))
+
+	case strings.HasPrefix(data, "replay_"):
+		emailID := strings.TrimPrefix(data, "replay_")
+		ok, err := publisher.ReplayDeadLetter(emailID)
+		if err != nil {
+			log.Error().Err(err).Str("email_id", emailID).Msg("Failed to replay dead letter")
+			return c.Respond(&telebot.CallbackResponse{Text: "Failed to replay"})
+		}
+		if !ok {
+			return c.Respond(&telebot.CallbackResponse{Text: "Already replayed or dropped"})
+		}
+		return c.Respond(&telebot.CallbackResponse{Text: "Notification queued again"})
+
+	case strings.HasPrefix(data, "drop_"):
+		emailID := strings.TrimPrefix(data, "drop_")
+		if err := publisher.DropDeadLetter(emailID); err != nil {
+			log.Error().Err(err).Str("email_id", emailID).Msg("Failed to drop dead letter")
+			return c.Respond(&telebot.CallbackResponse{Text: "Failed to drop"})
+		}
+		// Otherwise the sweeper would find it unnotified and queue it again
+		if err := b.db.MarkEmailAsNotified(emailID); err != nil {
+			log.Error().Err(err).Str("email_id", emailID).Msg("Failed to mark dropped email as notified")
+		}
+		return c.Respond(&telebot.CallbackResponse{Text: "Notification dropped"})
+	}
+
+	return c.Respond(&telebot.CallbackResponse{Text: "Unknown action"})
+}
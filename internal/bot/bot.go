@@ -52,12 +52,24 @@ func (b *Bot) setupHandlers() {
 	b.bot.Handle("/unlink", b.handleUnlink)
 	b.bot.Handle("/accounts", b.handleAccounts)
 	b.bot.Handle("/search", b.handleSearch)
+	b.bot.Handle("/timezone", b.handleTimezone)
+	b.bot.Handle("/keys", b.handleKeys)
+	b.bot.Handle("/importkey", b.handleImportKey)
+	b.bot.Handle("/lists", b.handleLists)
+	b.bot.Handle("/rules", b.handleRules)
+	b.bot.Handle("/addrule", b.handleAddRule)
+	b.bot.Handle("/deliver", b.handleDeliver)
+	b.bot.Handle("/digest", b.handleDigest)
+	b.bot.Handle("/dlq", b.handleDeadLetters)
 
 	// Callback queries (for inline buttons)
 	b.bot.Handle(telebot.OnCallback, b.handleCallback)
 
 	// Text messages (for reply mode)
 	b.bot.Handle(telebot.OnText, b.handleText)
+
+	// Documents (for key import)
+	b.bot.Handle(telebot.OnDocument, b.handleDocument)
 }
 
 func (b *Bot) Start() error {
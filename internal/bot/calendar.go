@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kexi/mail-to-tg/internal/smtp"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+var rsvpPartStats = map[string]string{
+	"accept":    smtp.PartStatAccepted,
+	"tentative": smtp.PartStatTentative,
+	"decline":   smtp.PartStatDeclined,
+}
+
+// handleRSVP answers a calendar invitation, data is "<response>_<emailID>"
+func (b *Bot) handleRSVP(c telebot.Context, data string) error {
+	user := c.Get("user").(*models.User)
+
+	response, emailID, _ := strings.Cut(data, "_")
+	partstat, ok := rsvpPartStats[response]
+	if !ok {
+		return c.Respond(&telebot.CallbackResponse{Text: "Unknown action"})
+	}
+
+	// Get email
+	email, err := b.db.GetEmailMessageByID(emailID)
+	if err != nil || email == nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Email not found"})
+	}
+
+	event, err := email.ParseCalendarEvent()
+	if err != nil || event == nil || event.Method != models.CalendarMethodRequest {
+		return c.Respond(&telebot.CallbackResponse{Text: "This email is not an invitation"})
+	}
+
+	// Get email account
+	account, err := b.db.GetEmailAccountByID(email.AccountID)
+	if err != nil || account == nil || account.UserID != user.ID {
+		return c.Respond(&telebot.CallbackResponse{Text: "Email account not found"})
+	}
+
+	smtpClient := smtp.NewClient(b.cfg, b.db)
+	if err := smtpClient.SendCalendarReply(account, email, event, partstat); err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to send calendar reply")
+		return c.Respond(&telebot.CallbackResponse{
+			Text:      fmt.Sprintf("Failed to send response: %v", err),
+			ShowAlert: true,
+		})
+	}
+
+	if err := b.db.SetEmailCalendarRSVP(emailID, partstat); err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to save calendar response")
+	}
+	b.refreshNotification(emailID)
+
+	log.Info().
+		Str("user_id", user.ID).
+		Str("email_id", emailID).
+		Str("partstat", partstat).
+		Msg("Sent calendar reply")
+
+	return c.Respond(&telebot.CallbackResponse{
+		Text:      fmt.Sprintf("Response sent: %s", strings.ToLower(partstat)),
+		ShowAlert: true,
+	})
+}
+
+func (b *Bot) handleTimezone(c telebot.Context) error {
+	user := c.Get("user").(*models.User)
+
+	name := strings.TrimSpace(strings.TrimPrefix(c.Text(), "/timezone"))
+	if name == "" {
+		return c.Send(fmt.Sprintf("Your timezone: %s\n\nUsage: /timezone <name>\n\nExample: /timezone Europe/Berlin",
+			user.Location().String()))
+	}
+
+	if _, err := time.LoadLocation(name); err != nil {
+		return c.Send(fmt.Sprintf("Unknown timezone: %s\n\nUse a name like Europe/Berlin or America/New_York.", name))
+	}
+
+	user.Timezone = &name
+	if err := b.db.UpdateUser(user); err != nil {
+		log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to update timezone")
+		return c.Send("Failed to save timezone. Please try again.")
+	}
+
+	return c.Send(fmt.Sprintf("Timezone set to %s", name))
+}
@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+const deliverUsage = `Usage:
+In a group or forum topic: /deliver <account>
+  Notifications of the account go to this chat. In the General topic of a forum, the bot opens a topic for the account.
+
+In this chat: /deliver <account> <chat id or @channel> [topic id]
+  For channels and chats you can't write in.
+
+/deliver <account> off
+  Back to this private chat.
+
+You must be an administrator of the chat, and the bot a member that can post in it.`
+
+// handleDeliver sets where an account's notifications go
+func (b *Bot) handleDeliver(c telebot.Context) error {
+	user := c.Get("user").(*models.User)
+
+	// Anonymous admins post as the group, there's no one to verify
+	if c.Message().SenderChat != nil {
+		return c.Send("Turn off \"Remain anonymous\" in your admin rights to use /deliver here.")
+	}
+
+	args := c.Args()
+	if len(args) == 0 {
+		return b.showDeliveryTargets(c, user)
+	}
+
+	account, err := b.findUserAccount(user.ID, args[0])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get accounts")
+		return c.Send("Failed to load accounts. Please try again.")
+	}
+	if account == nil {
+		return c.Send(fmt.Sprintf("No linked account %s. See /accounts.", args[0]))
+	}
+
+	if len(args) >= 2 && strings.EqualFold(args[1], "off") {
+		if err := b.db.SetAccountDeliveryTarget(account.ID, nil, nil); err != nil {
+			log.Error().Err(err).Str("account_id", account.ID).Msg("Failed to reset delivery target")
+			return c.Send("Failed to save. Please try again.")
+		}
+		return c.Send(fmt.Sprintf("Notifications for %s go to your private chat again.", account.EmailAddress))
+	}
+
+	// The chat the command was sent in, or the one named
+	chat := c.Chat()
+	threadID := 0
+	if c.Message().TopicMessage {
+		threadID = c.Message().ThreadID
+	}
+	if len(args) >= 2 {
+		chat, err = b.lookupChat(args[1])
+		if err != nil {
+			return c.Send(fmt.Sprintf("Chat %s not found: %v\n\nAdd the bot to it first.", args[1], err))
+		}
+		threadID = 0
+		if len(args) >= 3 {
+			if threadID, err = strconv.Atoi(args[2]); err != nil || threadID <= 0 {
+				return c.Send("The topic ID must be a number.\n\n" + deliverUsage)
+			}
+		}
+	}
+
+	if chat.Type == telebot.ChatPrivate {
+		return c.Send(deliverUsage)
+	}
+
+	if err := b.verifyChatAdmin(chat.ID, user.TelegramID); err != nil {
+		return c.Send(fmt.Sprintf("Can't deliver to %s: %v", chatTitle(chat), err))
+	}
+
+	forum, err := b.isForum(chat.ID)
+	if err != nil {
+		log.Error().Err(err).Int64("chat_id", chat.ID).Msg("Failed to get chat")
+		return c.Send("Failed to read the chat. Please try again.")
+	}
+
+	// Forums get a topic per account unless one was picked
+	if forum && threadID == 0 {
+		topic, err := b.bot.CreateTopic(chat, &telebot.Topic{Name: account.EmailAddress})
+		if err != nil {
+			log.Error().Err(err).Int64("chat_id", chat.ID).Msg("Failed to create forum topic")
+			return c.Send(fmt.Sprintf("Failed to create a topic in %s: %v\n\nGive the bot the right to manage topics, or name a topic.", chatTitle(chat), err))
+		}
+		threadID = topic.ThreadID
+	}
+
+	var thread *int
+	if threadID != 0 {
+		thread = &threadID
+	}
+	if err := b.db.SetAccountDeliveryTarget(account.ID, &chat.ID, thread); err != nil {
+		log.Error().Err(err).Str("account_id", account.ID).Msg("Failed to save delivery target")
+		return c.Send("Failed to save. Please try again.")
+	}
+
+	log.Info().
+		Str("user_id", user.ID).
+		Str("account_id", account.ID).
+		Int64("chat_id", chat.ID).
+		Int("thread_id", threadID).
+		Msg("Set account delivery target")
+
+	where := chatTitle(chat)
+	if threadID != 0 {
+		where += fmt.Sprintf(" (topic %d)", threadID)
+	}
+	return c.Send(fmt.Sprintf("Notifications for %s now go to %s.", account.EmailAddress, where))
+}
+
+func (b *Bot) showDeliveryTargets(c telebot.Context, user *models.User) error {
+	accounts, err := b.db.GetEmailAccountsByUserID(user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get accounts")
+		return c.Send("Failed to load accounts. Please try again.")
+	}
+
+	if len(accounts) == 0 {
+		return c.Send("You don't have any linked email accounts. Use /link to add one.")
+	}
+
+	var message strings.Builder
+	message.WriteString("Where notifications go:\n\n")
+	for _, account := range accounts {
+		where := "your private chat"
+		if account.DeliveryChatID != nil {
+			where = fmt.Sprintf("chat %d", *account.DeliveryChatID)
+			if account.DeliveryThreadID != nil {
+				where += fmt.Sprintf(", topic %d", *account.DeliveryThreadID)
+			}
+		}
+		message.WriteString(fmt.Sprintf("• %s: %s\n", account.EmailAddress, where))
+	}
+	message.WriteString("\n" + deliverUsage)
+
+	return c.Send(message.String())
+}
+
+// lookupChat takes a numeric chat ID or an @username
+func (b *Bot) lookupChat(name string) (*telebot.Chat, error) {
+	if id, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return b.bot.ChatByID(id)
+	}
+	if !strings.HasPrefix(name, "@") {
+		name = "@" + name
+	}
+	return b.bot.ChatByUsername(name)
+}
+
+// isForum reports whether a chat has topics. telebot's Chat lacks the
+// is_forum field getChat returns.
+func (b *Bot) isForum(chatID int64) (bool, error) {
+	data, err := b.bot.Raw("getChat", map[string]string{"chat_id": strconv.FormatInt(chatID, 10)})
+	if err != nil {
+		return false, err
+	}
+
+	var resp struct {
+		Result struct {
+			IsForum bool `json:"is_forum"`
+		}
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return false, err
+	}
+	return resp.Result.IsForum, nil
+}
+
+func chatTitle(chat *telebot.Chat) string {
+	switch {
+	case chat.Title != "":
+		return chat.Title
+	case chat.Username != "":
+		return "@" + chat.Username
+	}
+	return strconv.FormatInt(chat.ID, 10)
+}
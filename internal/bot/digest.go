@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+const digestUsage = `Usage:
+/digest hourly - Collect low-priority mail and send it at the top of each hour
+/digest daily [HH:MM] - Send it once a day, at 08:00 unless you pick a time
+/digest off - Notify low-priority mail right away
+
+Rules with the digest action collect the mail they match in any case, daily when digests are off. Times are in your /timezone.`
+
+func (b *Bot) handleDigest(c telebot.Context) error {
+	user := c.Get("user").(*models.User)
+
+	args := c.Args()
+	if len(args) == 0 {
+		return c.Send(fmt.Sprintf("Your digest: %s\n\n%s", describeDigest(user), digestUsage))
+	}
+
+	schedule := models.DigestSchedule(strings.ToLower(args[0]))
+	if !schedule.Valid() {
+		return c.Send(digestUsage)
+	}
+
+	if len(args) >= 2 {
+		if schedule != models.DigestDaily {
+			return c.Send(digestUsage)
+		}
+		clock, err := time.Parse("15:04", args[1])
+		if err != nil {
+			return c.Send("The time must look like 08:00 or 18:30.\n\n" + digestUsage)
+		}
+		user.DigestTime = clock.Format("15:04")
+	}
+
+	user.DigestSchedule = schedule
+	if err := b.db.UpdateUser(user); err != nil {
+		log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to update digest schedule")
+		return c.Send("Failed to save. Please try again.")
+	}
+
+	log.Info().
+		Str("user_id", user.ID).
+		Str("schedule", string(user.DigestSchedule)).
+		Str("time", user.DigestTime).
+		Msg("Set digest schedule")
+
+	return c.Send(fmt.Sprintf("Your digest: %s", describeDigest(user)))
+}
+
+func describeDigest(user *models.User) string {
+	switch user.DigestSchedule {
+	case models.DigestHourly:
+		return "hourly, low-priority mail is collected"
+	case models.DigestDaily:
+		return fmt.Sprintf("daily at %s (%s), low-priority mail is collected", user.DigestTime, user.Location())
+	}
+	return "off, low-priority mail is notified right away"
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/queue"
 	"github.com/kexi/mail-to-tg/pkg/crypto"
 	"github.com/kexi/mail-to-tg/pkg/models"
 	"github.com/rs/zerolog/log"
@@ -23,6 +24,9 @@ Commands:
 /accounts - List your linked accounts
 /unlink - Unlink an email account
 /search <query> - Search your emails
+/timezone <name> - Set your timezone for event times
+/keys - List your S/MIME and OpenPGP keys
+/importkey - Import a key for signed or encrypted mail
 /help - Show this help message
 
 Get started by linking an email account with /link`
@@ -40,14 +44,24 @@ func (b *Bot) handleHelp(c telebot.Context) error {
 
 /accounts - List all linked email accounts
 /unlink - Remove an email account
-/search <query> - Search emails by subject or sender
+/search <query> - Search emails by subject, sender or content
+/timezone <name> - Set your timezone, e.g. Europe/Berlin
+/keys - List or delete your S/MIME and OpenPGP keys
+/importkey - Import a certificate or key to verify and decrypt mail
+/lists - Show unsubscribed mailing lists and unmute them
+/rules - List, turn off or delete your notification rules
+/addrule - Add a rule to skip, silence, digest, route, forward or tag mail
+/deliver - Send an account's notifications to a group, channel or forum topic
+/digest - Collect low-priority mail into an hourly or daily digest
 
 When you receive an email, you'll get a notification with:
 • Subject and sender
 • Preview of the content
 • Buttons to view full email or reply
 
-To reply to an email, click the [Reply] button and send your message.`
+To reply to an email, click the [Reply] button and send your message.
+Meeting invitations can be answered with the Accept, Tentative and Decline buttons.
+Newsletters have an [Unsubscribe] button, which also mutes the list.`
 
 	return c.Send(message)
 }
@@ -135,6 +149,8 @@ func (b *Bot) handleAccounts(c telebot.Context) error {
 	return c.Send(message.String())
 }
 
+const maxSearchResults = 10
+
 func (b *Bot) handleSearch(c telebot.Context) error {
 	query := c.Text()
 	if query == "/search" || strings.TrimSpace(strings.TrimPrefix(query, "/search")) == "" {
@@ -142,8 +158,42 @@ func (b *Bot) handleSearch(c telebot.Context) error {
 	}
 
 	query = strings.TrimSpace(strings.TrimPrefix(query, "/search"))
+	user := c.Get("user").(*models.User)
+
+	emails, err := b.db.SearchEmails(user.ID, query, maxSearchResults)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to search emails")
+		return c.Send("Search failed. Please try again.")
+	}
+
+	if len(emails) == 0 {
+		return c.Send(fmt.Sprintf("No emails found for: %s", query))
+	}
 
-	return c.Send(fmt.Sprintf("Searching for: %s\n\nSearch functionality coming soon!", query))
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("Results for: %s\n\n", query))
+
+	selector := &telebot.ReplyMarkup{}
+	var buttons []telebot.Btn
+
+	for i, email := range emails {
+		subject := "No subject"
+		if email.Subject != nil && *email.Subject != "" {
+			subject = *email.Subject
+		}
+		from := email.FromAddress
+		if email.FromName != nil && *email.FromName != "" {
+			from = *email.FromName
+		}
+
+		message.WriteString(fmt.Sprintf("%d. %s\n   %s, %s\n",
+			i+1, subject, from, email.Date.In(user.Location()).Format("2006-01-02 15:04")))
+
+		buttons = append(buttons, selector.Data(fmt.Sprintf("%d", i+1), "view_"+email.ID))
+	}
+
+	selector.Inline(selector.Split(5, buttons)...)
+	return c.Send(message.String(), selector)
 }
 
 func (b *Bot) handleCallback(c telebot.Context) error {
@@ -174,6 +224,24 @@ func (b *Bot) handleCallback(c telebot.Context) error {
 	case strings.HasPrefix(data, "mark_read_"):
 		emailID := strings.TrimPrefix(data, "mark_read_")
 		return b.handleMarkRead(c, emailID)
+
+	case strings.HasPrefix(data, "delkey_"):
+		return b.handleDeleteKey(c, strings.TrimPrefix(data, "delkey_"))
+
+	case strings.HasPrefix(data, "rsvp_"):
+		return b.handleRSVP(c, strings.TrimPrefix(data, "rsvp_"))
+
+	case strings.HasPrefix(data, "unsub_"):
+		return b.handleUnsubscribe(c, strings.TrimPrefix(data, "unsub_"))
+
+	case strings.HasPrefix(data, "unmute_"):
+		return b.handleUnmute(c, strings.TrimPrefix(data, "unmute_"))
+
+	case strings.HasPrefix(data, "dlq_"):
+		return b.handleDeadLetterAction(c, strings.TrimPrefix(data, "dlq_"))
+
+	case strings.HasPrefix(data, "rule_"):
+		return b.handleRuleAction(c, strings.TrimPrefix(data, "rule_"))
 	}
 
 	return c.Respond(&telebot.CallbackResponse{Text: "Unknown action"})
@@ -213,6 +281,13 @@ func (b *Bot) handleUnlinkAccount(c telebot.Context, accountID string) error {
 }
 
 func (b *Bot) handleViewEmail(c telebot.Context, emailID string) error {
+	user := c.Get("user").(*models.User)
+
+	// Notifications in groups and channels show the button to every member
+	if email, _ := b.userEmail(user, emailID); email == nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Email not found"})
+	}
+
 	// Generate view token and send URL
 	token := uuid.New().String()
 
@@ -236,19 +311,69 @@ func (b *Bot) handleViewEmail(c telebot.Context, emailID string) error {
 	})
 }
 
+// userEmail returns an email and its account when the account belongs to
+// the user, and nil otherwise
+func (b *Bot) userEmail(user *models.User, emailID string) (*models.EmailMessage, *models.EmailAccount) {
+	email, err := b.db.GetEmailMessageByID(emailID)
+	if err != nil || email == nil {
+		return nil, nil
+	}
+
+	account, err := b.db.GetEmailAccountByID(email.AccountID)
+	if err != nil || account == nil || account.UserID != user.ID {
+		return nil, nil
+	}
+
+	return email, account
+}
+
 func (b *Bot) handleReplyButton(c telebot.Context, emailID string) error {
 	return b.startReplyMode(c, emailID)
 }
 
+// handleMarkRead marks the email read, on the mail server too, and shows
+// it on the notification
 func (b *Bot) handleMarkRead(c telebot.Context, emailID string) error {
-	if err := b.db.MarkEmailAsNotified(emailID); err != nil {
+	user := c.Get("user").(*models.User)
+
+	email, err := b.db.GetEmailMessageByID(emailID)
+	if err != nil || email == nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Email not found"})
+	}
+
+	account, err := b.db.GetEmailAccountByID(email.AccountID)
+	if err != nil || account == nil || account.UserID != user.ID {
+		return c.Respond(&telebot.CallbackResponse{Text: "Email account not found"})
+	}
+
+	if err := b.db.MarkEmailAsRead(emailID); err != nil {
 		log.Error().Err(err).Msg("Failed to mark email as read")
 		return c.Respond(&telebot.CallbackResponse{Text: "Failed to mark as read"})
 	}
 
+	// mail-fetcher holds the connection to the server
+	err = queue.NewPublisher(b.redis).PublishMailAction(&queue.MailAction{
+		Action:    queue.MailActionMarkRead,
+		EmailID:   emailID,
+		AccountID: account.ID,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to ask for mark as read on the server")
+	}
+
+	b.refreshNotification(emailID)
+
 	return c.Respond(&telebot.CallbackResponse{Text: "Marked as read"})
 }
 
+// refreshNotification has the notifications of an email edited to show its
+// current state
+func (b *Bot) refreshNotification(emailID string) {
+	if err := queue.NewPublisher(b.redis).PublishEmailUpdate(emailID); err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to publish email update")
+	}
+}
+
 func (b *Bot) handleText(c telebot.Context) error {
 	user := c.Get("user").(*models.User)
 	text := c.Text()
@@ -260,6 +385,12 @@ func (b *Bot) handleText(c telebot.Context) error {
 		return b.handleLinkIMAPFlow(c, user, stateKey, step, text)
 	}
 
+	// Check if importing a pasted key
+	importKey := fmt.Sprintf("import_key:%d", user.TelegramID)
+	if pending, err := b.redis.Get(importKey); err == nil && pending != "" && strings.HasPrefix(text, "-----BEGIN") {
+		return b.importKey(c, user, []byte(text), "")
+	}
+
 	// Check if in reply mode
 	replyKey := fmt.Sprintf("reply:%d", user.TelegramID)
 	emailID, err := b.redis.Get(replyKey)
@@ -0,0 +1,188 @@
+package bot
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/parser"
+	"github.com/kexi/mail-to-tg/pkg/crypto"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+// Key files are small, anything bigger is not a key
+const maxKeyFileSize = 1 << 20
+
+func (b *Bot) handleKeys(c telebot.Context) error {
+	user := c.Get("user").(*models.User)
+
+	keys, err := b.db.GetUserCryptoKeys(user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get keys")
+		return c.Send("Failed to load keys. Please try again.")
+	}
+
+	if len(keys) == 0 {
+		return c.Send("You don't have any keys.\n\nUse /importkey to add an S/MIME certificate or OpenPGP key.")
+	}
+
+	var message strings.Builder
+	message.WriteString("Your keys:\n\n")
+
+	selector := &telebot.ReplyMarkup{}
+	var rows []telebot.Row
+
+	for i, key := range keys {
+		kind := "S/MIME"
+		if key.Kind == models.CryptoKeyPGP {
+			kind = "OpenPGP"
+		}
+		usage := "verify"
+		if key.HasPrivate {
+			usage = "verify, decrypt"
+		}
+
+		message.WriteString(fmt.Sprintf("%d. %s %s (%s)\n   %s\n",
+			i+1, kind, key.Identity, usage, shortFingerprint(key.Fingerprint)))
+
+		btn := selector.Data(fmt.Sprintf("🗑 %d. %s", i+1, key.Identity), "delkey_"+key.ID)
+		rows = append(rows, selector.Row(btn))
+	}
+
+	selector.Inline(rows...)
+	return c.Send(message.String(), selector)
+}
+
+func (b *Bot) handleImportKey(c telebot.Context) error {
+	user := c.Get("user").(*models.User)
+
+	stateKey := fmt.Sprintf("import_key:%d", user.TelegramID)
+	b.redis.Set(stateKey, "1", 10*time.Minute)
+
+	return c.Send(`Send your key as a file:
+• S/MIME: PEM certificate with private key, or a .p12/.pfx file
+• OpenPGP: armored public or private key, as a file or pasted
+
+If the file is protected, put the passphrase in the caption.
+Certificates and public keys without a private key are used to verify signatures from that sender.`)
+}
+
+func (b *Bot) handleDocument(c telebot.Context) error {
+	user := c.Get("user").(*models.User)
+
+	stateKey := fmt.Sprintf("import_key:%d", user.TelegramID)
+	if pending, err := b.redis.Get(stateKey); err != nil || pending == "" {
+		return c.Send("I don't understand. Use /help to see available commands.")
+	}
+
+	doc := c.Message().Document
+	if doc.FileSize > maxKeyFileSize {
+		return c.Send("This file is too large to be a key.")
+	}
+
+	reader, err := b.bot.File(&doc.File)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to download key file")
+		return c.Send("Failed to download the file. Please try again.")
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxKeyFileSize))
+	if err != nil {
+		return c.Send("Failed to download the file. Please try again.")
+	}
+
+	return b.importKey(c, user, data, c.Message().Caption)
+}
+
+// importKey stores a key and removes the message that carried it
+func (b *Bot) importKey(c telebot.Context, user *models.User, data []byte, passphrase string) error {
+	stateKey := fmt.Sprintf("import_key:%d", user.TelegramID)
+
+	// The message holds key material and maybe a passphrase
+	if err := c.Delete(); err != nil {
+		log.Warn().Err(err).Msg("Failed to delete key message")
+	}
+
+	imported, err := parser.ParseKey(data, passphrase)
+	if err != nil {
+		return c.Send(fmt.Sprintf("Could not import the key: %v\n\nSend another file to try again.", err))
+	}
+
+	encKey, err := base64.StdEncoding.DecodeString(b.cfg.Security.EncryptionKey)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode encryption key")
+		return c.Send("Failed to save key. Please try again.")
+	}
+
+	keyData, err := crypto.Encrypt(imported.Data, encKey)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encrypt key")
+		return c.Send("Failed to save key. Please try again.")
+	}
+
+	key := &models.UserCryptoKey{
+		ID:               uuid.New().String(),
+		UserID:           user.ID,
+		Kind:             imported.Kind,
+		Fingerprint:      imported.Fingerprint,
+		Identity:         imported.Identity,
+		HasPrivate:       imported.HasPrivate,
+		KeyDataEncrypted: keyData,
+	}
+
+	// OpenPGP keys are stored as given, keep the passphrase to unlock them
+	if imported.Kind == models.CryptoKeyPGP && imported.HasPrivate && passphrase != "" {
+		encPassphrase, err := crypto.Encrypt(passphrase, encKey)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to encrypt passphrase")
+			return c.Send("Failed to save key. Please try again.")
+		}
+		key.PassphraseEncrypted = &encPassphrase
+	}
+
+	if err := b.db.CreateUserCryptoKey(key); err != nil {
+		log.Error().Err(err).Msg("Failed to save key")
+		return c.Send("Failed to save key. Please try again.")
+	}
+
+	b.redis.Del(stateKey)
+
+	log.Info().
+		Str("user_id", user.ID).
+		Str("kind", key.Kind).
+		Str("fingerprint", key.Fingerprint).
+		Bool("has_private", key.HasPrivate).
+		Msg("Imported crypto key")
+
+	return c.Send(fmt.Sprintf("Imported %s (%s).\n\nThe message with the key was deleted.",
+		imported.Identity, shortFingerprint(imported.Fingerprint)))
+}
+
+func (b *Bot) handleDeleteKey(c telebot.Context, keyID string) error {
+	user := c.Get("user").(*models.User)
+
+	key, err := b.db.GetUserCryptoKeyByID(keyID)
+	if err != nil || key == nil || key.UserID != user.ID {
+		return c.Edit("Key not found.")
+	}
+
+	if err := b.db.DeleteUserCryptoKey(keyID); err != nil {
+		log.Error().Err(err).Str("key_id", keyID).Msg("Failed to delete key")
+		return c.Edit("Failed to delete key. Please try again.")
+	}
+
+	return c.Edit(fmt.Sprintf("Deleted key %s", key.Identity))
+}
+
+func shortFingerprint(fingerprint string) string {
+	if len(fingerprint) > 16 {
+		fingerprint = fingerprint[len(fingerprint)-16:]
+	}
+	return strings.ToUpper(fingerprint)
+}
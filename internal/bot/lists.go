@@ -0,0 +1,182 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/smtp"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/kexi/mail-to-tg/pkg/netutil"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+const unsubscribeTimeout = 15 * time.Second
+
+// handleUnsubscribe unsubscribes from the list an email came from, by
+// one-click POST if the sender supports it, otherwise by mail
+func (b *Bot) handleUnsubscribe(c telebot.Context, emailID string) error {
+	user := c.Get("user").(*models.User)
+
+	email, err := b.db.GetEmailMessageByID(emailID)
+	if err != nil || email == nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Email not found"})
+	}
+
+	account, err := b.db.GetEmailAccountByID(email.AccountID)
+	if err != nil || account == nil || account.UserID != user.ID {
+		return c.Respond(&telebot.CallbackResponse{Text: "Email account not found"})
+	}
+
+	oneClick, mailto := email.UnsubscribeOptions()
+	if oneClick == "" && mailto == nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "This email has no unsubscribe option"})
+	}
+
+	name := email.FromAddress
+	if email.FromName != nil && *email.FromName != "" {
+		name = *email.FromName
+	}
+
+	record := &models.ListUnsubscribe{
+		ID:      uuid.New().String(),
+		UserID:  user.ID,
+		ListKey: email.ListKey(),
+		Name:    name,
+		Muted:   true,
+	}
+
+	if oneClick != "" {
+		record.Method = models.UnsubscribeOneClick
+		record.Target = oneClick
+		err = postOneClick(oneClick)
+	} else {
+		record.Method = models.UnsubscribeMailto
+		record.Target = mailto.String()
+		err = sendUnsubscribeMail(smtp.NewClient(b.cfg, b.db), account, mailto)
+	}
+
+	record.Success = err == nil
+	if err != nil {
+		errMsg := err.Error()
+		record.Error = &errMsg
+		log.Error().Err(err).Str("email_id", emailID).Str("method", record.Method).Msg("Failed to unsubscribe")
+	}
+
+	// Mute the list either way, the user doesn't want this mail
+	if err := b.db.SaveListUnsubscribe(record); err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to save unsubscribe")
+	}
+
+	log.Info().
+		Str("user_id", user.ID).
+		Str("list", record.ListKey).
+		Str("method", record.Method).
+		Bool("success", record.Success).
+		Msg("Unsubscribed from list")
+
+	text := fmt.Sprintf("Unsubscribed from %s. Further mail from this list is muted.", name)
+	if !record.Success {
+		text = fmt.Sprintf("Unsubscribing failed: %v\n\nFurther mail from this list is muted anyway.", err)
+	}
+	return c.Respond(&telebot.CallbackResponse{Text: text, ShowAlert: true})
+}
+
+// postOneClick does the RFC 8058 one-click unsubscribe request
+func postOneClick(target string) error {
+	client := netutil.NewPublicClient(unsubscribeTimeout)
+
+	resp, err := client.Post(target, "application/x-www-form-urlencoded",
+		strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil {
+		return fmt.Errorf("failed to send unsubscribe request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unsubscribe request returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sendUnsubscribeMail mails the list's unsubscribe address, keeping the
+// subject and body it asks for
+func sendUnsubscribeMail(client *smtp.Client, account *models.EmailAccount, mailto *url.URL) error {
+	to, err := url.PathUnescape(mailto.Opaque)
+	if err != nil {
+		return fmt.Errorf("invalid unsubscribe address: %w", err)
+	}
+
+	query := mailto.Query()
+	subject := query.Get("subject")
+	if subject == "" {
+		subject = "unsubscribe"
+	}
+	body := query.Get("body")
+	if body == "" {
+		body = "unsubscribe"
+	}
+
+	return client.SendEmail(account, to, subject, body)
+}
+
+func (b *Bot) handleLists(c telebot.Context) error {
+	user := c.Get("user").(*models.User)
+
+	records, err := b.db.GetListUnsubscribes(user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get unsubscribes")
+		return c.Send("Failed to load lists. Please try again.")
+	}
+
+	if len(records) == 0 {
+		return c.Send("You haven't unsubscribed from any lists.\n\nUse the Unsubscribe button on a newsletter to stop it.")
+	}
+
+	var message strings.Builder
+	message.WriteString("Lists you unsubscribed from:\n\n")
+
+	selector := &telebot.ReplyMarkup{}
+	var rows []telebot.Row
+
+	for i, record := range records {
+		status := "unsubscribed"
+		if !record.Success {
+			status = "unsubscribe failed"
+		}
+		if record.Muted {
+			status += ", muted"
+		}
+
+		message.WriteString(fmt.Sprintf("%d. %s (%s)\n   %s\n", i+1, record.Name, status, record.ListKey))
+
+		if record.Muted {
+			btn := selector.Data(fmt.Sprintf("🔔 Unmute %d. %s", i+1, record.Name), "unmute_"+record.ID)
+			rows = append(rows, selector.Row(btn))
+		}
+	}
+
+	selector.Inline(rows...)
+	return c.Send(message.String(), selector)
+}
+
+func (b *Bot) handleUnmute(c telebot.Context, id string) error {
+	user := c.Get("user").(*models.User)
+
+	record, err := b.db.GetListUnsubscribeByID(id)
+	if err != nil || record == nil || record.UserID != user.ID {
+		return c.Respond(&telebot.CallbackResponse{Text: "List not found"})
+	}
+
+	if err := b.db.SetListMuted(id, false); err != nil {
+		log.Error().Err(err).Str("id", id).Msg("Failed to unmute list")
+		return c.Respond(&telebot.CallbackResponse{Text: "Failed to unmute list"})
+	}
+
+	return c.Edit(fmt.Sprintf("Mail from %s will be notified again.", record.Name))
+}
@@ -2,6 +2,7 @@ package bot
 
 import (
 	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/queue"
 	"github.com/kexi/mail-to-tg/pkg/models"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/telebot.v3"
@@ -53,6 +54,22 @@ func (b *Bot) authMiddleware(next telebot.HandlerFunc) telebot.HandlerFunc {
 				Str("user_id", user.ID).
 				Int64("telegram_id", telegramID).
 				Msg("Created new user")
+		} else if !user.IsActive {
+			// Delivery stopped when they blocked the bot, they're back
+			user.IsActive = true
+			if err := b.db.UpdateUser(user); err != nil {
+				log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to reactivate user")
+			} else {
+				log.Info().Str("user_id", user.ID).Msg("Reactivated delivery for user")
+
+				// Send what was held while they were away
+				replayed, err := queue.NewPublisher(b.redis).ReplayUserDeadLetters(user.ID)
+				if err != nil {
+					log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to replay held notifications")
+				} else if replayed > 0 {
+					log.Info().Str("user_id", user.ID).Int("count", replayed).Msg("Replayed held notifications")
+				}
+			}
 		}
 
 		// Store user in context
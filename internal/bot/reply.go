@@ -14,6 +14,11 @@ import (
 func (b *Bot) startReplyMode(c telebot.Context, emailID string) error {
 	user := c.Get("user").(*models.User)
 
+	// Only the owner of the mailbox may send from it, whoever sees the button
+	if email, _ := b.userEmail(user, emailID); email == nil {
+		return c.Respond(&telebot.CallbackResponse{Text: "Email not found"})
+	}
+
 	// Store reply state in Redis
 	replyKey := fmt.Sprintf("reply:%d", user.TelegramID)
 	b.redis.Set(replyKey, emailID, 10*time.Minute)
@@ -25,20 +30,13 @@ func (b *Bot) startReplyMode(c telebot.Context, emailID string) error {
 }
 
 func (b *Bot) handleReplyText(c telebot.Context, user *models.User, emailID, text string) error {
-	// Get original email
-	email, err := b.db.GetEmailMessageByID(emailID)
-	if err != nil || email == nil {
+	// Get original email and its account, which must be the user's
+	email, account := b.userEmail(user, emailID)
+	if email == nil {
 		b.redis.Del(fmt.Sprintf("reply:%d", user.TelegramID))
 		return c.Send("Original email not found. Reply cancelled.")
 	}
 
-	// Get email account
-	account, err := b.db.GetEmailAccountByID(email.AccountID)
-	if err != nil || account == nil {
-		b.redis.Del(fmt.Sprintf("reply:%d", user.TelegramID))
-		return c.Send("Email account not found. Reply cancelled.")
-	}
-
 	// Send reply via SMTP
 	smtpClient := smtp.NewClient(b.cfg, b.db)
 
@@ -47,7 +45,7 @@ func (b *Bot) handleReplyText(c telebot.Context, user *models.User, emailID, tex
 		subject += *email.Subject
 	}
 
-	err = smtpClient.SendReply(account, email, subject, text)
+	err := smtpClient.SendReply(account, email, subject, text)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to send reply")
 
@@ -81,6 +79,11 @@ func (b *Bot) handleReplyText(c telebot.Context, user *models.User, emailID, tex
 	}
 	b.db.CreateSentReply(reply)
 
+	if err := b.db.MarkEmailAsReplied(emailID); err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to mark email as replied")
+	}
+	b.refreshNotification(emailID)
+
 	// Clear reply state
 	b.redis.Del(fmt.Sprintf("reply:%d", user.TelegramID))
 
@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/rules"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+const addRuleUsage = `Usage: /addrule <conditions> => <actions>
+
+Conditions:
+  from:<address or @domain>
+  subject:"<regex>"  body:"<regex>"
+  attachment:yes|no
+  list:<list id>
+  category:<AI category>
+  account:<linked address>
+
+Actions:
+  skip, silent, digest, markread, stop
+  chat:<chat id> topic:<topic id>
+  priority:high|normal|low
+  forward:<address>
+  tag:<name>
+
+Examples:
+/addrule from:@github.com subject:"review requested" => silent tag:review
+/addrule list:news.example.com => skip markread
+/addrule from:@shop.example.com => digest
+/addrule category:finance => chat:-1001234567890 priority:high`
+
+func (b *Bot) handleAddRule(c telebot.Context) error {
+	user := c.Get("user").(*models.User)
+
+	definition := strings.TrimSpace(strings.TrimPrefix(c.Text(), "/addrule"))
+	if definition == "" {
+		return c.Send(addRuleUsage)
+	}
+
+	conditions, actions, err := rules.Parse(definition)
+	if err != nil {
+		return c.Send(fmt.Sprintf("Invalid rule: %v\n\n%s", err, addRuleUsage))
+	}
+
+	if conditions.AccountID != "" {
+		account, err := b.findUserAccount(user.ID, conditions.AccountID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get accounts")
+			return c.Send("Failed to load accounts. Please try again.")
+		}
+		if account == nil {
+			return c.Send(fmt.Sprintf("No linked account %s. See /accounts.", conditions.AccountID))
+		}
+		conditions.AccountID = account.ID
+	}
+
+	// Forwarding to a linked account would fetch the copy and forward it again
+	if actions.ForwardTo != "" {
+		own, err := b.findUserAccount(user.ID, actions.ForwardTo)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get accounts")
+			return c.Send("Failed to load accounts. Please try again.")
+		}
+		if own != nil {
+			return c.Send(fmt.Sprintf("Can't forward to %s, it is one of your linked accounts.", actions.ForwardTo))
+		}
+	}
+
+	// Only chats the user runs can receive their mail
+	if actions.ChatID != 0 {
+		if err := b.verifyChatAdmin(actions.ChatID, user.TelegramID); err != nil {
+			return c.Send(fmt.Sprintf("Can't route to chat %d: %v", actions.ChatID, err))
+		}
+	}
+
+	conditionsJSON, _ := json.Marshal(conditions)
+	actionsJSON, _ := json.Marshal(actions)
+	rule := &models.Rule{
+		ID:         uuid.New().String(),
+		UserID:     user.ID,
+		Definition: definition,
+		Conditions: string(conditionsJSON),
+		Actions:    string(actionsJSON),
+		Enabled:    true,
+	}
+
+	if err := b.db.CreateRule(rule); err != nil {
+		log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to save rule")
+		return c.Send("Failed to save rule. Please try again.")
+	}
+
+	log.Info().Str("user_id", user.ID).Str("rule_id", rule.ID).Msg("Added notification rule")
+
+	return c.Send(fmt.Sprintf("Rule added:\n%s\n\nSee /rules to turn it off or delete it.", definition))
+}
+
+func (b *Bot) handleRules(c telebot.Context) error {
+	user := c.Get("user").(*models.User)
+
+	userRules, err := b.db.GetRules(user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get rules")
+		return c.Send("Failed to load rules. Please try again.")
+	}
+
+	if len(userRules) == 0 {
+		return c.Send("You don't have any rules.\n\n" + addRuleUsage)
+	}
+
+	var message strings.Builder
+	message.WriteString("Your rules, applied in this order:\n\n")
+
+	selector := &telebot.ReplyMarkup{}
+	var rows []telebot.Row
+
+	for i, rule := range userRules {
+		status := ""
+		toggle := fmt.Sprintf("⏸ Turn off %d", i+1)
+		if !rule.Enabled {
+			status = " (off)"
+			toggle = fmt.Sprintf("▶️ Turn on %d", i+1)
+		}
+
+		message.WriteString(fmt.Sprintf("%d.%s %s\n", i+1, status, rule.Definition))
+
+		rows = append(rows, selector.Row(
+			selector.Data(toggle, "rule_toggle_"+rule.ID),
+			selector.Data(fmt.Sprintf("🗑 Delete %d", i+1), "rule_del_"+rule.ID),
+		))
+	}
+
+	selector.Inline(rows...)
+	return c.Send(message.String(), selector)
+}
+
+// handleRuleAction handles the buttons of /rules: toggle_<id> and del_<id>
+func (b *Bot) handleRuleAction(c telebot.Context, data string) error {
+	user := c.Get("user").(*models.User)
+
+	action, id, _ := strings.Cut(data, "_")
+
+	rule, err := b.db.GetRuleByID(id)
+	if err != nil || rule == nil || rule.UserID != user.ID {
+		return c.Respond(&telebot.CallbackResponse{Text: "Rule not found"})
+	}
+
+	switch action {
+	case "toggle":
+		if err := b.db.SetRuleEnabled(id, !rule.Enabled); err != nil {
+			log.Error().Err(err).Str("rule_id", id).Msg("Failed to toggle rule")
+			return c.Respond(&telebot.CallbackResponse{Text: "Failed to update rule"})
+		}
+		if rule.Enabled {
+			return c.Edit(fmt.Sprintf("Rule turned off:\n%s", rule.Definition))
+		}
+		return c.Edit(fmt.Sprintf("Rule turned on:\n%s", rule.Definition))
+
+	case "del":
+		if err := b.db.DeleteRule(id); err != nil {
+			log.Error().Err(err).Str("rule_id", id).Msg("Failed to delete rule")
+			return c.Respond(&telebot.CallbackResponse{Text: "Failed to delete rule"})
+		}
+		return c.Edit(fmt.Sprintf("Rule deleted:\n%s", rule.Definition))
+	}
+
+	return c.Respond(&telebot.CallbackResponse{Text: "Unknown action"})
+}
+
+// findUserAccount looks up one of the user's accounts by address or ID
+func (b *Bot) findUserAccount(userID, addressOrID string) (*models.EmailAccount, error) {
+	accounts, err := b.db.GetEmailAccountsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, account := range accounts {
+		if account.ID == addressOrID || strings.EqualFold(account.EmailAddress, addressOrID) {
+			return account, nil
+		}
+	}
+	return nil, nil
+}
+
+// verifyChatAdmin checks that the user administers the chat and the bot
+// can post in it
+func (b *Bot) verifyChatAdmin(chatID, telegramID int64) error {
+	chat := &telebot.Chat{ID: chatID}
+
+	member, err := b.bot.ChatMemberOf(chat, &telebot.User{ID: telegramID})
+	if err != nil {
+		return fmt.Errorf("add the bot to the chat first (%v)", err)
+	}
+	if member.Role != telebot.Creator && member.Role != telebot.Administrator {
+		return fmt.Errorf("you are not an administrator of the chat")
+	}
+
+	me, err := b.bot.ChatMemberOf(chat, b.bot.Me)
+	if err != nil {
+		return fmt.Errorf("the bot is not in the chat (%v)", err)
+	}
+	switch me.Role {
+	case telebot.Left, telebot.Kicked:
+		return fmt.Errorf("the bot is not in the chat")
+	case telebot.Restricted:
+		if !me.CanSendMessages {
+			return fmt.Errorf("the bot can't post in the chat")
+		}
+	}
+	return nil
+}
@@ -7,8 +7,10 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/attachments"
 	"github.com/kexi/mail-to-tg/internal/parser"
 	"github.com/kexi/mail-to-tg/internal/queue"
+	"github.com/kexi/mail-to-tg/internal/rules"
 	"github.com/kexi/mail-to-tg/internal/storage"
 	"github.com/kexi/mail-to-tg/pkg/config"
 	"github.com/kexi/mail-to-tg/pkg/models"
@@ -19,13 +21,14 @@ import (
 )
 
 type Client struct {
-	account   *models.EmailAccount
-	db        *storage.MariaDB
-	publisher *queue.Publisher
-	parser    *parser.Parser
-	cfg       *config.GmailConfig
-	srv       *gmail.Service
-	stopped   bool
+	account     *models.EmailAccount
+	db          *storage.MariaDB
+	publisher   *queue.Publisher
+	parser      *parser.Parser
+	attachments *attachments.Store
+	cfg         *config.GmailConfig
+	srv         *gmail.Service
+	stopped     bool
 }
 
 func NewClient(
@@ -33,6 +36,7 @@ func NewClient(
 	db *storage.MariaDB,
 	publisher *queue.Publisher,
 	emailParser *parser.Parser,
+	attachmentStore *attachments.Store,
 	cfg *config.GmailConfig,
 ) (*Client, error) {
 	if account.OAuthTokenEncrypted == nil || account.OAuthRefreshTokenEncrypted == nil {
@@ -73,12 +77,13 @@ func NewClient(
 	}
 
 	return &Client{
-		account:   account,
-		db:        db,
-		publisher: publisher,
-		parser:    emailParser,
-		cfg:       cfg,
-		srv:       srv,
+		account:     account,
+		db:          db,
+		publisher:   publisher,
+		parser:      emailParser,
+		attachments: attachmentStore,
+		cfg:         cfg,
+		srv:         srv,
 	}, nil
 }
 
@@ -194,8 +199,14 @@ func (c *Client) FetchAndProcessMessage(gmailID string) error {
 		return fmt.Errorf("failed to decode message: %w", err)
 	}
 
+	// Load the user's keys for signed and encrypted mail
+	keys, err := c.db.GetUserCryptoKeys(c.account.UserID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", c.account.UserID).Msg("Failed to load crypto keys")
+	}
+
 	// Parse email
-	parsed, err := c.parser.ParseRaw(rawEmail)
+	parsed, err := c.parser.ParseRawWithKeys(rawEmail, keys)
 	if err != nil {
 		return fmt.Errorf("failed to parse message: %w", err)
 	}
@@ -224,29 +235,58 @@ func (c *Client) FetchAndProcessMessage(gmailID string) error {
 
 	// Create email message record
 	email := &models.EmailMessage{
-		ID:            uuid.New().String(),
-		AccountID:     c.account.ID,
-		MessageID:     messageID,
-		ThreadID:      &msg.ThreadId,
-		GmailID:       &msg.Id,
-		FromAddress:   parsed.FromAddress,
-		FromName:      parsed.FromName,
-		ToAddresses:   parsed.ToAddresses,
-		Subject:       parsed.Subject,
-		Date:          parsed.Date,
-		TextBody:      parsed.TextBody,
-		HTMLBody:      parsed.HTMLBody,
-		SanitizedHTML: parsed.SanitizedHTML,
-		InReplyTo:     parsed.InReplyTo,
-		References:    parsed.References,
-		IsRead:        false,
-		IsNotified:    false,
+		ID:                  uuid.New().String(),
+		AccountID:           c.account.ID,
+		MessageID:           messageID,
+		ThreadID:            &msg.ThreadId,
+		GmailID:             &msg.Id,
+		FromAddress:         parsed.FromAddress,
+		FromName:            parsed.FromName,
+		ToAddresses:         parsed.ToAddresses,
+		Subject:             parsed.Subject,
+		Date:                parsed.Date,
+		TextBody:            parsed.TextBody,
+		NewContent:          parsed.NewContent,
+		HTMLBody:            parsed.HTMLBody,
+		HTMLText:            parsed.HTMLText,
+		SanitizedHTML:       parsed.SanitizedHTML,
+		InReplyTo:           parsed.InReplyTo,
+		References:          parsed.References,
+		CalendarEvent:       parsed.CalendarEventJSON(),
+		CryptoStatus:        parsed.CryptoStatusJSON(),
+		AuthVerdict:         parsed.AuthVerdictJSON(),
+		ListID:              parsed.ListID,
+		ListUnsubscribe:     parsed.ListUnsubscribe,
+		ListUnsubscribePost: parsed.ListUnsubscribePost,
+		EmbeddedMessages:    parsed.EmbeddedMessagesJSON(),
+		Priority:            parsed.Priority,
+		AutoForwarded:       parsed.AutoForwarded,
+		IsRead:              false,
+		IsNotified:          false,
+	}
+
+	// Keep the original for .eml downloads
+	if sha, err := c.attachments.PutRaw(c.account.UserID, rawEmail); err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to store raw message")
+	} else {
+		email.RawSHA256 = &sha
 	}
 
 	// Handle attachments
 	if len(parsed.Attachments) > 0 {
+		if err := c.attachments.Put(c.account.UserID, parsed.Attachments); err != nil {
+			log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to store attachments")
+		}
 		email.HasAttachments = true
-		email.Attachments = parsed.AttachmentsJSON
+		email.Attachments = parsed.AttachmentsJSON()
+	}
+
+	// A rule can move the email to another lane
+	userRules, err := c.db.GetEnabledRules(c.account.UserID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", c.account.UserID).Msg("Failed to load rules")
+	} else if outcome := rules.Evaluate(userRules, email, ""); outcome != nil && outcome.Priority != "" {
+		email.Priority = outcome.Priority
 	}
 
 	// Save to database
@@ -254,6 +294,10 @@ func (c *Client) FetchAndProcessMessage(gmailID string) error {
 		return fmt.Errorf("failed to save email: %w", err)
 	}
 
+	if err := c.attachments.Link(email.ID, parsed.Attachments); err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to link attachments")
+	}
+
 	log.Info().
 		Str("email_id", email.ID).
 		Str("message_id", messageID).
@@ -265,6 +309,7 @@ func (c *Client) FetchAndProcessMessage(gmailID string) error {
 		EmailID:   email.ID,
 		AccountID: c.account.ID,
 		UserID:    c.account.UserID,
+		Priority:  email.Priority,
 	}
 
 	if err := c.publisher.PublishEmailEvent(event); err != nil {
@@ -274,6 +319,15 @@ func (c *Client) FetchAndProcessMessage(gmailID string) error {
 	return nil
 }
 
+// MarkAsRead removes the UNREAD label of a message
+func (c *Client) MarkAsRead(gmailID string) error {
+	req := &gmail.ModifyMessageRequest{RemoveLabelIds: []string{"UNREAD"}}
+	if _, err := c.srv.Users.Messages.Modify("me", gmailID, req).Do(); err != nil {
+		return fmt.Errorf("failed to remove UNREAD label: %w", err)
+	}
+	return nil
+}
+
 func (c *Client) HandlePushNotification(historyID uint64) error {
 	if c.account.GmailHistoryID == nil {
 		return c.FetchUnreadMessages()
@@ -296,6 +350,21 @@ func (c *Client) HandlePushNotification(historyID uint64) error {
 					Msg("Failed to process message from history")
 			}
 		}
+
+		// Changes to mail that was already notified
+		for _, msg := range history.MessagesDeleted {
+			c.syncMessage(msg.Message.Id, true, false)
+		}
+		for _, change := range history.LabelsAdded {
+			if hasLabel(change.LabelIds, "TRASH") {
+				c.syncMessage(change.Message.Id, true, false)
+			}
+		}
+		for _, change := range history.LabelsRemoved {
+			if hasLabel(change.LabelIds, "UNREAD") {
+				c.syncMessage(change.Message.Id, false, true)
+			}
+		}
 	}
 
 	// Update history ID
@@ -305,3 +374,42 @@ func (c *Client) HandlePushNotification(historyID uint64) error {
 
 	return nil
 }
+
+// syncMessage records that a message was deleted or read in Gmail, and has
+// its notification edited
+func (c *Client) syncMessage(gmailID string, deleted, read bool) {
+	email, err := c.db.GetEmailMessageByGmailID(c.account.ID, gmailID)
+	if err != nil {
+		log.Error().Err(err).Str("gmail_id", gmailID).Msg("Failed to look up changed message")
+		return
+	}
+	if email == nil {
+		return
+	}
+
+	switch {
+	case deleted && !email.IsDeleted:
+		err = c.db.MarkEmailAsDeleted(email.ID)
+	case read && !email.IsRead:
+		err = c.db.MarkEmailAsRead(email.ID)
+	default:
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to save message state")
+		return
+	}
+
+	if err := c.publisher.PublishEmailUpdate(email.ID); err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to publish email update")
+	}
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -149,6 +149,43 @@ func (c *Client) FetchUnread() ([]*Message, error) {
 	return result, nil
 }
 
+// ExistingUIDs reports which of the given messages are still in INBOX and
+// not flagged \Deleted, and the UIDVALIDITY of INBOX the UIDs belong to
+func (c *Client) ExistingUIDs(uids []uint32) (map[uint32]bool, uint32, error) {
+	imapClient, err := client.DialTLS(fmt.Sprintf("%s:%d", c.server, c.port), nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+	defer imapClient.Logout()
+
+	if err := imapClient.Login(c.username, c.password); err != nil {
+		return nil, 0, fmt.Errorf("failed to login: %w", err)
+	}
+
+	mbox, err := imapClient.Select("INBOX", true)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to select INBOX: %w", err)
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = seqset
+	criteria.WithoutFlags = []string{imap.DeletedFlag}
+
+	found, err := imapClient.UidSearch(criteria)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	existing := make(map[uint32]bool, len(found))
+	for _, uid := range found {
+		existing[uid] = true
+	}
+	return existing, mbox.UidValidity, nil
+}
+
 func (c *Client) MarkAsSeen(uid uint32) error {
 	imapClient, err := client.DialTLS(fmt.Sprintf("%s:%d", c.server, c.port), nil)
 	if err != nil {
@@ -170,5 +207,5 @@ func (c *Client) MarkAsSeen(uid uint32) error {
 	item := imap.FormatFlagsOp(imap.AddFlags, true)
 	flags := []interface{}{imap.SeenFlag}
 
-	return imapClient.Store(seqset, item, flags, nil)
+	return imapClient.UidStore(seqset, item, flags, nil)
 }
@@ -5,20 +5,26 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/attachments"
 	"github.com/kexi/mail-to-tg/internal/parser"
 	"github.com/kexi/mail-to-tg/internal/queue"
+	"github.com/kexi/mail-to-tg/internal/rules"
 	"github.com/kexi/mail-to-tg/internal/storage"
 	"github.com/kexi/mail-to-tg/pkg/models"
 	"github.com/rs/zerolog/log"
 )
 
+// How far back emails are checked for removal from INBOX
+const syncWindow = 7 * 24 * time.Hour
+
 type Poller struct {
-	account   *models.EmailAccount
-	db        *storage.MariaDB
-	publisher *queue.Publisher
-	parser    *parser.Parser
-	interval  time.Duration
-	stopped   bool
+	account     *models.EmailAccount
+	db          *storage.MariaDB
+	publisher   *queue.Publisher
+	parser      *parser.Parser
+	attachments *attachments.Store
+	interval    time.Duration
+	stopped     bool
 }
 
 func NewPoller(
@@ -26,14 +32,16 @@ func NewPoller(
 	db *storage.MariaDB,
 	publisher *queue.Publisher,
 	emailParser *parser.Parser,
+	attachmentStore *attachments.Store,
 	interval time.Duration,
 ) *Poller {
 	return &Poller{
-		account:   account,
-		db:        db,
-		publisher: publisher,
-		parser:    emailParser,
-		interval:  interval,
+		account:     account,
+		db:          db,
+		publisher:   publisher,
+		parser:      emailParser,
+		attachments: attachmentStore,
+		interval:    interval,
 	}
 }
 
@@ -85,6 +93,7 @@ func (p *Poller) fetchOnce() error {
 
 	client := NewClient(*p.account.IMAPServer, *p.account.IMAPPort, *p.account.IMAPUsername, password)
 
+	started := time.Now()
 	messages, err := client.FetchUnread()
 	if err != nil {
 		// Update account with error
@@ -110,6 +119,8 @@ func (p *Poller) fetchOnce() error {
 		}
 	}
 
+	p.syncRemoved(client, started)
+
 	// Update last fetch time
 	now := time.Now()
 	p.account.LastFetchAt = &now
@@ -121,6 +132,91 @@ func (p *Poller) fetchOnce() error {
 	return nil
 }
 
+// MarkAsSeen sets the \Seen flag of a message on the server
+func (p *Poller) MarkAsSeen(uid uint32) error {
+	if p.account.IMAPServer == nil || p.account.IMAPPort == nil ||
+		p.account.IMAPUsername == nil || p.account.IMAPPasswordEncrypted == nil {
+		return fmt.Errorf("IMAP credentials not configured")
+	}
+
+	password, err := p.parser.DecryptPassword(*p.account.IMAPPasswordEncrypted)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt IMAP password: %w", err)
+	}
+
+	client := NewClient(*p.account.IMAPServer, *p.account.IMAPPort, *p.account.IMAPUsername, password)
+	if err := client.MarkAsSeen(uid); err != nil {
+		return fmt.Errorf("failed to mark message as seen: %w", err)
+	}
+	return nil
+}
+
+// syncRemoved notices recent emails that were deleted or moved out of
+// INBOX, so their notifications are struck through. Emails stored from
+// this fetch on, which started at started, already carry current UIDs.
+func (p *Poller) syncRemoved(client *Client, started time.Time) {
+	emails, err := p.db.GetTrackedIMAPEmails(p.account.ID, time.Now().Add(-syncWindow))
+	if err != nil {
+		log.Error().Err(err).Str("account_id", p.account.ID).Msg("Failed to load emails to sync")
+		return
+	}
+	if len(emails) == 0 {
+		return
+	}
+
+	uids := make([]uint32, 0, len(emails))
+	for _, email := range emails {
+		uids = append(uids, uint32(*email.IMAPUID))
+	}
+
+	existing, validity, err := client.ExistingUIDs(uids)
+	if err != nil {
+		log.Error().Err(err).Str("account_id", p.account.ID).Msg("Failed to check for removed messages")
+		return
+	}
+
+	// The UIDs we stored only mean something under the UIDVALIDITY they
+	// were seen with. When it changes they are dropped, not taken for
+	// removals; the first time it's only recorded.
+	known := p.account.IMAPUIDValidity
+	if known == nil || *known != int64(validity) {
+		if known != nil {
+			log.Warn().
+				Str("account_id", p.account.ID).
+				Int64("old", *known).
+				Uint32("new", validity).
+				Msg("INBOX UIDVALIDITY changed, forgetting stored UIDs")
+			if err := p.db.ClearIMAPUIDs(p.account.ID, started); err != nil {
+				log.Error().Err(err).Str("account_id", p.account.ID).Msg("Failed to clear stored UIDs")
+				return
+			}
+		}
+		v := int64(validity)
+		if err := p.db.SetIMAPUIDValidity(p.account.ID, v); err != nil {
+			log.Error().Err(err).Str("account_id", p.account.ID).Msg("Failed to save UIDVALIDITY")
+			return
+		}
+		p.account.IMAPUIDValidity = &v
+		return
+	}
+
+	for _, email := range emails {
+		if existing[uint32(*email.IMAPUID)] {
+			continue
+		}
+
+		if err := p.db.MarkEmailAsDeleted(email.ID); err != nil {
+			log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to mark email as removed")
+			continue
+		}
+		if err := p.publisher.PublishEmailUpdate(email.ID); err != nil {
+			log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to publish email update")
+		}
+
+		log.Info().Str("email_id", email.ID).Msg("Email was removed from INBOX")
+	}
+}
+
 func (p *Poller) processMessage(msg *Message) error {
 	// Check if message already exists
 	existing, err := p.db.GetEmailMessageByAccountAndMessageID(p.account.ID, msg.MessageID)
@@ -134,37 +230,84 @@ func (p *Poller) processMessage(msg *Message) error {
 		return nil
 	}
 
+	// Load the user's keys for signed and encrypted mail
+	keys, err := p.db.GetUserCryptoKeys(p.account.UserID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", p.account.UserID).Msg("Failed to load crypto keys")
+	}
+
 	// Parse email
-	parsed, err := p.parser.ParseRaw(msg.RawMessage)
+	parsed, err := p.parser.ParseRawWithKeys(msg.RawMessage, keys)
 	if err != nil {
 		return fmt.Errorf("failed to parse message: %w", err)
 	}
 
 	// Create email message record
 	email := &models.EmailMessage{
-		ID:            uuid.New().String(),
-		AccountID:     p.account.ID,
-		MessageID:     msg.MessageID,
-		IMAPUID:       new(int64),
-		FromAddress:   parsed.FromAddress,
-		FromName:      parsed.FromName,
-		ToAddresses:   parsed.ToAddresses,
-		Subject:       parsed.Subject,
-		Date:          parsed.Date,
-		TextBody:      parsed.TextBody,
-		HTMLBody:      parsed.HTMLBody,
-		SanitizedHTML: parsed.SanitizedHTML,
-		InReplyTo:     parsed.InReplyTo,
-		References:    parsed.References,
-		IsRead:        false,
-		IsNotified:    false,
+		ID:                  uuid.New().String(),
+		AccountID:           p.account.ID,
+		MessageID:           msg.MessageID,
+		IMAPUID:             new(int64),
+		FromAddress:         parsed.FromAddress,
+		FromName:            parsed.FromName,
+		ToAddresses:         parsed.ToAddresses,
+		Subject:             parsed.Subject,
+		Date:                parsed.Date,
+		TextBody:            parsed.TextBody,
+		NewContent:          parsed.NewContent,
+		HTMLBody:            parsed.HTMLBody,
+		HTMLText:            parsed.HTMLText,
+		SanitizedHTML:       parsed.SanitizedHTML,
+		InReplyTo:           parsed.InReplyTo,
+		References:          parsed.References,
+		CalendarEvent:       parsed.CalendarEventJSON(),
+		CryptoStatus:        parsed.CryptoStatusJSON(),
+		AuthVerdict:         parsed.AuthVerdictJSON(),
+		ListID:              parsed.ListID,
+		ListUnsubscribe:     parsed.ListUnsubscribe,
+		ListUnsubscribePost: parsed.ListUnsubscribePost,
+		EmbeddedMessages:    parsed.EmbeddedMessagesJSON(),
+		Priority:            parsed.Priority,
+		AutoForwarded:       parsed.AutoForwarded,
+		IsRead:              false,
+		IsNotified:          false,
 	}
 	*email.IMAPUID = int64(msg.UID)
 
+	// IMAP has no thread IDs: the email joins the thread of the mail it
+	// replies to, or starts one
+	threadID, err := p.db.FindThreadID(p.account.ID, parsed.ReferencedIDs())
+	if err != nil {
+		log.Error().Err(err).Str("message_id", msg.MessageID).Msg("Failed to look up thread")
+	}
+	if threadID == nil {
+		root := parsed.ThreadRoot(msg.MessageID)
+		threadID = &root
+	}
+	email.ThreadID = threadID
+
+	// Keep the original for .eml downloads
+	if sha, err := p.attachments.PutRaw(p.account.UserID, msg.RawMessage); err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to store raw message")
+	} else {
+		email.RawSHA256 = &sha
+	}
+
 	// Handle attachments
 	if len(parsed.Attachments) > 0 {
+		if err := p.attachments.Put(p.account.UserID, parsed.Attachments); err != nil {
+			log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to store attachments")
+		}
 		email.HasAttachments = true
-		email.Attachments = parsed.AttachmentsJSON
+		email.Attachments = parsed.AttachmentsJSON()
+	}
+
+	// A rule can move the email to another lane
+	userRules, err := p.db.GetEnabledRules(p.account.UserID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", p.account.UserID).Msg("Failed to load rules")
+	} else if outcome := rules.Evaluate(userRules, email, ""); outcome != nil && outcome.Priority != "" {
+		email.Priority = outcome.Priority
 	}
 
 	// Save to database
@@ -172,6 +315,10 @@ func (p *Poller) processMessage(msg *Message) error {
 		return fmt.Errorf("failed to save email: %w", err)
 	}
 
+	if err := p.attachments.Link(email.ID, parsed.Attachments); err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to link attachments")
+	}
+
 	log.Info().
 		Str("email_id", email.ID).
 		Str("message_id", msg.MessageID).
@@ -183,6 +330,7 @@ func (p *Poller) processMessage(msg *Message) error {
 		EmailID:   email.ID,
 		AccountID: p.account.ID,
 		UserID:    p.account.UserID,
+		Priority:  email.Priority,
 	}
 
 	if err := p.publisher.PublishEmailEvent(event); err != nil {
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kexi/mail-to-tg/internal/attachments"
 	"github.com/kexi/mail-to-tg/internal/fetcher/gmail"
 	"github.com/kexi/mail-to-tg/internal/fetcher/imap"
 	"github.com/kexi/mail-to-tg/internal/parser"
@@ -20,6 +21,7 @@ type Manager struct {
 	db              *storage.MariaDB
 	publisher       *queue.Publisher
 	parser          *parser.Parser
+	attachments     *attachments.Store
 	cfg             *config.Config
 	pollers         map[string]*imap.Poller
 	gmailClients    map[string]*gmail.Client
@@ -30,6 +32,7 @@ type Manager struct {
 func NewManager(
 	db *storage.MariaDB,
 	publisher *queue.Publisher,
+	attachmentStore *attachments.Store,
 	cfg *config.Config,
 ) (*Manager, error) {
 	// Decode encryption key
@@ -38,12 +41,16 @@ func NewManager(
 		return nil, fmt.Errorf("failed to decode encryption key: %w", err)
 	}
 
-	emailParser := parser.NewParser(encryptionKey, cfg.Storage.AttachmentsPath)
+	policy := parser.NewAttachmentPolicy(&cfg.Security.AttachmentPolicy)
+	auth := parser.NewAuthChecker(&cfg.Security)
+	priority := parser.NewPriorityRules(&cfg.Queue)
+	emailParser := parser.NewParser(encryptionKey, policy, auth, priority)
 
 	return &Manager{
 		db:           db,
 		publisher:    publisher,
 		parser:       emailParser,
+		attachments:  attachmentStore,
 		cfg:          cfg,
 		pollers:      make(map[string]*imap.Poller),
 		gmailClients: make(map[string]*gmail.Client),
@@ -172,7 +179,7 @@ func (m *Manager) startIMAPPoller(account *models.EmailAccount) error {
 	}
 
 	interval := time.Duration(m.cfg.MailFetcher.IMAPPollInterval) * time.Second
-	poller := imap.NewPoller(account, m.db, m.publisher, m.parser, interval)
+	poller := imap.NewPoller(account, m.db, m.publisher, m.parser, m.attachments, interval)
 
 	m.pollers[account.ID] = poller
 
@@ -203,7 +210,7 @@ func (m *Manager) startGmailClient(account *models.EmailAccount) error {
 		return nil
 	}
 
-	client, err := gmail.NewClient(account, m.db, m.publisher, m.parser, &m.cfg.MailFetcher.Gmail)
+	client, err := gmail.NewClient(account, m.db, m.publisher, m.parser, m.attachments, &m.cfg.MailFetcher.Gmail)
 	if err != nil {
 		return fmt.Errorf("failed to create Gmail client: %w", err)
 	}
@@ -244,3 +251,48 @@ func (m *Manager) StopFetcherForAccount(accountID string) {
 		log.Info().Str("account_id", accountID).Msg("Stopped Gmail client")
 	}
 }
+
+// HandleMailAction runs an action telegram-service asked for on the
+// email's mail server
+func (m *Manager) HandleMailAction(action *queue.MailAction) error {
+	if action.Action != queue.MailActionMarkRead {
+		return fmt.Errorf("unknown mail action: %s", action.Action)
+	}
+
+	email, err := m.db.GetEmailMessageByID(action.EmailID)
+	if err != nil {
+		return fmt.Errorf("failed to load email: %w", err)
+	}
+	if email == nil {
+		return fmt.Errorf("email not found")
+	}
+
+	m.mu.RLock()
+	poller := m.pollers[email.AccountID]
+	client := m.gmailClients[email.AccountID]
+	m.mu.RUnlock()
+
+	switch {
+	case poller != nil && email.IMAPUID != nil:
+		err = poller.MarkAsSeen(uint32(*email.IMAPUID))
+	case client != nil && email.GmailID != nil:
+		err = client.MarkAsRead(*email.GmailID)
+	default:
+		return fmt.Errorf("no fetcher running for account %s", email.AccountID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := m.db.MarkEmailAsRead(email.ID); err != nil {
+		return fmt.Errorf("failed to mark email as read: %w", err)
+	}
+
+	// Show it on the notification
+	if err := m.publisher.PublishEmailUpdate(email.ID); err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to publish email update")
+	}
+
+	log.Info().Str("email_id", email.ID).Msg("Marked email as read on the server")
+	return nil
+}
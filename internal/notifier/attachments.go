@@ -0,0 +1,233 @@
+package notifier
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// Telegram rejects photos larger than 10 MB, send those as documents
+	maxPhotoSize = 10 << 20
+
+	// Telegram allows at most 10 items per media group
+	maxAlbumSize = 10
+
+	fileIDCacheTTL = 30 * 24 * time.Hour
+)
+
+// sendAttachments uploads the email's attachments to the chat as a reply to
+// the notification. Attachments over the upload limit are skipped, they are
+// offered as download links by the formatter instead.
+func (nc *NotificationConsumer) sendAttachments(recipient telebot.Recipient, notification *telebot.Message, email *models.EmailMessage) {
+	attachments, err := email.ParseAttachments()
+	if err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to parse attachments")
+		return
+	}
+
+	var photos, documents []int
+	for i, attachment := range attachments {
+		if !isUploadable(attachment, nc.maxUploadSize) {
+			continue
+		}
+
+		if isPhoto(attachment) {
+			photos = append(photos, i)
+		} else {
+			documents = append(documents, i)
+		}
+	}
+
+	// A single photo goes out as a reply, several as media groups
+	if len(photos) == 1 {
+		documents = append(documents, photos[0])
+		photos = nil
+	}
+
+	for start := 0; start < len(photos); start += maxAlbumSize {
+		end := start + maxAlbumSize
+		if end > len(photos) {
+			end = len(photos)
+		}
+		nc.sendAlbum(recipient, notification, email, attachments, photos[start:end])
+	}
+
+	for _, i := range documents {
+		nc.sendAttachment(recipient, notification, email, attachments[i], i)
+	}
+}
+
+func (nc *NotificationConsumer) sendAttachment(recipient telebot.Recipient, notification *telebot.Message, email *models.EmailMessage, attachment *models.Attachment, index int) {
+	file, closer, err := nc.attachmentFile(attachment)
+	if err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Str("filename", attachment.Filename).Msg("Failed to open attachment")
+		nc.sendAttachmentLink(recipient, notification, email, attachment, index)
+		return
+	}
+	defer closer.Close()
+
+	var what interface{}
+	if isPhoto(attachment) {
+		what = &telebot.Photo{File: file}
+	} else {
+		what = &telebot.Document{
+			File:     file,
+			FileName: attachment.Filename,
+			MIME:     attachment.ContentType,
+		}
+	}
+
+	nc.waitToSend(recipient)
+	msg, err := nc.bot.Send(recipient, what, &telebot.SendOptions{
+		ReplyTo:             notification,
+		DisableNotification: true,
+	})
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("email_id", email.ID).
+			Str("filename", attachment.Filename).
+			Msg("Failed to upload attachment to Telegram")
+		nc.sendAttachmentLink(recipient, notification, email, attachment, index)
+		return
+	}
+
+	nc.cacheFileID(attachment, msg)
+}
+
+func (nc *NotificationConsumer) sendAlbum(recipient telebot.Recipient, notification *telebot.Message, email *models.EmailMessage, attachments []*models.Attachment, indexes []int) {
+	album := make(telebot.Album, 0, len(indexes))
+	sent := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		file, closer, err := nc.attachmentFile(attachments[i])
+		if err != nil {
+			log.Error().Err(err).Str("email_id", email.ID).Str("filename", attachments[i].Filename).Msg("Failed to open attachment")
+			nc.sendAttachmentLink(recipient, notification, email, attachments[i], i)
+			continue
+		}
+		defer closer.Close()
+		album = append(album, &telebot.Photo{File: file})
+		sent = append(sent, i)
+	}
+
+	if len(album) == 0 {
+		return
+	}
+
+	// Keep the album in the notification's forum topic. Replies outside
+	// forums have a thread ID too, which isn't a topic.
+	opts := &telebot.SendOptions{DisableNotification: true}
+	if notification.TopicMessage {
+		opts.ThreadID = notification.ThreadID
+	}
+	nc.waitToSend(recipient)
+	msgs, err := nc.bot.SendAlbum(recipient, album, opts)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("email_id", email.ID).
+			Int("count", len(sent)).
+			Msg("Failed to upload photo album to Telegram")
+
+		// One bad photo fails the whole group, send them one by one so
+		// only that one ends up as a download link
+		for _, i := range sent {
+			nc.sendAttachment(recipient, notification, email, attachments[i], i)
+		}
+		return
+	}
+
+	for n, i := range sent {
+		if n < len(msgs) {
+			nc.cacheFileID(attachments[i], &msgs[n])
+		}
+	}
+}
+
+// sendAttachmentLink falls back to a download button when an upload fails
+func (nc *NotificationConsumer) sendAttachmentLink(recipient telebot.Recipient, notification *telebot.Message, email *models.EmailMessage, attachment *models.Attachment, index int) {
+	keyboard := &telebot.ReplyMarkup{}
+	keyboard.Inline(keyboard.Row(keyboard.URL("⬇️ Download", nc.formatter.AttachmentURL(email.ID, index))))
+
+	text := fmt.Sprintf("📎 %s could not be uploaded.", attachment.Filename)
+	nc.waitToSend(recipient)
+	if _, err := nc.bot.Send(recipient, text, &telebot.SendOptions{
+		ReplyTo:             notification,
+		ReplyMarkup:         keyboard,
+		DisableNotification: true,
+	}); err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to send attachment link")
+	}
+}
+
+// attachmentFile reuses a cached Telegram file_id when the file was uploaded
+// before, otherwise it streams the blob. The closer must always be closed.
+func (nc *NotificationConsumer) attachmentFile(attachment *models.Attachment) (telebot.File, io.Closer, error) {
+	if fileID, err := nc.redis.Get(fileIDCacheKey(attachment)); err == nil && fileID != "" {
+		return telebot.File{FileID: fileID}, io.NopCloser(nil), nil
+	}
+
+	obj, _, err := nc.blobs.Open(attachment.Path)
+	if err != nil {
+		return telebot.File{}, nil, err
+	}
+	return telebot.FromReader(obj), obj, nil
+}
+
+func (nc *NotificationConsumer) cacheFileID(attachment *models.Attachment, msg *telebot.Message) {
+	var fileID string
+	switch {
+	case msg.Photo != nil:
+		fileID = msg.Photo.FileID
+	case msg.Document != nil:
+		fileID = msg.Document.FileID
+	}
+
+	if fileID == "" {
+		return
+	}
+
+	if err := nc.redis.Set(fileIDCacheKey(attachment), fileID, fileIDCacheTTL); err != nil {
+		log.Warn().Err(err).Str("filename", attachment.Filename).Msg("Failed to cache Telegram file_id")
+	}
+}
+
+// fileIDCacheKey keys on content, and on the media kind since Telegram
+// file_ids of photos can't be reused as documents
+func fileIDCacheKey(attachment *models.Attachment) string {
+	kind := "document"
+	if isPhoto(attachment) {
+		kind = "photo"
+	}
+
+	if attachment.SHA256 == "" {
+		return fmt.Sprintf("mail-to-tg:tgfile:%s:%s", kind, attachment.Path)
+	}
+	return fmt.Sprintf("mail-to-tg:tgfile:%s:%s", kind, attachment.SHA256)
+}
+
+// isUploadable reports whether an attachment is sent into the chat. Quarantined
+// files are only offered through the download page, which warns first.
+func isUploadable(attachment *models.Attachment, maxUploadSize int64) bool {
+	return attachment.Path != "" &&
+		attachment.Safety == "" &&
+		attachment.Size <= maxUploadSize
+}
+
+func isPhoto(attachment *models.Attachment) bool {
+	if attachment.Size > maxPhotoSize {
+		return false
+	}
+
+	switch strings.ToLower(attachment.ContentType) {
+	case "image/jpeg", "image/png", "image/webp":
+		return true
+	}
+	return false
+}
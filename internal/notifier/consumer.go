@@ -3,69 +3,136 @@ package notifier
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/blobstore"
 	"github.com/kexi/mail-to-tg/internal/queue"
+	"github.com/kexi/mail-to-tg/internal/rules"
+	"github.com/kexi/mail-to-tg/internal/smtp"
 	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/crypto"
 	"github.com/kexi/mail-to-tg/pkg/llm"
 	"github.com/kexi/mail-to-tg/pkg/models"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/telebot.v3"
 )
 
+const (
+	// Events each worker queues before reading from the stream pauses
+	workerBacklog = 4
+
+	// Held while an email is being notified, longer than a summary and
+	// the sends can take
+	notifyLockKey = "mail-to-tg:notify-lock:"
+	notifyLockTTL = 5 * time.Minute
+)
+
 type NotificationConsumer struct {
-	consumer   *queue.Consumer
-	db         *storage.MariaDB
-	redis      *storage.Redis
-	bot        *telebot.Bot
-	formatter  *Formatter
-	llmClient  llm.Client
-	llmTimeout time.Duration
-	cacheTTL   time.Duration
+	consumer      *queue.Consumer
+	updates       *queue.UpdateConsumer
+	publisher     *queue.Publisher
+	mailer        *smtp.Client
+	db            *storage.MariaDB
+	redis         *storage.Redis
+	bot           *telebot.Bot
+	blobs         blobstore.Store
+	formatter     *Formatter
+	workers       *workerPool
+	limiter       *rateLimiter
+	maxUploadSize int64
+	llmClient     llm.Client
+	llmTimeout    time.Duration
+	cacheTTL      time.Duration
 }
 
 func NewNotificationConsumer(
 	redis *storage.Redis,
 	db *storage.MariaDB,
 	bot *telebot.Bot,
+	blobs blobstore.Store,
 	baseURL string,
+	signer *crypto.Signer,
+	attachmentLinkTTL time.Duration,
+	maxUploadSize int64,
 	llmClient llm.Client,
 	llmTimeout time.Duration,
 	cacheTTL time.Duration,
+	queueCfg *config.QueueConfig,
+	telegramCfg *config.TelegramConfig,
+	mailer *smtp.Client,
 ) *NotificationConsumer {
-	formatter := NewFormatter(baseURL)
+	formatter := NewFormatter(baseURL, signer, attachmentLinkTTL, maxUploadSize)
 
 	nc := &NotificationConsumer{
-		db:         db,
-		redis:      redis,
-		bot:        bot,
-		formatter:  formatter,
-		llmClient:  llmClient,
-		llmTimeout: llmTimeout,
-		cacheTTL:   cacheTTL,
+		publisher:     queue.NewPublisher(redis),
+		mailer:        mailer,
+		db:            db,
+		redis:         redis,
+		bot:           bot,
+		blobs:         blobs,
+		formatter:     formatter,
+		workers:       newWorkerPool(queueCfg.Workers, workerBacklog),
+		limiter:       newRateLimiter(telegramCfg),
+		maxUploadSize: maxUploadSize,
+		llmClient:     llmClient,
+		llmTimeout:    llmTimeout,
+		cacheTTL:      cacheTTL,
 	}
 
-	consumer := queue.NewConsumer(redis, nc.handleEmailEvent)
+	consumer := queue.NewConsumer(redis, queueCfg, nc.handleEmailEvent)
+	consumer.SetDispatch(nc.dispatch)
 	nc.consumer = consumer
+	nc.updates = queue.NewUpdateConsumer(redis, nc.handleEmailUpdate)
 
 	return nc
 }
 
 func (nc *NotificationConsumer) Start() error {
-	return nc.consumer.Start()
+	// Edits of sent notifications
+	go func() {
+		if err := nc.updates.Start(); err != nil {
+			log.Error().Err(err).Msg("Email update consumer stopped")
+		}
+	}()
+
+	err := nc.consumer.Start()
+	nc.workers.Stop()
+	return err
 }
 
 func (nc *NotificationConsumer) Stop() {
+	nc.updates.Stop()
 	nc.consumer.Stop()
 }
 
+// dispatch hands events to the workers by user, so a slow summary only
+// holds up that user's mail and each mailbox's messages stay in order.
+// High priority mail goes ahead of the user's mail already waiting.
+func (nc *NotificationConsumer) dispatch(event *queue.EmailEvent, run func()) {
+	nc.workers.Submit(event.UserID, event.Priority == models.PriorityHigh, run)
+}
+
 func (nc *NotificationConsumer) handleEmailEvent(event *queue.EmailEvent) error {
 	log.Debug().
 		Str("email_id", event.EmailID).
 		Str("user_id", event.UserID).
 		Msg("Handling email notification event")
 
+	// The live consumer, retries and the sweeper may all carry this email
+	locked, unlock, err := nc.lockEmail(event.EmailID)
+	if err != nil {
+		return fmt.Errorf("failed to lock email: %w", err)
+	}
+	if !locked {
+		log.Debug().Str("email_id", event.EmailID).Msg("Email is being notified by another worker")
+		return nil
+	}
+	defer unlock()
+
 	// Get email
 	email, err := nc.db.GetEmailMessageByID(event.EmailID)
 	if err != nil || email == nil {
@@ -73,6 +140,12 @@ func (nc *NotificationConsumer) handleEmailEvent(event *queue.EmailEvent) error
 		return err
 	}
 
+	// Events can be delivered again after a crash, don't notify twice
+	if email.IsNotified {
+		log.Debug().Str("email_id", email.ID).Msg("Email already notified")
+		return nil
+	}
+
 	// Get user
 	user, err := nc.db.GetUserByID(event.UserID)
 	if err != nil || user == nil {
@@ -80,20 +153,107 @@ func (nc *NotificationConsumer) handleEmailEvent(event *queue.EmailEvent) error
 		return err
 	}
 
-	// Generate AI summary if LLM is enabled
+	// Dead-lettered, and replayed once the user talks to the bot again
+	if !user.IsActive {
+		return queue.Permanent(errDeliveryDisabled)
+	}
+
+	// Get account, for its delivery target
+	account, err := nc.db.GetEmailAccountByID(email.AccountID)
+	if err != nil || account == nil {
+		log.Error().Err(err).Str("account_id", email.AccountID).Msg("Failed to get account")
+		return err
+	}
+	nc.verifyDelivery(user, account)
+
+	// Mail from lists the user unsubscribed from is kept, but not notified
+	muted, err := nc.db.IsListMuted(user.ID, email.ListKey())
+	if err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to check muted lists")
+	} else if muted {
+		log.Info().Str("email_id", email.ID).Str("list", email.ListKey()).Msg("Skipping notification for muted list")
+		if err := nc.db.MarkEmailAsNotified(email.ID); err != nil {
+			log.Error().Err(err).Msg("Failed to mark email as notified")
+		}
+		return nil
+	}
+
+	// Rules on the AI category wait for the summary, the others run first
+	// so skipped mail doesn't cost a summary
+	userRules := nc.loadRules(user.ID)
+	waitForCategory := nc.llmClient != nil && rules.UsesCategory(userRules)
+
+	var outcome *rules.Outcome
+	if !waitForCategory {
+		outcome = rules.Evaluate(userRules, email, "")
+		if outcome != nil && outcome.Skip {
+			return nc.skip(email, account, outcome)
+		}
+	}
+
+	// Generate AI summary if LLM is enabled. One that times out is
+	// finished in the background and added to the notification.
+	summaryLate := false
 	if nc.llmClient != nil {
-		nc.generateAISummary(email)
+		err := nc.generateAISummary(email, nc.llmTimeout)
+		summaryLate = errors.Is(err, llm.ErrTimeout)
+	}
+
+	if waitForCategory {
+		outcome = rules.Evaluate(userRules, email, emailCategory(email))
+		if outcome != nil && outcome.Skip {
+			return nc.skip(email, account, outcome)
+		}
+	}
+	tag(email, outcome)
+
+	// Low-priority mail, and mail a rule picks, waits for the digest
+	if wantsDigest(user, account, email, outcome) {
+		if summaryLate {
+			go nc.lateSummary(email.ID)
+		}
+		return nc.collect(user, email, account, outcome)
 	}
 
 	// Format notification message
-	message, keyboard := nc.formatter.FormatEmailNotification(email)
+	message, keyboard := nc.formatter.FormatEmailNotification(email, user.Location())
 
-	// Send to Telegram
-	recipient := &telebot.User{ID: user.TelegramID}
-	_, err = nc.bot.Send(recipient, message, &telebot.SendOptions{
+	// Send to Telegram: the user, the account's chat or where a rule routes it
+	opts := &telebot.SendOptions{
 		ParseMode:   telebot.ModeHTML,
 		ReplyMarkup: keyboard,
-	})
+	}
+	recipient := target(user, account, outcome, opts)
+	nc.replyInThread(email, recipient, opts)
+	nc.waitToSend(recipient)
+	sent, err := nc.bot.Send(recipient, message, opts)
+
+	// The account's forum topic was deleted, open a new one
+	routed := outcome != nil && outcome.ChatID != 0
+	if err != nil && !routed && opts.ThreadID != 0 && isTopicGone(err) {
+		if threadID := nc.recreateTopic(account); threadID != 0 {
+			opts.ThreadID = threadID
+			opts.ReplyTo = nil
+			nc.waitToSend(recipient)
+			sent, err = nc.bot.Send(recipient, message, opts)
+		}
+	}
+
+	// A chat the bot can't post to anymore falls back to the user's own
+	if err != nil && !isPrivate(recipient) && isPermanent(classifySendError(err)) {
+		log.Warn().
+			Err(err).
+			Str("email_id", email.ID).
+			Str("chat", recipient.Recipient()).
+			Msg("Failed to send to chat, sending to the user")
+
+		recipient = &telebot.User{ID: user.TelegramID}
+		opts.ThreadID = 0
+		opts.ReplyTo = nil
+		nc.replyInThread(email, recipient, opts)
+		nc.waitToSend(recipient)
+		sent, err = nc.bot.Send(recipient, message, opts)
+	}
 
 	if err != nil {
 		log.Error().
@@ -101,9 +261,25 @@ func (nc *NotificationConsumer) handleEmailEvent(event *queue.EmailEvent) error
 			Str("email_id", email.ID).
 			Int64("telegram_id", user.TelegramID).
 			Msg("Failed to send Telegram notification")
-		return err
+
+		if isUnreachable(err) {
+			nc.deactivateUser(user)
+		}
+		return classifySendError(err)
+	}
+
+	nc.track(email, sent, opts.ThreadID)
+	if summaryLate {
+		go nc.lateSummary(email.ID)
+	}
+
+	// Upload attachments into the chat
+	if nc.maxUploadSize > 0 && email.HasAttachments {
+		nc.sendAttachments(recipient, sent, email)
 	}
 
+	nc.applyRules(email, account, outcome)
+
 	// Mark as notified
 	if err := nc.db.MarkEmailAsNotified(email.ID); err != nil {
 		log.Error().Err(err).Msg("Failed to mark email as notified")
@@ -117,7 +293,56 @@ func (nc *NotificationConsumer) handleEmailEvent(event *queue.EmailEvent) error
 	return nil
 }
 
-func (nc *NotificationConsumer) generateAISummary(email *models.EmailMessage) {
+// skip handles mail a rule keeps from being notified
+func (nc *NotificationConsumer) skip(email *models.EmailMessage, account *models.EmailAccount, outcome *rules.Outcome) error {
+	log.Info().Str("email_id", email.ID).Strs("rules", outcome.Matched).Msg("Skipping notification by rule")
+
+	tag(email, outcome)
+	nc.applyRules(email, account, outcome)
+
+	if err := nc.db.MarkEmailAsNotified(email.ID); err != nil {
+		log.Error().Err(err).Msg("Failed to mark email as notified")
+	}
+	return nil
+}
+
+// lockEmail takes the lock for notifying an email, held until unlock is
+// called or notifyLockTTL passes
+func (nc *NotificationConsumer) lockEmail(emailID string) (bool, func(), error) {
+	key := notifyLockKey + emailID
+	owner := uuid.New().String()
+
+	locked, err := nc.redis.SetNX(key, owner, notifyLockTTL)
+	if err != nil || !locked {
+		return false, nil, err
+	}
+
+	unlock := func() {
+		if err := nc.redis.DelIfEqual(key, owner); err != nil {
+			log.Error().Err(err).Str("email_id", emailID).Msg("Failed to release notify lock")
+		}
+	}
+	return true, unlock, nil
+}
+
+// deactivateUser stops delivery to a user who blocked the bot or whose
+// chat is gone; it is turned back on when they use the bot again
+func (nc *NotificationConsumer) deactivateUser(user *models.User) {
+	user.IsActive = false
+	if err := nc.db.UpdateUser(user); err != nil {
+		log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to deactivate user")
+		return
+	}
+
+	log.Warn().
+		Str("user_id", user.ID).
+		Int64("telegram_id", user.TelegramID).
+		Msg("Deactivated delivery for unreachable user")
+}
+
+// generateAISummary summarizes the email, returning llm.ErrTimeout when
+// the model took longer than timeout
+func (nc *NotificationConsumer) generateAISummary(email *models.EmailMessage, timeout time.Duration) error {
 	// Check Redis cache first
 	cacheKey := fmt.Sprintf("llm:summary:%s", email.ID)
 	cached, err := nc.redis.Get(cacheKey)
@@ -134,12 +359,12 @@ func (nc *NotificationConsumer) generateAISummary(email *models.EmailMessage) {
 			log.Debug().
 				Str("email_id", email.ID).
 				Msg("Using cached LLM summary")
-			return
+			return nil
 		}
 	}
 
 	// Call LLM API with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), nc.llmTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	summary, err := nc.llmClient.Summarize(ctx, email)
@@ -153,7 +378,11 @@ func (nc *NotificationConsumer) generateAISummary(email *models.EmailMessage) {
 
 		// Store error in database
 		nc.db.UpdateEmailSummary(email.ID, nil, nil, nil, &errStr)
-		return
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return llm.ErrTimeout
+		}
+		return err
 	}
 
 	// Cache and store summary
@@ -175,4 +404,6 @@ func (nc *NotificationConsumer) generateAISummary(email *models.EmailMessage) {
 		Int("input_tokens", summary.InputTokens).
 		Int("output_tokens", summary.OutputTokens).
 		Msg("LLM summarization completed")
+
+	return nil
 }
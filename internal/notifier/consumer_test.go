@@ -0,0 +1,51 @@
+package notifier
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/kexi/mail-to-tg/pkg/config"
+)
+
+func TestLockEmail(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redis, err := storage.NewRedis(&config.RedisConfig{Host: mr.Host(), Port: mr.Server().Addr().Port})
+	if err != nil {
+		t.Fatalf("NewRedis: %v", err)
+	}
+	defer redis.Close()
+
+	nc := &NotificationConsumer{redis: redis}
+
+	locked, unlock, err := nc.lockEmail("e1")
+	if err != nil || !locked {
+		t.Fatalf("first lockEmail = %v, %v", locked, err)
+	}
+
+	if again, _, _ := nc.lockEmail("e1"); again {
+		t.Fatal("email locked twice")
+	}
+	if other, unlockOther, _ := nc.lockEmail("e2"); !other {
+		t.Fatal("lock on e1 blocked e2")
+	} else {
+		unlockOther()
+	}
+
+	// An expired lock taken over by someone else isn't released by the old owner
+	mr.Set(notifyLockKey+"e1", "someone-else")
+	unlock()
+	if !mr.Exists(notifyLockKey + "e1") {
+		t.Fatal("released a lock owned by someone else")
+	}
+
+	mr.Del(notifyLockKey + "e1")
+	locked, unlock, _ = nc.lockEmail("e1")
+	if !locked {
+		t.Fatal("lock not available after release")
+	}
+	unlock()
+	if mr.Exists(notifyLockKey + "e1") {
+		t.Error("unlock left the lock in place")
+	}
+}
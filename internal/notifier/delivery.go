@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kexi/mail-to-tg/internal/rules"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+// How long the owner's admin rights in a delivery chat are trusted before
+// they are checked again
+const deliveryVerifyInterval = 24 * time.Hour
+
+// target picks the chat a notification goes to: the chat and topic a rule
+// routes to, else the account's delivery target, else the owner's private
+// chat. It sets the topic and silence on opts.
+func target(user *models.User, account *models.EmailAccount, outcome *rules.Outcome, opts *telebot.SendOptions) telebot.Recipient {
+	if outcome != nil {
+		opts.DisableNotification = outcome.Silent
+		if outcome.ChatID != 0 {
+			opts.ThreadID = outcome.TopicID
+			return &telebot.Chat{ID: outcome.ChatID}
+		}
+	}
+
+	if account != nil && account.DeliveryChatID != nil {
+		if account.DeliveryThreadID != nil {
+			opts.ThreadID = *account.DeliveryThreadID
+		}
+		return &telebot.Chat{ID: *account.DeliveryChatID}
+	}
+
+	return &telebot.User{ID: user.TelegramID}
+}
+
+// isPrivate reports whether a notification goes to the owner's own chat
+func isPrivate(recipient telebot.Recipient) bool {
+	_, ok := recipient.(*telebot.User)
+	return ok
+}
+
+// isTopicGone reports whether a send failed because its forum topic was
+// deleted
+func isTopicGone(err error) bool {
+	return strings.Contains(err.Error(), "message thread not found")
+}
+
+// recreateTopic opens a new forum topic for an account whose topic was
+// deleted, returning 0 when that isn't possible
+func (nc *NotificationConsumer) recreateTopic(account *models.EmailAccount) int {
+	chat := &telebot.Chat{ID: *account.DeliveryChatID}
+	topic, err := nc.bot.CreateTopic(chat, &telebot.Topic{Name: account.EmailAddress})
+	if err != nil {
+		log.Error().Err(err).Str("account_id", account.ID).Int64("chat_id", chat.ID).Msg("Failed to recreate forum topic")
+		return 0
+	}
+
+	if err := nc.db.SetAccountDeliveryTopic(account.ID, topic.ThreadID); err != nil {
+		log.Error().Err(err).Str("account_id", account.ID).Msg("Failed to save recreated forum topic")
+	}
+	account.DeliveryThreadID = &topic.ThreadID
+
+	log.Info().
+		Str("account_id", account.ID).
+		Int64("chat_id", chat.ID).
+		Int("thread_id", topic.ThreadID).
+		Msg("Recreated deleted forum topic")
+	return topic.ThreadID
+}
+
+// verifyDelivery checks again, once the last check is older than
+// deliveryVerifyInterval, that the owner still administers the account's
+// delivery chat. Otherwise the account goes back to the private chat.
+func (nc *NotificationConsumer) verifyDelivery(user *models.User, account *models.EmailAccount) {
+	if account.DeliveryChatID == nil {
+		return
+	}
+	if account.DeliveryVerifiedAt != nil && time.Since(*account.DeliveryVerifiedAt) < deliveryVerifyInterval {
+		return
+	}
+
+	chat := &telebot.Chat{ID: *account.DeliveryChatID}
+	member, err := nc.bot.ChatMemberOf(chat, &telebot.User{ID: user.TelegramID})
+	if err != nil {
+		// Sending falls back to the private chat if the bot lost the chat
+		log.Warn().Err(err).Str("account_id", account.ID).Int64("chat_id", chat.ID).Msg("Failed to check delivery chat admin")
+		return
+	}
+
+	if member.Role == telebot.Creator || member.Role == telebot.Administrator {
+		if err := nc.db.MarkAccountDeliveryVerified(account.ID); err != nil {
+			log.Error().Err(err).Str("account_id", account.ID).Msg("Failed to save delivery verification")
+		}
+		now := time.Now()
+		account.DeliveryVerifiedAt = &now
+		return
+	}
+
+	if err := nc.db.SetAccountDeliveryTarget(account.ID, nil, nil); err != nil {
+		log.Error().Err(err).Str("account_id", account.ID).Msg("Failed to reset delivery target")
+		return
+	}
+	account.DeliveryChatID = nil
+	account.DeliveryThreadID = nil
+	account.DeliveryVerifiedAt = nil
+
+	log.Warn().
+		Str("account_id", account.ID).
+		Int64("chat_id", chat.ID).
+		Msg("Owner no longer administers the delivery chat, delivering privately")
+
+	recipient := &telebot.User{ID: user.TelegramID}
+	text := fmt.Sprintf("You are no longer an administrator of chat %d, notifications for %s come here again. Use /deliver to pick another chat.", chat.ID, account.EmailAddress)
+	nc.waitToSend(recipient)
+	if _, err := nc.bot.Send(recipient, text); err != nil {
+		log.Error().Err(err).Str("account_id", account.ID).Msg("Failed to tell the owner about the delivery target")
+	}
+}
@@ -0,0 +1,42 @@
+package notifier
+
+import (
+	"testing"
+
+	"github.com/kexi/mail-to-tg/internal/rules"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"gopkg.in/telebot.v3"
+)
+
+func TestTarget(t *testing.T) {
+	user := &models.User{TelegramID: 42}
+	groupID, threadID := int64(-100500), 7
+	account := &models.EmailAccount{DeliveryChatID: &groupID, DeliveryThreadID: &threadID}
+
+	tests := []struct {
+		name      string
+		account   *models.EmailAccount
+		outcome   *rules.Outcome
+		want      string
+		wantTopic int
+	}{
+		{"private chat", &models.EmailAccount{}, nil, "42", 0},
+		{"account target", account, nil, "-100500", 7},
+		{"rule without route", account, &rules.Outcome{RuleActions: models.RuleActions{Silent: true}}, "-100500", 7},
+		{"rule route wins", account, &rules.Outcome{RuleActions: models.RuleActions{ChatID: -100900, TopicID: 3}}, "-100900", 3},
+		{"rule route to general", account, &rules.Outcome{RuleActions: models.RuleActions{ChatID: -100900}}, "-100900", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &telebot.SendOptions{}
+			recipient := target(user, tt.account, tt.outcome, opts)
+			if recipient.Recipient() != tt.want || opts.ThreadID != tt.wantTopic {
+				t.Errorf("target = %s topic %d, want %s topic %d", recipient.Recipient(), opts.ThreadID, tt.want, tt.wantTopic)
+			}
+			if isPrivate(recipient) != (tt.want == "42") {
+				t.Errorf("isPrivate = %v", isPrivate(recipient))
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package notifier
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/rules"
+	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// How often due digests are looked for
+	digestInterval = time.Minute
+
+	// Entries per digest message, each has a row of buttons
+	digestPageSize = 10
+
+	// Held while a user's digest is sent, so instances don't both send it
+	digestLockKey = "mail-to-tg:digest-lock:"
+	digestLockTTL = 5 * time.Minute
+)
+
+// wantsDigest reports whether an email waits for the user's digest: a
+// rule asks for it, or it is low-priority and the user has digests on.
+// Digests go to the user's private chat, so mail delivered to a group or
+// routed to a chat is never held for one.
+func wantsDigest(user *models.User, account *models.EmailAccount, email *models.EmailMessage, outcome *rules.Outcome) bool {
+	if account.DeliveryChatID != nil || (outcome != nil && outcome.ChatID != 0) {
+		return false
+	}
+	if outcome != nil && outcome.Digest {
+		return true
+	}
+	return email.Priority == models.PriorityLow && user.DigestSchedule != "" && user.DigestSchedule != models.DigestOff
+}
+
+// collect puts an email in the user's digest instead of notifying it. The
+// digest lives in the database, so a restart loses nothing.
+func (nc *NotificationConsumer) collect(user *models.User, email *models.EmailMessage, account *models.EmailAccount, outcome *rules.Outcome) error {
+	item := &models.DigestItem{
+		ID:      uuid.New().String(),
+		UserID:  user.ID,
+		EmailID: email.ID,
+	}
+	if err := nc.db.AddDigestItem(item); err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to add email to digest")
+		return err
+	}
+
+	nc.applyRules(email, account, outcome)
+
+	if err := nc.db.MarkEmailAsNotified(email.ID); err != nil {
+		log.Error().Err(err).Msg("Failed to mark email as notified")
+	}
+
+	log.Info().Str("email_id", email.ID).Str("user_id", user.ID).Msg("Collected email for digest")
+	return nil
+}
+
+// Digester sends the digests that are due
+type Digester struct {
+	nc      *NotificationConsumer
+	redis   *storage.Redis
+	stopped bool
+}
+
+func NewDigester(nc *NotificationConsumer, redis *storage.Redis) *Digester {
+	return &Digester{nc: nc, redis: redis}
+}
+
+func (d *Digester) Start() error {
+	log.Info().Dur("interval", digestInterval).Msg("Starting digest sender")
+
+	ticker := time.NewTicker(digestInterval)
+	defer ticker.Stop()
+
+	for !d.stopped {
+		<-ticker.C
+		d.sendDue()
+	}
+
+	return nil
+}
+
+func (d *Digester) Stop() {
+	log.Info().Msg("Stopping digest sender")
+	d.stopped = true
+}
+
+func (d *Digester) sendDue() {
+	pending, err := d.nc.db.GetPendingDigests()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load pending digests")
+		return
+	}
+
+	now := time.Now()
+	for _, p := range pending {
+		user, err := d.nc.db.GetUserByID(p.UserID)
+		if err != nil || user == nil {
+			log.Error().Err(err).Str("user_id", p.UserID).Msg("Failed to get user")
+			continue
+		}
+
+		// Kept until the user talks to the bot again
+		if !user.IsActive || user.NextDigest(p.Since).After(now) {
+			continue
+		}
+
+		locked, err := d.redis.SetNX(digestLockKey+user.ID, "1", digestLockTTL)
+		if err != nil {
+			log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to take digest lock")
+			continue
+		}
+		if !locked {
+			continue
+		}
+
+		d.nc.sendDigest(user)
+		d.redis.Del(digestLockKey + user.ID)
+	}
+}
+
+// sendDigest sends the mail collected for a user, a page at a time. Each
+// page leaves the digest once sent, so a failure only repeats that page.
+func (nc *NotificationConsumer) sendDigest(user *models.User) {
+	emails, err := nc.db.GetDigestEmails(user.ID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to load digest")
+		return
+	}
+
+	// Mail removed from INBOX meanwhile is left out
+	var entries []*models.EmailMessage
+	var deleted []string
+	for _, email := range emails {
+		if email.IsDeleted {
+			deleted = append(deleted, email.ID)
+			continue
+		}
+		entries = append(entries, email)
+	}
+	if err := nc.db.DeleteDigestItems(user.ID, deleted); err != nil {
+		log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to drop deleted mail from digest")
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	recipient := &telebot.User{ID: user.TelegramID}
+	for first := 0; first < len(entries); first += digestPageSize {
+		page := entries[first:min(first+digestPageSize, len(entries))]
+		message, keyboard := nc.formatter.FormatDigest(page, first, len(entries), user.Location())
+
+		nc.waitToSend(recipient)
+		_, err := nc.bot.Send(recipient, message, &telebot.SendOptions{
+			ParseMode:   telebot.ModeHTML,
+			ReplyMarkup: keyboard,
+		})
+		if err != nil {
+			log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to send digest")
+			if isUnreachable(err) {
+				nc.deactivateUser(user)
+			}
+			return
+		}
+
+		ids := make([]string, 0, len(page))
+		for _, email := range page {
+			ids = append(ids, email.ID)
+		}
+		if err := nc.db.DeleteDigestItems(user.ID, ids); err != nil {
+			log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to clear sent digest")
+			return
+		}
+	}
+
+	log.Info().Str("user_id", user.ID).Int("emails", len(entries)).Msg("Sent digest")
+}
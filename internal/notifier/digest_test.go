@@ -0,0 +1,66 @@
+package notifier
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kexi/mail-to-tg/internal/rules"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+func TestWantsDigest(t *testing.T) {
+	daily := &models.User{DigestSchedule: models.DigestDaily}
+	off := &models.User{DigestSchedule: models.DigestOff}
+	low := &models.EmailMessage{Priority: models.PriorityLow}
+	normal := &models.EmailMessage{Priority: models.PriorityNormal}
+	byRule := &rules.Outcome{RuleActions: models.RuleActions{Digest: true}}
+	routed := &rules.Outcome{RuleActions: models.RuleActions{Digest: true, ChatID: -100900}}
+	private := &models.EmailAccount{}
+	groupID := int64(-100500)
+	shared := &models.EmailAccount{DeliveryChatID: &groupID}
+
+	tests := []struct {
+		name    string
+		user    *models.User
+		account *models.EmailAccount
+		email   *models.EmailMessage
+		outcome *rules.Outcome
+		want    bool
+	}{
+		{"low priority with digests on", daily, private, low, nil, true},
+		{"low priority with digests off", off, private, low, nil, false},
+		{"normal priority", daily, private, normal, nil, false},
+		{"rule with digests off", off, private, normal, byRule, true},
+		{"account delivered to a group", daily, shared, low, byRule, false},
+		{"rule routes to a chat", daily, private, low, routed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wantsDigest(tt.user, tt.account, tt.email, tt.outcome); got != tt.want {
+				t.Errorf("wantsDigest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatDigest(t *testing.T) {
+	subject, summary := "Weekly <news>", "Three new posts this week"
+	emails := []*models.EmailMessage{
+		{ID: "a", FromAddress: "news@example.com", Subject: &subject, AISummary: &summary, Date: time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)},
+		{ID: "b", FromAddress: "shop@example.com", Date: time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)},
+	}
+
+	f := NewFormatter("https://mail.example.com", nil, time.Hour, 0)
+	message, keyboard := f.FormatDigest(emails, 10, 12, time.UTC)
+
+	for _, want := range []string{"11–12 of 12", "<b>11. Weekly &lt;news&gt;</b>", summary, "<b>12. No subject</b>"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("digest %q lacks %q", message, want)
+		}
+	}
+	if len(keyboard.InlineKeyboard) != 2 || keyboard.InlineKeyboard[1][0].Unique != "view_b" {
+		t.Errorf("keyboard = %+v", keyboard.InlineKeyboard)
+	}
+}
@@ -0,0 +1,111 @@
+package notifier
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kexi/mail-to-tg/internal/queue"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+// How long a summary that timed out during delivery gets in the background
+const lateSummaryTimeout = 2 * time.Minute
+
+// track records a sent notification so it can be edited later. topicID is
+// the forum topic it was sent to; replies in other groups carry a thread
+// ID of their own that isn't a topic.
+func (nc *NotificationConsumer) track(email *models.EmailMessage, sent *telebot.Message, topicID int) {
+	msg := &models.TelegramMessage{
+		ID:        uuid.New().String(),
+		EmailID:   email.ID,
+		ChatID:    sent.Chat.ID,
+		MessageID: sent.ID,
+	}
+	if topicID != 0 {
+		msg.ThreadID = &topicID
+	}
+
+	if err := nc.db.SaveTelegramMessage(msg); err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to save Telegram message")
+	}
+}
+
+func (nc *NotificationConsumer) handleEmailUpdate(update *queue.EmailUpdate) error {
+	return nc.refresh(update.EmailID)
+}
+
+// refresh edits the notifications of an email to show its current state
+func (nc *NotificationConsumer) refresh(emailID string) error {
+	messages, err := nc.db.GetTelegramMessages(emailID)
+	if err != nil || len(messages) == 0 {
+		return err
+	}
+
+	email, err := nc.db.GetEmailMessageByID(emailID)
+	if err != nil || email == nil {
+		return err
+	}
+
+	account, err := nc.db.GetEmailAccountByID(email.AccountID)
+	if err != nil || account == nil {
+		return err
+	}
+
+	user, err := nc.db.GetUserByID(account.UserID)
+	if err != nil || user == nil {
+		return err
+	}
+
+	message, keyboard := nc.formatter.FormatEmailNotification(email, user.Location())
+
+	for _, msg := range messages {
+		chat := &telebot.Chat{ID: msg.ChatID}
+		stored := &telebot.StoredMessage{ChatID: msg.ChatID, MessageID: strconv.Itoa(msg.MessageID)}
+
+		nc.waitToSend(chat)
+		_, err := nc.bot.Edit(stored, message, &telebot.SendOptions{
+			ParseMode:   telebot.ModeHTML,
+			ReplyMarkup: keyboard,
+		})
+		if err != nil && !isNotModified(err) {
+			log.Error().
+				Err(err).
+				Str("email_id", emailID).
+				Int64("chat_id", msg.ChatID).
+				Int("message_id", msg.MessageID).
+				Msg("Failed to edit notification")
+			continue
+		}
+	}
+
+	log.Debug().Str("email_id", emailID).Int("messages", len(messages)).Msg("Refreshed notifications")
+	return nil
+}
+
+// lateSummary gives a summary that timed out during delivery more time and
+// adds it to the notification
+func (nc *NotificationConsumer) lateSummary(emailID string) {
+	email, err := nc.db.GetEmailMessageByID(emailID)
+	if err != nil || email == nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to get email for late summary")
+		return
+	}
+
+	if err := nc.generateAISummary(email, lateSummaryTimeout); err != nil {
+		return
+	}
+
+	if err := nc.refresh(emailID); err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to add late summary")
+	}
+}
+
+// isNotModified reports an edit that would change nothing, as when the
+// same update arrives twice
+func isNotModified(err error) bool {
+	return errors.Is(err, telebot.ErrSameMessageContent) || errors.Is(err, telebot.ErrMessageNotModified)
+}
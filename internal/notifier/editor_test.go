@@ -0,0 +1,41 @@
+package notifier
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+func TestFormatHeader(t *testing.T) {
+	replied := time.Date(2026, 3, 2, 14, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		email   *models.EmailMessage
+		want    []string
+		notWant []string
+	}{
+		{"new", &models.EmailMessage{}, []string{"📧 New Email"}, []string{"Removed", "✅ Read", "Replied"}},
+		{"read", &models.EmailMessage{IsRead: true}, []string{"📧 New Email", "✅ Read"}, []string{"Replied"}},
+		{"replied", &models.EmailMessage{RepliedAt: &replied}, []string{"↩️ Replied Mon 02 Mar 14:30"}, []string{"✅ Read"}},
+		{"deleted", &models.EmailMessage{IsDeleted: true, IsRead: true}, []string{"🗑 Removed from INBOX", "✅ Read"}, []string{"New Email"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := formatHeader(tt.email, time.UTC)
+			for _, want := range tt.want {
+				if !strings.Contains(header, want) {
+					t.Errorf("header %q lacks %q", header, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(header, notWant) {
+					t.Errorf("header %q has %q", header, notWant)
+				}
+			}
+		})
+	}
+}
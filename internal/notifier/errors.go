@@ -0,0 +1,73 @@
+package notifier
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/kexi/mail-to-tg/internal/queue"
+	"gopkg.in/telebot.v3"
+)
+
+// errDeliveryDisabled is returned for users whose chat can't be reached
+// anymore, until they talk to the bot again
+var errDeliveryDisabled = errors.New("delivery disabled for user")
+
+// Errors after which the user can't receive anything from the bot
+var unreachableErrors = []error{
+	telebot.ErrBlockedByUser,
+	telebot.ErrUserIsDeactivated,
+	telebot.ErrNotStartedByUser,
+	telebot.ErrChatNotFound,
+	telebot.ErrKickedFromGroup,
+	telebot.ErrKickedFromSuperGroup,
+	telebot.ErrKickedFromChannel,
+}
+
+// telebot reports errors it has no sentinel for as "telegram: <description> (<code>)"
+var errorCodePattern = regexp.MustCompile(`\((\d{3})\)$`)
+
+// isUnreachable reports whether err means the user's chat is gone
+func isUnreachable(err error) bool {
+	for _, target := range unreachableErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPermanent(err error) bool {
+	var permanent *queue.PermanentError
+	return errors.As(err, &permanent)
+}
+
+// classifySendError tells the queue how to handle a failed Telegram send:
+// flood waits are retried after retry_after, other client errors are
+// permanent and everything else is retried with backoff
+func classifySendError(err error) error {
+	var flood telebot.FloodError
+	if errors.As(err, &flood) {
+		return queue.RetryAfter(err, time.Duration(flood.RetryAfter)*time.Second)
+	}
+
+	if isUnreachable(err) {
+		return queue.Permanent(err)
+	}
+
+	code := 0
+	var apiErr *telebot.Error
+	if errors.As(err, &apiErr) {
+		code = apiErr.Code
+	} else if m := errorCodePattern.FindStringSubmatch(err.Error()); m != nil {
+		code, _ = strconv.Atoi(m[1])
+	}
+
+	// 429 without retry_after and 5xx are worth retrying, a bad request
+	// will fail the same way every time
+	if code >= 400 && code < 500 && code != 429 {
+		return queue.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kexi/mail-to-tg/internal/queue"
+	"gopkg.in/telebot.v3"
+)
+
+func TestClassifySendError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{"blocked", telebot.ErrBlockedByUser, true},
+		{"wrapped blocked", fmt.Errorf("send: %w", telebot.ErrBlockedByUser), true},
+		{"bad request", telebot.ErrEmptyText, true},
+		{"unknown bad request", errors.New("telegram: Bad Request: can't parse entities (400)"), true},
+		{"server error", errors.New("telegram: Internal Server Error (500)"), false},
+		{"network", errors.New("dial tcp: i/o timeout"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifySendError(tt.err)
+
+			var permanent *queue.PermanentError
+			if got := errors.As(err, &permanent); got != tt.permanent {
+				t.Errorf("permanent = %v, want %v", got, tt.permanent)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("classified error does not wrap the original")
+			}
+		})
+	}
+}
+
+func TestIsUnreachable(t *testing.T) {
+	if !isUnreachable(telebot.ErrUserIsDeactivated) {
+		t.Error("deactivated user should be unreachable")
+	}
+	if isUnreachable(telebot.ErrEmptyText) {
+		t.Error("bad request should not be unreachable")
+	}
+}
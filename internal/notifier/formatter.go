@@ -5,24 +5,75 @@ import (
 	"fmt"
 	"html"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/kexi/mail-to-tg/pkg/crypto"
+	"github.com/kexi/mail-to-tg/pkg/links"
 	"github.com/kexi/mail-to-tg/pkg/llm"
 	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/kexi/mail-to-tg/pkg/textutil"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/telebot.v3"
 )
 
+// maxAttachmentButtons caps the number of download buttons per notification
+const maxAttachmentButtons = 8
+
+// maxLinkWarnings caps the mismatched links listed in a notification, the
+// email view flags them all
+const maxLinkWarnings = 3
+
 type Formatter struct {
-	baseURL string
+	baseURL           string
+	signer            *crypto.Signer
+	attachmentLinkTTL time.Duration
+	maxUploadSize     int64
 }
 
-func NewFormatter(baseURL string) *Formatter {
-	return &Formatter{baseURL: baseURL}
+func NewFormatter(baseURL string, signer *crypto.Signer, attachmentLinkTTL time.Duration, maxUploadSize int64) *Formatter {
+	return &Formatter{
+		baseURL:           baseURL,
+		signer:            signer,
+		attachmentLinkTTL: attachmentLinkTTL,
+		maxUploadSize:     maxUploadSize,
+	}
 }
 
-func (f *Formatter) FormatEmailNotification(email *models.EmailMessage) (string, *telebot.ReplyMarkup) {
+// FormatEmailNotification renders the notification in the email's current
+// state, showing times in loc. It is rendered again to edit the message
+// when the email is read, replied to, summarized or deleted.
+func (f *Formatter) FormatEmailNotification(email *models.EmailMessage, loc *time.Location) (string, *telebot.ReplyMarkup) {
 	var message strings.Builder
 
-	message.WriteString("<b>📧 New Email</b>\n\n")
+	// Spoofing and phishing warnings go first so they are seen before the sender
+	warned := false
+	if verdict, err := email.ParseAuthVerdict(); err == nil {
+		// No trusted results isn't a failure, but nothing vouches for the sender
+		if verdict == nil || verdict.Verdict == models.AuthNone {
+			message.WriteString("⚠️ Sender not authenticated\n")
+			warned = true
+		}
+		if verdict != nil {
+			for _, warning := range verdict.Warnings {
+				message.WriteString(fmt.Sprintf("🚨 <b>%s</b>\n", html.EscapeString(warning)))
+				warned = true
+			}
+		}
+	}
+	if email.SanitizedHTML != nil {
+		for i, mismatch := range links.Mismatches(*email.SanitizedHTML) {
+			if i == maxLinkWarnings {
+				break
+			}
+			message.WriteString(fmt.Sprintf("⚠️ <b>Link shows %s but goes to %s</b>\n",
+				html.EscapeString(mismatch.Shown), html.EscapeString(mismatch.Destination)))
+			warned = true
+		}
+	}
+	if warned {
+		message.WriteString("\n")
+	}
 
 	// From
 	if email.FromName != nil && *email.FromName != "" {
@@ -39,9 +90,30 @@ func (f *Formatter) FormatEmailNotification(email *models.EmailMessage) (string,
 	if email.Subject != nil && *email.Subject != "" {
 		subject = *email.Subject
 	}
-	message.WriteString(fmt.Sprintf("<b>Subject:</b> %s\n\n",
+	message.WriteString(fmt.Sprintf("<b>Subject:</b> %s\n",
 		html.EscapeString(subject)))
 
+	// Tags set by the user's rules
+	if tags := email.ParseTags(); len(tags) > 0 {
+		message.WriteString(fmt.Sprintf("🏷 %s\n", html.EscapeString("#"+strings.Join(tags, " #"))))
+	}
+	message.WriteString("\n")
+
+	// Signature and encryption badge
+	if status, err := email.ParseCryptoStatus(); err == nil && status != nil {
+		message.WriteString(formatCryptoBadge(status))
+		message.WriteString("\n\n")
+	}
+
+	// Calendar invitation
+	event, err := email.ParseCalendarEvent()
+	if err != nil {
+		log.Warn().Err(err).Str("email_id", email.ID).Msg("Failed to parse calendar event")
+	}
+	if event != nil {
+		message.WriteString(formatEventCard(event, email.CalendarRSVP, loc))
+	}
+
 	// AI Summary (or fallback to preview)
 	if email.AISummary != nil && *email.AISummary != "" {
 		message.WriteString("<b>🤖 Summary:</b>\n")
@@ -98,42 +170,307 @@ func (f *Formatter) FormatEmailNotification(email *models.EmailMessage) (string,
 	}
 
 	// Attachments
-	if email.HasAttachments {
+	attachments, _ := email.ParseAttachments()
+	if len(attachments) > 0 {
+		for _, attachment := range attachments {
+			switch attachment.Safety {
+			case models.SafetyBlocked:
+				message.WriteString(fmt.Sprintf("⚠️ <b>Blocked attachment:</b> %s — %s\n",
+					html.EscapeString(attachment.Filename),
+					html.EscapeString(attachment.SafetyReason)))
+			case models.SafetyQuarantined:
+				message.WriteString(fmt.Sprintf("⚠️ <b>Quarantined attachment:</b> %s — %s\n",
+					html.EscapeString(attachment.Filename),
+					html.EscapeString(attachment.SafetyReason)))
+			}
+		}
+
+		message.WriteString(fmt.Sprintf("📎 <b>Attachments (%d):</b>\n", len(attachments)))
+		for _, attachment := range attachments {
+			message.WriteString(fmt.Sprintf("• %s (%s)",
+				html.EscapeString(attachment.Filename),
+				textutil.FormatSize(attachment.Size)))
+			if attachment.Parent != "" {
+				message.WriteString(fmt.Sprintf(" in %s", html.EscapeString(attachment.Parent)))
+			}
+			if attachment.Safety == models.SafetyBlocked {
+				message.WriteString(" — <i>blocked</i>")
+			} else if attachment.Skipped != "" {
+				message.WriteString(fmt.Sprintf(" — <i>not stored: %s</i>",
+					html.EscapeString(attachment.Skipped)))
+			}
+			message.WriteString("\n")
+		}
+	} else if email.HasAttachments {
 		message.WriteString("📎 Has attachments\n")
 	}
 
+	// Messages forwarded as attachments
+	if embedded, err := email.ParseEmbeddedMessages(); err == nil && len(embedded) > 0 {
+		message.WriteString("\n")
+		for _, forwarded := range embedded {
+			subject := forwarded.Subject
+			if subject == "" {
+				subject = "No subject"
+			}
+			message.WriteString(fmt.Sprintf("📨 <b>Forwarded:</b> %s — %s\n",
+				html.EscapeString(subject), html.EscapeString(forwarded.From)))
+		}
+	}
+
 	// Inline keyboard
 	keyboard := &telebot.ReplyMarkup{}
 
 	btnView := keyboard.Data("🌐 View Full", "view_"+email.ID)
 	btnReply := keyboard.Data("↩️ Reply", "reply_"+email.ID)
-	btnMarkRead := keyboard.Data("✅ Mark Read", "mark_read_"+email.ID)
 
-	keyboard.Inline(
+	// Nothing left to do with a deleted email but read it
+	if email.IsDeleted {
+		keyboard.Inline(keyboard.Row(btnView))
+		return formatHeader(email, loc) + "<s>" + strings.TrimRight(message.String(), "\n") + "</s>", keyboard
+	}
+
+	rows := []telebot.Row{
 		keyboard.Row(btnView, btnReply),
-		keyboard.Row(btnMarkRead),
-	)
+	}
 
-	return message.String(), keyboard
+	var row telebot.Row
+	if !email.IsRead {
+		row = append(row, keyboard.Data("✅ Mark Read", "mark_read_"+email.ID))
+	}
+	if oneClick, mailto := email.UnsubscribeOptions(); oneClick != "" || mailto != nil {
+		row = append(row, keyboard.Data("🔕 Unsubscribe", "unsub_"+email.ID))
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	if event != nil && event.Method == models.CalendarMethodRequest {
+		rows = append(rows, keyboard.Row(
+			keyboard.Data("✅ Accept", "rsvp_accept_"+email.ID),
+			keyboard.Data("❔ Tentative", "rsvp_tentative_"+email.ID),
+			keyboard.Data("❌ Decline", "rsvp_decline_"+email.ID),
+		))
+	}
+	rows = append(rows, f.attachmentRows(keyboard, email.ID, attachments)...)
+
+	keyboard.Inline(rows...)
+
+	return formatHeader(email, loc) + message.String(), keyboard
+}
+
+// formatHeader shows what happened to the email since it arrived
+func formatHeader(email *models.EmailMessage, loc *time.Location) string {
+	var header strings.Builder
+
+	if email.IsDeleted {
+		header.WriteString("<b>🗑 Removed from INBOX</b>\n")
+	} else {
+		header.WriteString("<b>📧 New Email</b>\n")
+	}
+
+	if email.IsRead {
+		header.WriteString("✅ Read\n")
+	}
+	if email.RepliedAt != nil {
+		header.WriteString(fmt.Sprintf("↩️ Replied %s\n", email.RepliedAt.In(loc).Format("Mon 02 Jan 15:04")))
+	}
+
+	header.WriteString("\n")
+	return header.String()
+}
+
+// formatCryptoBadge summarizes the S/MIME or OpenPGP status in one line
+func formatCryptoBadge(status *models.CryptoStatus) string {
+	var parts []string
+
+	if status.Encrypted {
+		if status.Decrypted {
+			parts = append(parts, "🔓 Decrypted")
+		} else {
+			parts = append(parts, fmt.Sprintf("🔒 Encrypted, not decrypted: %s", html.EscapeString(status.DecryptError)))
+		}
+	}
+
+	if status.Signed {
+		switch status.Signature {
+		case models.SignatureVerified:
+			parts = append(parts, fmt.Sprintf("✅ Verified signature: %s", html.EscapeString(status.Signer)))
+		case models.SignatureInvalid:
+			parts = append(parts, fmt.Sprintf("⛔️ <b>Invalid signature:</b> %s", html.EscapeString(status.SignatureError)))
+		default:
+			parts = append(parts, fmt.Sprintf("⚠️ Unverified signature: %s", html.EscapeString(status.SignatureError)))
+		}
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+func formatEventCard(event *models.CalendarEvent, rsvp *string, loc *time.Location) string {
+	var card strings.Builder
+
+	switch {
+	case event.Method == models.CalendarMethodCancel || event.Status == "CANCELLED":
+		card.WriteString("<b>❌ Event cancelled</b>\n")
+	case event.IsUpdate():
+		card.WriteString("<b>🔄 Updated invitation</b>\n")
+	default:
+		card.WriteString("<b>📅 Invitation</b>\n")
+	}
+
+	if event.Summary != "" {
+		card.WriteString(fmt.Sprintf("<b>%s</b>\n", html.EscapeString(event.Summary)))
+	}
+
+	card.WriteString(fmt.Sprintf("🕒 %s\n", html.EscapeString(formatEventTime(event, loc))))
+
+	if event.Location != "" {
+		card.WriteString(fmt.Sprintf("📍 %s\n", html.EscapeString(event.Location)))
+	}
+
+	if event.Organizer != "" {
+		organizer := event.Organizer
+		if event.OrganizerName != "" {
+			organizer = fmt.Sprintf("%s <%s>", event.OrganizerName, event.Organizer)
+		}
+		card.WriteString(fmt.Sprintf("👤 %s\n", html.EscapeString(organizer)))
+	}
+
+	if rsvp != nil && *rsvp != "" {
+		card.WriteString(fmt.Sprintf("<i>You replied: %s</i>\n", strings.ToLower(*rsvp)))
+	}
+
+	card.WriteString("\n")
+	return card.String()
+}
+
+func formatEventTime(event *models.CalendarEvent, loc *time.Location) string {
+	// All-day dates have no timezone
+	if event.AllDay {
+		return event.Start.Format("Mon, 02 Jan 2006") + " (all day)"
+	}
+
+	start := event.Start.In(loc)
+	text := start.Format("Mon, 02 Jan 2006 15:04")
+
+	if !event.End.IsZero() {
+		end := event.End.In(loc)
+		if end.YearDay() == start.YearDay() && end.Year() == start.Year() {
+			text += " – " + end.Format("15:04")
+		} else {
+			text += " – " + end.Format("Mon, 02 Jan 2006 15:04")
+		}
+	}
+
+	return text + " (" + loc.String() + ")"
+}
+
+// AttachmentURL returns a signed, expiring download link for an attachment
+func (f *Formatter) AttachmentURL(emailID string, index int) string {
+	token := f.signer.Sign(models.AttachmentRef(emailID, index), time.Now().Add(f.attachmentLinkTTL))
+	return fmt.Sprintf("%s/attachment/%s", f.baseURL, token)
+}
+
+func (f *Formatter) attachmentRows(keyboard *telebot.ReplyMarkup, emailID string, attachments []*models.Attachment) []telebot.Row {
+	var rows []telebot.Row
+
+	for i, attachment := range attachments {
+		if len(rows) >= maxAttachmentButtons {
+			break
+		}
+
+		if attachment.Path == "" {
+			continue
+		}
+
+		// Files that are uploaded into the chat don't need a link
+		if isUploadable(attachment, f.maxUploadSize) {
+			continue
+		}
+
+		label := fmt.Sprintf("📎 %s (%s)", attachment.Filename, textutil.FormatSize(attachment.Size))
+		if attachment.Safety == models.SafetyQuarantined {
+			label = "⚠️ " + label
+		}
+		rows = append(rows, keyboard.Row(keyboard.URL(label, f.AttachmentURL(emailID, i))))
+	}
+
+	return rows
+}
+
+// Length of an entry's summary in digests, in characters
+const digestSummaryChars = 160
+
+// FormatDigest renders a page of a digest: the entries from first on of
+// total, each with a short summary and buttons to view or reply to it
+func (f *Formatter) FormatDigest(emails []*models.EmailMessage, first, total int, loc *time.Location) (string, *telebot.ReplyMarkup) {
+	var message strings.Builder
+
+	if first == 0 {
+		message.WriteString(fmt.Sprintf("<b>📬 Digest</b> · %d emails\n\n", total))
+	} else {
+		message.WriteString(fmt.Sprintf("<b>📬 Digest</b> · %d–%d of %d\n\n", first+1, first+len(emails), total))
+	}
+
+	keyboard := &telebot.ReplyMarkup{}
+	var rows []telebot.Row
+
+	for i, email := range emails {
+		n := first + i + 1
+
+		subject := "No subject"
+		if email.Subject != nil && *email.Subject != "" {
+			subject = *email.Subject
+		}
+		from := email.FromAddress
+		if email.FromName != nil && *email.FromName != "" {
+			from = *email.FromName
+		}
+
+		message.WriteString(fmt.Sprintf("<b>%d. %s</b>\n", n, html.EscapeString(subject)))
+		message.WriteString(fmt.Sprintf("<i>%s</i> · %s\n",
+			html.EscapeString(from), email.Date.In(loc).Format("Mon 02 Jan 15:04")))
+
+		summary := f.getEmailPreview(email)
+		if email.AISummary != nil && *email.AISummary != "" {
+			summary = *email.AISummary
+		}
+		if summary != "" {
+			message.WriteString(html.EscapeString(textutil.Truncate(summary, digestSummaryChars, "...")))
+			message.WriteString("\n")
+		}
+		message.WriteString("\n")
+
+		rows = append(rows, keyboard.Row(
+			keyboard.Data(fmt.Sprintf("🌐 View %d", n), "view_"+email.ID),
+			keyboard.Data(fmt.Sprintf("↩️ Reply %d", n), "reply_"+email.ID),
+		))
+	}
+
+	keyboard.Inline(rows...)
+	return strings.TrimSpace(message.String()), keyboard
 }
 
 func (f *Formatter) getEmailPreview(email *models.EmailMessage) string {
 	var text string
 
-	// Prefer text body for preview
-	if email.TextBody != nil && *email.TextBody != "" {
+	// Prefer what was written for this message, then the text body
+	if email.NewContent != nil && *email.NewContent != "" {
+		text = *email.NewContent
+	} else if email.TextBody != nil && *email.TextBody != "" {
 		text = *email.TextBody
+	} else if email.HTMLText != nil && *email.HTMLText != "" {
+		text = *email.HTMLText
 	} else if email.SanitizedHTML != nil && *email.SanitizedHTML != "" {
-		// Strip HTML tags for preview
-		text = f.stripHTML(*email.SanitizedHTML)
+		// Stored before the plain rendering existed
+		text = textutil.HTMLToText(*email.SanitizedHTML)
 	}
 
 	if text == "" {
 		return ""
 	}
 
-	// Clean up whitespace
-	text = strings.TrimSpace(text)
+	// Show where links really go, and clean up whitespace
+	text = strings.TrimSpace(links.UnwrapText(text))
 	lines := strings.Split(text, "\n")
 
 	var preview strings.Builder
@@ -146,12 +483,12 @@ func (f *Formatter) getEmailPreview(email *models.EmailMessage) string {
 			continue
 		}
 
-		if charCount+len(line) > maxChars {
-			remaining := maxChars - charCount
-			if remaining > 0 {
-				preview.WriteString(line[:remaining])
+		lineChars := utf8.RuneCountInString(line)
+		if charCount+lineChars > maxChars {
+			if preview.Len() > 0 {
+				preview.WriteString(" ")
 			}
-			preview.WriteString("...")
+			preview.WriteString(textutil.Truncate(line, maxChars-charCount, "..."))
 			break
 		}
 
@@ -159,31 +496,8 @@ func (f *Formatter) getEmailPreview(email *models.EmailMessage) string {
 			preview.WriteString(" ")
 		}
 		preview.WriteString(line)
-		charCount += len(line)
+		charCount += lineChars
 	}
 
 	return html.EscapeString(preview.String())
 }
-
-func (f *Formatter) stripHTML(htmlText string) string {
-	// Simple HTML tag removal
-	text := htmlText
-	text = strings.ReplaceAll(text, "<br>", "\n")
-	text = strings.ReplaceAll(text, "<br/>", "\n")
-	text = strings.ReplaceAll(text, "<br />", "\n")
-	text = strings.ReplaceAll(text, "</p>", "\n")
-	text = strings.ReplaceAll(text, "</div>", "\n")
-
-	// Remove all remaining tags
-	for strings.Contains(text, "<") && strings.Contains(text, ">") {
-		start := strings.Index(text, "<")
-		end := strings.Index(text, ">")
-		if start < end {
-			text = text[:start] + text[end+1:]
-		} else {
-			break
-		}
-	}
-
-	return text
-}
@@ -0,0 +1,55 @@
+package notifier
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+func TestFormatEmailNotificationLinkWarnings(t *testing.T) {
+	formatter := NewFormatter("https://mail.example.com", nil, time.Hour, 0)
+
+	sanitized := `<p><a href="https://evil.example.net/login">www.paypal.com</a>` +
+		` <a href="https://www.paypal.com/">paypal.com</a></p>`
+	email := &models.EmailMessage{FromAddress: "service@paypal.example", SanitizedHTML: &sanitized}
+
+	message, _ := formatter.FormatEmailNotification(email, time.UTC)
+	warning := "⚠️ <b>Link shows paypal.com but goes to evil.example.net</b>"
+	if !strings.Contains(message, warning) {
+		t.Errorf("notification lacks the link warning:\n%s", message)
+	}
+	if strings.Index(message, warning) > strings.Index(message, "<b>From:</b>") {
+		t.Errorf("link warning is not above the sender:\n%s", message)
+	}
+	if strings.Count(message, "Link shows") != 1 {
+		t.Errorf("matching link was flagged:\n%s", message)
+	}
+}
+
+func TestFormatEmailNotificationAuthentication(t *testing.T) {
+	formatter := NewFormatter("https://mail.example.com", nil, time.Hour, 0)
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		verdict *string
+		want    bool
+	}{
+		{"no results", nil, true},
+		{"none", str(`{"verdict":"none","from_domain":"example.com","aligned":false}`), true},
+		{"pass", str(`{"verdict":"pass","from_domain":"example.com","aligned":true}`), false},
+		{"fail", str(`{"verdict":"fail","from_domain":"example.com","aligned":false,"warnings":["DMARC check failed"]}`), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := &models.EmailMessage{FromAddress: "a@example.com", AuthVerdict: tt.verdict}
+			message, _ := formatter.FormatEmailNotification(email, time.UTC)
+			if got := strings.Contains(message, "⚠️ Sender not authenticated"); got != tt.want {
+				t.Errorf("unauthenticated line shown = %v, want %v:\n%s", got, tt.want, message)
+			}
+		})
+	}
+}
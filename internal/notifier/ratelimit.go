@@ -0,0 +1,89 @@
+package notifier
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"golang.org/x/time/rate"
+	"gopkg.in/telebot.v3"
+)
+
+// Chat limiters unused for this long are dropped
+const chatLimiterIdle = 10 * time.Minute
+
+// rateLimiter spaces out sends to stay under Telegram's limits: an overall
+// rate for the bot and a lower one per chat, lowest for groups
+type rateLimiter struct {
+	global    *rate.Limiter
+	chatRate  rate.Limit
+	groupRate rate.Limit
+
+	mu        sync.Mutex
+	chats     map[string]*chatLimiter
+	lastPrune time.Time
+}
+
+type chatLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+func newRateLimiter(cfg *config.TelegramConfig) *rateLimiter {
+	return &rateLimiter{
+		global:    rate.NewLimiter(rate.Limit(cfg.GlobalRatePerSecond), 1),
+		chatRate:  rate.Limit(cfg.ChatRatePerSecond),
+		groupRate: rate.Limit(cfg.GroupRatePerMinute / 60),
+		chats:     make(map[string]*chatLimiter),
+		lastPrune: time.Now(),
+	}
+}
+
+// Wait blocks until a message may be sent to the recipient
+func (l *rateLimiter) Wait(ctx context.Context, recipient telebot.Recipient) error {
+	if err := l.chat(recipient.Recipient()).Wait(ctx); err != nil {
+		return err
+	}
+	return l.global.Wait(ctx)
+}
+
+func (l *rateLimiter) chat(chatID string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPrune) > chatLimiterIdle {
+		for id, chat := range l.chats {
+			if now.Sub(chat.lastUsed) > chatLimiterIdle {
+				delete(l.chats, id)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	chat, ok := l.chats[chatID]
+	if !ok {
+		limit := l.chatRate
+		if isGroupChat(chatID) {
+			limit = l.groupRate
+		}
+		chat = &chatLimiter{limiter: rate.NewLimiter(limit, 1)}
+		l.chats[chatID] = chat
+	}
+	chat.lastUsed = now
+	return chat.limiter
+}
+
+// isGroupChat tells groups and channels, which have negative IDs, from
+// private chats
+func isGroupChat(chatID string) bool {
+	id, err := strconv.ParseInt(chatID, 10, 64)
+	return err == nil && id < 0
+}
+
+// waitToSend blocks until another message may be sent to the recipient
+func (nc *NotificationConsumer) waitToSend(recipient telebot.Recipient) {
+	nc.limiter.Wait(context.Background(), recipient)
+}
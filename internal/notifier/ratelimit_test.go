@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"gopkg.in/telebot.v3"
+)
+
+func TestRateLimiterPerChat(t *testing.T) {
+	limiter := newRateLimiter(&config.TelegramConfig{
+		GlobalRatePerSecond: 1000,
+		ChatRatePerSecond:   20,
+		GroupRatePerMinute:  60,
+	})
+	ctx := context.Background()
+
+	user := &telebot.User{ID: 42}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.Wait(ctx, user)
+	}
+	// Burst of one, then 50ms apart
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("3 messages to one chat took %v, want at least 100ms", elapsed)
+	}
+
+	// Another chat isn't held up
+	start = time.Now()
+	limiter.Wait(ctx, &telebot.User{ID: 43})
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("first message to another chat waited %v", elapsed)
+	}
+
+	group := &telebot.Chat{ID: -100123}
+	limiter.Wait(ctx, group)
+	deadline, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(deadline, group); err == nil {
+		t.Error("second group message within a second was allowed")
+	}
+}
+
+func TestIsGroupChat(t *testing.T) {
+	if isGroupChat("12345") {
+		t.Error("positive ID is a private chat")
+	}
+	if !isGroupChat("-100123") {
+		t.Error("negative ID is a group")
+	}
+}
@@ -0,0 +1,109 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/kexi/mail-to-tg/internal/attachments"
+	"github.com/kexi/mail-to-tg/internal/queue"
+	"github.com/kexi/mail-to-tg/internal/rules"
+	"github.com/kexi/mail-to-tg/pkg/llm"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// loadRules returns the user's enabled rules. Mail is still notified when
+// they can't be read.
+func (nc *NotificationConsumer) loadRules(userID string) []*models.Rule {
+	userRules, err := nc.db.GetEnabledRules(userID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID).Msg("Failed to load rules")
+		return nil
+	}
+	return userRules
+}
+
+// applyRules performs the actions of the matching rules that don't depend
+// on the notification, once it was sent or skipped: tags, marking read on
+// the server and forwarding
+func (nc *NotificationConsumer) applyRules(email *models.EmailMessage, account *models.EmailAccount, outcome *rules.Outcome) {
+	if outcome == nil {
+		return
+	}
+
+	if email.Tags != nil {
+		if err := nc.db.SetEmailTags(email.ID, email.Tags); err != nil {
+			log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to save tags")
+		}
+	}
+
+	if outcome.MarkRead {
+		err := nc.publisher.PublishMailAction(&queue.MailAction{
+			Action:    queue.MailActionMarkRead,
+			EmailID:   email.ID,
+			AccountID: email.AccountID,
+		})
+		if err != nil {
+			log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to ask for mark as read")
+		}
+	}
+
+	if outcome.ForwardTo != "" {
+		if email.AutoForwarded {
+			// A forwarded copy, maybe our own; forwarding it again could loop
+			log.Info().Str("email_id", email.ID).Msg("Not forwarding mail that was forwarded automatically")
+		} else {
+			nc.forward(email, account, outcome.ForwardTo)
+		}
+	}
+
+	log.Debug().
+		Str("email_id", email.ID).
+		Strs("rules", outcome.Matched).
+		Msg("Applied notification rules")
+}
+
+// tag puts the tags of the matching rules on the email, for the formatter
+// and applyRules
+func tag(email *models.EmailMessage, outcome *rules.Outcome) {
+	if outcome == nil || len(outcome.Tags) == 0 {
+		return
+	}
+	data, _ := json.Marshal(outcome.Tags)
+	tags := string(data)
+	email.Tags = &tags
+}
+
+// forward sends the email on from its account, with the original attached
+// when it was kept
+func (nc *NotificationConsumer) forward(email *models.EmailMessage, account *models.EmailAccount, to string) {
+	var raw io.Reader
+	if email.RawSHA256 != nil {
+		obj, _, err := nc.blobs.Open(attachments.BlobKey(*email.RawSHA256))
+		if err != nil {
+			log.Warn().Err(err).Str("email_id", email.ID).Msg("Failed to open raw message, forwarding the text")
+		} else {
+			defer obj.Close()
+			raw = obj
+		}
+	}
+
+	if err := nc.mailer.ForwardEmail(account, email, to, raw); err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Str("to", to).Msg("Failed to forward email")
+		return
+	}
+
+	log.Info().Str("email_id", email.ID).Str("to", to).Msg("Forwarded email")
+}
+
+// emailCategory returns the category of the AI summary, if any
+func emailCategory(email *models.EmailMessage) string {
+	if email.AIExtractedData == nil {
+		return ""
+	}
+	data, err := llm.ParseExtractedData(*email.AIExtractedData)
+	if err != nil {
+		return ""
+	}
+	return llm.GetCategory(data)
+}
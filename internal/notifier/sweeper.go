@@ -0,0 +1,152 @@
+package notifier
+
+import (
+	"time"
+
+	"github.com/kexi/mail-to-tg/internal/queue"
+	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	sweepBatchSize = 100
+
+	// Only one instance sweeps per interval
+	sweepLockKey = "mail-to-tg:sweeper:lock"
+
+	// An email is queued again at most this often while it stays
+	// unnotified, so retries under way aren't multiplied
+	sweptKey      = "mail-to-tg:swept:"
+	sweepRequeued = time.Hour
+)
+
+// Sweeper queues emails again that were saved but never notified, e.g.
+// because publishing the event failed. Sending twice is prevented by the
+// notify lock and the is_notified check in the consumer.
+type Sweeper struct {
+	db        *storage.MariaDB
+	redis     *storage.Redis
+	publisher *queue.Publisher
+	interval  time.Duration
+	grace     time.Duration
+	maxAge    time.Duration
+	stopped   bool
+}
+
+func NewSweeper(db *storage.MariaDB, redis *storage.Redis, cfg *config.QueueConfig) *Sweeper {
+	return &Sweeper{
+		db:        db,
+		redis:     redis,
+		publisher: queue.NewPublisher(redis),
+		interval:  time.Duration(cfg.SweepIntervalSeconds) * time.Second,
+		grace:     time.Duration(cfg.SweepGraceMinutes) * time.Minute,
+		maxAge:    time.Duration(cfg.SweepMaxAgeHours) * time.Hour,
+	}
+}
+
+func (s *Sweeper) Start() error {
+	log.Info().
+		Dur("interval", s.interval).
+		Dur("grace", s.grace).
+		Msg("Starting unnotified email sweeper")
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for !s.stopped {
+		<-ticker.C
+		s.sweep()
+	}
+
+	return nil
+}
+
+func (s *Sweeper) Stop() {
+	log.Info().Msg("Stopping unnotified email sweeper")
+	s.stopped = true
+}
+
+func (s *Sweeper) sweep() {
+	locked, err := s.redis.SetNX(sweepLockKey, "1", s.interval)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to take sweeper lock")
+		return
+	}
+	if !locked {
+		return
+	}
+
+	now := time.Now()
+	emails, err := s.db.GetUnnotifiedEmails(now.Add(-s.maxAge), now.Add(-s.grace), sweepBatchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load unnotified emails")
+		return
+	}
+
+	accounts := make(map[string]string) // account ID to user ID
+	requeued := 0
+
+	for _, email := range emails {
+		if !s.claim(email.ID) {
+			continue
+		}
+
+		userID, ok := accounts[email.AccountID]
+		if !ok {
+			account, err := s.db.GetEmailAccountByID(email.AccountID)
+			if err != nil || account == nil {
+				log.Error().Err(err).Str("account_id", email.AccountID).Msg("Failed to get account")
+				continue
+			}
+			userID = account.UserID
+			accounts[email.AccountID] = userID
+		}
+
+		event := &queue.EmailEvent{
+			EmailID:   email.ID,
+			AccountID: email.AccountID,
+			UserID:    userID,
+			Priority:  email.Priority,
+		}
+		if err := s.publisher.PublishEmailEvent(event); err != nil {
+			log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to requeue email")
+			s.redis.Del(sweptKey + email.ID)
+			continue
+		}
+		requeued++
+	}
+
+	if requeued > 0 {
+		log.Info().Int("count", requeued).Msg("Requeued unnotified emails")
+	}
+}
+
+// claim reports whether an unnotified email is queued again now. Dead
+// letters are left for an admin to replay, dropped ones for good.
+func (s *Sweeper) claim(emailID string) bool {
+	dead, err := s.publisher.IsDeadLettered(emailID)
+	if err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to check dead letters")
+		return false
+	}
+	if dead {
+		return false
+	}
+
+	dropped, err := s.publisher.IsDropped(emailID)
+	if err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to check dropped dead letters")
+		return false
+	}
+	if dropped {
+		return false
+	}
+
+	fresh, err := s.redis.SetNX(sweptKey+emailID, "1", sweepRequeued)
+	if err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to mark email as swept")
+		return false
+	}
+	return fresh
+}
@@ -0,0 +1,46 @@
+package notifier
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kexi/mail-to-tg/internal/queue"
+	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/kexi/mail-to-tg/pkg/config"
+)
+
+func TestSweeperSkipsDroppedDeadLetters(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redis, err := storage.NewRedis(&config.RedisConfig{Host: mr.Host(), Port: mr.Server().Addr().Port})
+	if err != nil {
+		t.Fatalf("NewRedis: %v", err)
+	}
+	defer redis.Close()
+
+	publisher := queue.NewPublisher(redis)
+	s := &Sweeper{redis: redis, publisher: publisher}
+
+	redis.HSet(queue.DeadLetterKey, "e1", `{"event":{"email_id":"e1","account_id":"a1","user_id":"u1"}}`)
+	if s.claim("e1") {
+		t.Fatal("dead-lettered email was queued again")
+	}
+
+	// Once dropped it stays unnotified, and must not come back
+	if err := publisher.DropDeadLetter("e1"); err != nil {
+		t.Fatalf("DropDeadLetter: %v", err)
+	}
+	if dead, _ := publisher.IsDeadLettered("e1"); dead {
+		t.Fatal("dead letter still there after the drop")
+	}
+	if s.claim("e1") {
+		t.Error("dropped email was queued again")
+	}
+
+	// Other unnotified mail is queued, once per sweepRequeued
+	if !s.claim("e2") {
+		t.Error("unnotified email was not queued")
+	}
+	if s.claim("e2") {
+		t.Error("email queued twice in a row")
+	}
+}
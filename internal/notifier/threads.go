@@ -0,0 +1,40 @@
+package notifier
+
+import (
+	"strconv"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/telebot.v3"
+)
+
+// replyInThread makes a follow-up email a reply to the last notification
+// of its thread in the same chat and topic
+func (nc *NotificationConsumer) replyInThread(email *models.EmailMessage, recipient telebot.Recipient, opts *telebot.SendOptions) {
+	if email.ThreadID == nil {
+		return
+	}
+
+	chatID, err := strconv.ParseInt(recipient.Recipient(), 10, 64)
+	if err != nil {
+		return
+	}
+
+	var topicID *int
+	if opts.ThreadID != 0 {
+		topicID = &opts.ThreadID
+	}
+
+	previous, err := nc.db.GetLastThreadMessage(email.AccountID, *email.ThreadID, email.ID, chatID, topicID)
+	if err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to look up thread notification")
+		return
+	}
+	if previous == nil {
+		return
+	}
+
+	opts.ReplyTo = &telebot.Message{ID: previous.MessageID}
+	// The user may have deleted it
+	opts.AllowWithoutReply = true
+}
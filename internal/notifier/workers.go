@@ -0,0 +1,102 @@
+package notifier
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// workerPool runs jobs on a fixed number of workers. Jobs submitted with
+// the same key always go to the same worker, so they run in order; urgent
+// jobs skip ahead of the worker's queued ordinary ones.
+type workerPool struct {
+	lanes []*workerLane
+	wg    sync.WaitGroup
+}
+
+// workerLane is the queue of one worker
+type workerLane struct {
+	urgent chan func()
+	jobs   chan func()
+}
+
+// newWorkerPool starts workers that each queue up to backlog jobs of each
+// kind; Submit blocks once a worker's queue is full
+func newWorkerPool(workers, backlog int) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &workerPool{lanes: make([]*workerLane, workers)}
+	for i := range p.lanes {
+		lane := &workerLane{
+			urgent: make(chan func(), backlog),
+			jobs:   make(chan func(), backlog),
+		}
+		p.lanes[i] = lane
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			lane.run()
+		}()
+	}
+	return p
+}
+
+// run takes urgent jobs first until both queues are closed and drained
+func (l *workerLane) run() {
+	urgent, jobs := l.urgent, l.jobs
+	for urgent != nil || jobs != nil {
+		select {
+		case job, ok := <-urgent:
+			if !ok {
+				urgent = nil
+				continue
+			}
+			job()
+			continue
+		default:
+		}
+
+		select {
+		case job, ok := <-urgent:
+			if !ok {
+				urgent = nil
+				continue
+			}
+			job()
+		case job, ok := <-jobs:
+			if !ok {
+				jobs = nil
+				continue
+			}
+			job()
+		}
+	}
+}
+
+func (p *workerPool) Submit(key string, urgent bool, job func()) {
+	lane := p.lanes[p.lane(key)]
+	if urgent {
+		lane.urgent <- job
+	} else {
+		lane.jobs <- job
+	}
+}
+
+// lane picks the worker for a key
+func (p *workerPool) lane(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.lanes)))
+}
+
+// Stop waits for the queued jobs to finish. Nothing may be submitted
+// afterwards.
+func (p *workerPool) Stop() {
+	for _, lane := range p.lanes {
+		close(lane.urgent)
+		close(lane.jobs)
+	}
+	p.wg.Wait()
+}
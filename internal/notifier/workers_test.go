@@ -0,0 +1,87 @@
+package notifier
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolKeepsOrderPerKey(t *testing.T) {
+	pool := newWorkerPool(4, 2)
+
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	for i := 0; i < 20; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			key, i := key, i
+			pool.Submit(key, false, func() {
+				mu.Lock()
+				got[key] = append(got[key], i)
+				mu.Unlock()
+			})
+		}
+	}
+	pool.Stop()
+
+	for key, seq := range got {
+		if len(seq) != 20 {
+			t.Fatalf("%s ran %d jobs, want 20", key, len(seq))
+		}
+		for i, n := range seq {
+			if n != i {
+				t.Fatalf("%s ran out of order: %v", key, seq)
+			}
+		}
+	}
+}
+
+func TestWorkerPoolRunsKeysConcurrently(t *testing.T) {
+	pool := newWorkerPool(2, 1)
+	defer pool.Stop()
+
+	// Find a key that lands on a different worker than "slow"
+	other := ""
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if pool.lane(key) != pool.lane("slow") {
+			other = key
+			break
+		}
+	}
+	if other == "" {
+		t.Skip("no key on a different worker")
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	pool.Submit("slow", false, func() { <-release })
+
+	done := make(chan struct{})
+	pool.Submit(other, false, func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("job was held up by a blocked worker")
+	}
+}
+
+func TestWorkerPoolRunsUrgentJobsFirst(t *testing.T) {
+	pool := newWorkerPool(1, 5)
+
+	release := make(chan struct{})
+	pool.Submit("u1", false, func() { <-release })
+
+	var got []string
+	for _, name := range []string{"low1", "low2"} {
+		name := name
+		pool.Submit("u1", false, func() { got = append(got, name) })
+	}
+	pool.Submit("u1", true, func() { got = append(got, "otp") })
+
+	close(release)
+	pool.Stop()
+
+	if len(got) != 3 || got[0] != "otp" || got[1] != "low1" || got[2] != "low2" {
+		t.Errorf("ran %v, want [otp low1 low2]", got)
+	}
+}
@@ -0,0 +1,203 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Domain-looking words in display names, e.g. "PayPal.com Support"
+var domainPattern = regexp.MustCompile(`[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}`)
+
+// AuthChecker turns Authentication-Results headers, and optionally a local
+// DKIM check, into a verdict on whether the From address can be trusted
+type AuthChecker struct {
+	trusted    map[string]bool
+	verifyDKIM bool
+}
+
+type authResult struct {
+	value  string
+	domain string
+}
+
+func NewAuthChecker(cfg *config.SecurityConfig) *AuthChecker {
+	a := &AuthChecker{
+		trusted:    make(map[string]bool),
+		verifyDKIM: cfg.VerifyDKIM,
+	}
+	for _, id := range cfg.TrustedAuthServIDs {
+		a.trusted[strings.ToLower(id)] = true
+	}
+	return a
+}
+
+// Check builds the verdict for a message. headers are the message's
+// Authentication-Results values, topmost first.
+func (a *AuthChecker) Check(raw []byte, headers []string, fromHeader string) *models.AuthVerdict {
+	verdict := &models.AuthVerdict{Verdict: models.AuthNone}
+
+	from, err := mail.ParseAddress(fromHeader)
+	if err != nil || !strings.Contains(from.Address, "@") {
+		verdict.Verdict = models.AuthFail
+		verdict.Warnings = append(verdict.Warnings, "The From address is malformed")
+		return verdict
+	}
+	verdict.FromDomain = strings.ToLower(from.Address[strings.LastIndex(from.Address, "@")+1:])
+
+	var spf, dkims []authResult
+	for i, header := range headers {
+		id, results, err := authres.Parse(header)
+		if err != nil {
+			continue
+		}
+
+		// Anyone can add these headers, only those of our own servers count
+		if len(a.trusted) == 0 && i > 0 {
+			break
+		}
+		if len(a.trusted) > 0 && !a.trusted[strings.ToLower(id)] {
+			continue
+		}
+
+		for _, result := range results {
+			switch r := result.(type) {
+			case *authres.SPFResult:
+				domain := r.From
+				if domain == "" {
+					domain = r.Helo
+				}
+				spf = append(spf, authResult{value: string(r.Value), domain: addressDomain(domain)})
+			case *authres.DKIMResult:
+				domain := r.Domain
+				if domain == "" {
+					domain = r.Identifier
+				}
+				dkims = append(dkims, authResult{value: string(r.Value), domain: addressDomain(domain)})
+			case *authres.DMARCResult:
+				if verdict.DMARC == "" {
+					verdict.DMARC = string(r.Value)
+				}
+			}
+		}
+	}
+
+	if a.verifyDKIM {
+		dkims = append(dkims, verifyDKIM(raw)...)
+	}
+
+	// Record the aligned result if there is one, otherwise the first
+	verdict.SPF, verdict.SPFDomain = pickResult(spf, verdict.FromDomain)
+	verdict.DKIM, verdict.DKIMDomain = pickResult(dkims, verdict.FromDomain)
+
+	spfAligned := verdict.SPF == "pass" && sameOrganization(verdict.SPFDomain, verdict.FromDomain)
+	dkimAligned := verdict.DKIM == "pass" && sameOrganization(verdict.DKIMDomain, verdict.FromDomain)
+
+	switch {
+	case verdict.DMARC == "pass":
+		verdict.Verdict = models.AuthPass
+	case verdict.DMARC == "fail":
+		verdict.Verdict = models.AuthFail
+		verdict.Warnings = append(verdict.Warnings,
+			fmt.Sprintf("DMARC check failed, this may not really be from %s", verdict.FromDomain))
+	case spfAligned || dkimAligned:
+		verdict.Verdict = models.AuthPass
+	case verdict.SPF != "" || verdict.DKIM != "":
+		verdict.Verdict = models.AuthFail
+		verdict.Warnings = append(verdict.Warnings,
+			fmt.Sprintf("SPF/DKIM do not confirm the sender domain %s", verdict.FromDomain))
+	}
+	verdict.Aligned = verdict.Verdict == models.AuthPass
+
+	// "Bank.com Support <x@evil.example>"
+	for _, domain := range displayNameDomains(from.Name) {
+		if !sameOrganization(domain, verdict.FromDomain) {
+			verdict.Warnings = append(verdict.Warnings,
+				fmt.Sprintf("The name mentions %s but the mail comes from %s", domain, verdict.FromDomain))
+			break
+		}
+	}
+
+	return verdict
+}
+
+func verifyDKIM(raw []byte) []authResult {
+	verifications, err := dkim.Verify(bytes.NewReader(raw))
+	if err != nil {
+		log.Debug().Err(err).Msg("DKIM verification failed")
+		return nil
+	}
+
+	var results []authResult
+	for _, v := range verifications {
+		value := "pass"
+		if v.Err != nil {
+			value = "fail"
+			if dkim.IsTempFail(v.Err) {
+				value = "temperror"
+			}
+		}
+		results = append(results, authResult{value: value, domain: strings.ToLower(v.Domain)})
+	}
+	return results
+}
+
+func pickResult(results []authResult, fromDomain string) (string, string) {
+	for _, r := range results {
+		if r.value == "pass" && sameOrganization(r.domain, fromDomain) {
+			return r.value, r.domain
+		}
+	}
+	if len(results) > 0 {
+		return results[0].value, results[0].domain
+	}
+	return "", ""
+}
+
+// sameOrganization is DMARC relaxed alignment: both domains share the
+// registrable domain
+func sameOrganization(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	return organizationalDomain(a) == organizationalDomain(b)
+}
+
+func organizationalDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if org, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil {
+		return org
+	}
+	return domain
+}
+
+func addressDomain(value string) string {
+	if i := strings.LastIndex(value, "@"); i >= 0 {
+		value = value[i+1:]
+	}
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// displayNameDomains finds domains with a real public suffix in a name
+func displayNameDomains(name string) []string {
+	var domains []string
+	for _, match := range domainPattern.FindAllString(strings.ToLower(name), -1) {
+		if _, icann := publicsuffix.PublicSuffix(match); !icann {
+			continue
+		}
+		if _, err := publicsuffix.EffectiveTLDPlusOne(match); err != nil {
+			continue
+		}
+		domains = append(domains, match)
+	}
+	return domains
+}
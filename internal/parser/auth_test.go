@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+func TestAuthChecker(t *testing.T) {
+	checker := NewAuthChecker(&config.SecurityConfig{})
+
+	tests := []struct {
+		name     string
+		headers  []string
+		from     string
+		verdict  string
+		warnings int
+	}{
+		{
+			name:    "dmarc pass",
+			headers: []string{"mx.example.net; dmarc=pass header.from=bank.com"},
+			from:    "Bank <alerts@bank.com>",
+			verdict: models.AuthPass,
+		},
+		{
+			name:    "aligned dkim on subdomain",
+			headers: []string{"mx.example.net; spf=pass smtp.mailfrom=bounce@esp.net; dkim=pass header.d=mail.bank.com"},
+			from:    "alerts@bank.com",
+			verdict: models.AuthPass,
+		},
+		{
+			name:     "unaligned pass",
+			headers:  []string{"mx.example.net; spf=pass smtp.mailfrom=x@evil.example; dkim=pass header.d=evil.example"},
+			from:     "Bank <alerts@bank.com>",
+			verdict:  models.AuthFail,
+			warnings: 1,
+		},
+		{
+			name: "forged lower header is ignored",
+			headers: []string{
+				"mx.example.net; dmarc=fail header.from=bank.com",
+				"forged.example; dmarc=pass header.from=bank.com",
+			},
+			from:     "alerts@bank.com",
+			verdict:  models.AuthFail,
+			warnings: 1,
+		},
+		{
+			name:     "display name mismatch",
+			from:     "\"support@bank.com\" <x@evil.example>",
+			verdict:  models.AuthNone,
+			warnings: 1,
+		},
+		{
+			name:    "no results",
+			from:    "Jane Doe <jane@example.org>",
+			verdict: models.AuthNone,
+		},
+	}
+
+	for _, tt := range tests {
+		verdict := checker.Check(nil, tt.headers, tt.from)
+		if verdict.Verdict != tt.verdict || len(verdict.Warnings) != tt.warnings {
+			t.Errorf("%s: got %s with warnings %v, want %s with %d warnings",
+				tt.name, verdict.Verdict, verdict.Warnings, tt.verdict, tt.warnings)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"path/filepath"
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"github.com/teamwork/tnef"
+)
+
+// Forwards of forwards are expanded this many levels deep
+const maxEmbeddedDepth = 3
+
+// attachmentFile is an attachment before policy checks, either a MIME part
+// or a file taken out of a container
+type attachmentFile struct {
+	filename    string
+	contentType string
+	content     []byte
+	parent      string
+}
+
+func isEmbeddedMessage(part *enmime.Part) bool {
+	return strings.EqualFold(part.ContentType, "message/rfc822")
+}
+
+func isTNEF(part *enmime.Part) bool {
+	switch strings.ToLower(part.ContentType) {
+	case "application/ms-tnef", "application/vnd.ms-tnef":
+		return true
+	}
+	return strings.EqualFold(part.FileName, "winmail.dat")
+}
+
+// embeddedParts returns the parts of an envelope that can hold files or
+// messages. Forwarded messages without a filename end up in OtherParts.
+func embeddedParts(envelope *enmime.Envelope) []*enmime.Part {
+	parts := append([]*enmime.Part(nil), envelope.Attachments...)
+	for _, part := range envelope.OtherParts {
+		if isEmbeddedMessage(part) {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// expandParts turns parts into attachment files, parsing forwarded messages
+// and unpacking TNEF containers on the way. Files inside a forwarded
+// message are returned too, so they can be stored and downloaded.
+func (p *Parser) expandParts(parts []*enmime.Part, parent string, depth int) ([]attachmentFile, []*models.EmbeddedMessage) {
+	var files []attachmentFile
+	var messages []*models.EmbeddedMessage
+
+	for _, part := range parts {
+		file := attachmentFile{
+			filename:    part.FileName,
+			contentType: part.ContentType,
+			content:     part.Content,
+			parent:      parent,
+		}
+
+		switch {
+		case isEmbeddedMessage(part) && depth < maxEmbeddedDepth:
+			message, nested, err := p.parseEmbedded(part.Content, depth+1)
+			if err != nil {
+				log.Warn().Err(err).Str("filename", part.FileName).Msg("Failed to parse forwarded message")
+				files = append(files, file)
+				continue
+			}
+			messages = append(messages, message)
+			files = append(files, nested...)
+
+			// The original .eml stays downloadable
+			if part.FileName != "" {
+				files = append(files, file)
+			}
+
+		case isTNEF(part):
+			extracted, err := extractTNEF(part.Content, part.FileName)
+			if err != nil {
+				log.Warn().Err(err).Str("filename", part.FileName).Msg("Failed to unpack TNEF attachment")
+				files = append(files, file)
+				continue
+			}
+			files = append(files, extracted...)
+
+		default:
+			files = append(files, file)
+		}
+	}
+
+	return files, messages
+}
+
+// parseEmbedded parses a message/rfc822 part with its own headers, body
+// and attachments
+func (p *Parser) parseEmbedded(raw []byte, depth int) (*models.EmbeddedMessage, []attachmentFile, error) {
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(raw))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	message := &models.EmbeddedMessage{
+		From:     envelope.GetHeader("From"),
+		To:       envelope.GetHeader("To"),
+		Cc:       envelope.GetHeader("Cc"),
+		Subject:  envelope.GetHeader("Subject"),
+		TextBody: envelope.Text,
+	}
+	if date, err := mail.ParseDate(envelope.GetHeader("Date")); err == nil {
+		message.Date = &date
+	}
+	if envelope.HTML != "" {
+		message.SanitizedHTML = p.sanitizer.Sanitize(envelope.HTML)
+	}
+
+	parent := message.Subject
+	if parent == "" {
+		parent = "Forwarded message"
+	}
+
+	files, nested := p.expandParts(embeddedParts(envelope), parent, depth)
+	for _, file := range files {
+		if file.parent == parent {
+			message.Attachments = append(message.Attachments, filepath.Base(file.filename))
+		}
+	}
+	message.Embedded = nested
+
+	return message, files, nil
+}
+
+// extractTNEF takes the files out of an Outlook winmail.dat
+func extractTNEF(data []byte, container string) (files []attachmentFile, err error) {
+	// The decoder indexes into the data without bounds checks
+	defer func() {
+		if r := recover(); r != nil {
+			files, err = nil, fmt.Errorf("malformed TNEF data: %v", r)
+		}
+	}()
+
+	decoded, err := tnef.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if container == "" {
+		container = "winmail.dat"
+	}
+
+	for _, att := range decoded.Attachments {
+		if len(att.Data) == 0 {
+			continue
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(att.Title))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		files = append(files, attachmentFile{
+			filename:    att.Title,
+			contentType: contentType,
+			content:     att.Data,
+			parent:      container,
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+)
+
+const forwardedRaw = "From: alice@example.com\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: Fwd: Contract\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=OUTER\r\n" +
+	"\r\n" +
+	"--OUTER\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"See the message below.\r\n" +
+	"--OUTER\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"Content-Disposition: attachment; filename=\"contract.eml\"\r\n" +
+	"\r\n" +
+	"From: Carol <carol@example.org>\r\n" +
+	"To: alice@example.com\r\n" +
+	"Subject: Contract\r\n" +
+	"Date: Mon, 2 Mar 2026 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=INNER\r\n" +
+	"\r\n" +
+	"--INNER\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Please sign the attached.\r\n" +
+	"--INNER\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Disposition: attachment; filename=\"terms.txt\"\r\n" +
+	"\r\n" +
+	"Terms and conditions\r\n" +
+	"--INNER--\r\n" +
+	"\r\n" +
+	"--OUTER--\r\n"
+
+func TestParseForwardedMessage(t *testing.T) {
+	p := NewParser(nil, nil, nil, nil)
+
+	parsed, err := p.ParseRaw([]byte(forwardedRaw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed.EmbeddedMessages) != 1 {
+		t.Fatalf("got %d embedded messages, want 1", len(parsed.EmbeddedMessages))
+	}
+	message := parsed.EmbeddedMessages[0]
+	if message.Subject != "Contract" || !strings.Contains(message.From, "carol@example.org") {
+		t.Errorf("wrong headers: %+v", message)
+	}
+	if message.Date == nil || message.Date.Day() != 2 {
+		t.Errorf("date not parsed: %v", message.Date)
+	}
+	if !strings.Contains(message.TextBody, "Please sign") {
+		t.Errorf("body = %q", message.TextBody)
+	}
+	if len(message.Attachments) != 1 || message.Attachments[0] != "terms.txt" {
+		t.Errorf("attachments = %v", message.Attachments)
+	}
+
+	// The inner file and the original .eml can both be downloaded
+	var names []string
+	for _, att := range parsed.Attachments {
+		names = append(names, att.Filename+"<"+att.Parent)
+	}
+	if strings.Join(names, ",") != "terms.txt<Contract,contract.eml<" {
+		t.Errorf("attachments = %v", names)
+	}
+}
+
+func TestParseTNEF(t *testing.T) {
+	data, err := os.ReadFile("testdata/winmail.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := "From: outlook@example.com\r\n" +
+		"Subject: Files\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=XX\r\n" +
+		"\r\n" +
+		"--XX\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Two files attached.\r\n" +
+		"--XX\r\n" +
+		"Content-Type: application/ms-tnef; name=\"winmail.dat\"\r\n" +
+		"Content-Disposition: attachment; filename=\"winmail.dat\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString(data) + "\r\n" +
+		"--XX--\r\n"
+
+	parsed, err := NewParser(nil, nil, nil, nil).ParseRaw([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, att := range parsed.Attachments {
+		if att.Parent != "winmail.dat" {
+			t.Errorf("%s: parent = %q", att.Filename, att.Parent)
+		}
+		names = append(names, att.Filename)
+	}
+	if strings.Join(names, ",") != "AUTHORS,README" {
+		t.Errorf("attachments = %v", names)
+	}
+}
+
+func TestExtractTNEFMalformed(t *testing.T) {
+	for _, data := range [][]byte{nil, {0x78, 0x9f}, {0x78, 0x9f, 0x3e, 0x22, 0x00, 0x00, 0x01, 0x09}} {
+		if _, err := extractTNEF(data, "winmail.dat"); err == nil {
+			t.Errorf("expected an error for %v", data)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+// icalProperty is one content line of an iCalendar object
+type icalProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// findCalendarPart returns the first text/calendar part of the message
+func findCalendarPart(root *enmime.Part) *enmime.Part {
+	if root == nil {
+		return nil
+	}
+
+	switch strings.ToLower(root.ContentType) {
+	case "text/calendar", "application/ics":
+		return root
+	}
+
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		if part := findCalendarPart(child); part != nil {
+			return part
+		}
+	}
+	return nil
+}
+
+// ParseCalendar decodes the first VEVENT of an iCalendar object. method is
+// used when the object itself has no METHOD, e.g. from the Content-Type.
+func ParseCalendar(data []byte, method string) (*models.CalendarEvent, error) {
+	event := &models.CalendarEvent{Method: strings.ToUpper(method)}
+
+	props := parseICalLines(data)
+	zones := parseVTimezones(props)
+
+	var stack []string
+	found := false
+	for _, prop := range props {
+		switch prop.Name {
+		case "BEGIN":
+			stack = append(stack, strings.ToUpper(prop.Value))
+			continue
+		case "END":
+			if len(stack) > 0 {
+				if stack[len(stack)-1] == "VEVENT" && len(stack) == 2 {
+					found = true
+				}
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		// Only the calendar itself and its first event matter, skip
+		// timezones, alarms and further occurrences
+		if len(stack) == 1 && stack[0] == "VCALENDAR" && prop.Name == "METHOD" {
+			event.Method = strings.ToUpper(prop.Value)
+			continue
+		}
+		if found || len(stack) != 2 || stack[1] != "VEVENT" {
+			continue
+		}
+
+		switch prop.Name {
+		case "UID":
+			event.UID = prop.Value
+		case "SEQUENCE":
+			event.Sequence, _ = strconv.Atoi(prop.Value)
+		case "RECURRENCE-ID":
+			event.RecurrenceID = prop.Value
+			event.RecurrenceTZ = prop.Params["TZID"]
+		case "SUMMARY":
+			event.Summary = unescapeICalText(prop.Value)
+		case "DESCRIPTION":
+			event.Description = unescapeICalText(prop.Value)
+		case "LOCATION":
+			event.Location = unescapeICalText(prop.Value)
+		case "STATUS":
+			event.Status = strings.ToUpper(prop.Value)
+		case "DTSTART":
+			t, allDay, err := parseICalTime(prop, zones)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DTSTART: %w", err)
+			}
+			event.Start, event.AllDay = t, allDay
+		case "DTEND":
+			if t, _, err := parseICalTime(prop, zones); err == nil {
+				event.End = t
+			}
+		case "ORGANIZER":
+			event.Organizer = stripMailto(prop.Value)
+			event.OrganizerName = prop.Params["CN"]
+		case "ATTENDEE":
+			event.Attendees = append(event.Attendees, stripMailto(prop.Value))
+		}
+	}
+
+	if event.UID == "" || event.Start.IsZero() {
+		return nil, fmt.Errorf("no event found")
+	}
+
+	return event, nil
+}
+
+// parseICalLines unfolds continuation lines and splits each content line
+// into its name, parameters and value
+func parseICalLines(data []byte) []icalProperty {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\n ", "")
+	text = strings.ReplaceAll(text, "\n\t", "")
+
+	var props []icalProperty
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			continue
+		}
+
+		// The value starts at the first colon outside a quoted parameter
+		sep := -1
+		quoted := false
+		for i, r := range line {
+			if r == '"' {
+				quoted = !quoted
+			} else if r == ':' && !quoted {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 {
+			continue
+		}
+
+		head := strings.Split(line[:sep], ";")
+		prop := icalProperty{
+			Name:   strings.ToUpper(head[0]),
+			Params: make(map[string]string),
+			Value:  line[sep+1:],
+		}
+		for _, param := range head[1:] {
+			if k, v, ok := strings.Cut(param, "="); ok {
+				prop.Params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+			}
+		}
+		props = append(props, prop)
+	}
+
+	return props
+}
+
+// parseICalTime reads a DATE or DATE-TIME. A TZID is looked up as an IANA
+// name, then as a Windows name, then in the calendar's VTIMEZONE blocks.
+func parseICalTime(prop icalProperty, zones map[string]*vtimezone) (time.Time, bool, error) {
+	value := prop.Value
+
+	if prop.Params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.Parse("20060102", value)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+
+	// Floating times and unknown zones fall back to UTC
+	wall, err := time.Parse("20060102T150405", value)
+	if err != nil {
+		return wall, false, err
+	}
+
+	loc := time.UTC
+	if tzid := prop.Params["TZID"]; tzid != "" {
+		loc = lookupZone(tzid, wall, zones)
+	}
+
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc), false, nil
+}
+
+func stripMailto(value string) string {
+	if len(value) >= 7 && strings.EqualFold(value[:7], "mailto:") {
+		return value[7:]
+	}
+	return value
+}
+
+func unescapeICalText(value string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(value)
+}
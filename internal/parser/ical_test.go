@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+const testInvite = "BEGIN:VCALENDAR\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Berlin\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:abc-123\r\n" +
+	"SEQUENCE:2\r\n" +
+	"SUMMARY:Quarterly review\\, Q3\r\n" +
+	"DESCRIPTION:Agenda:\\nnumbers and a very long line that is folded over\r\n" +
+	"  two lines\r\n" +
+	"LOCATION:Room 4\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20260310T140000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20260310T150000\r\n" +
+	"ORGANIZER;CN=\"Doe, Jane\":mailto:jane@example.com\r\n" +
+	"ATTENDEE;PARTSTAT=NEEDS-ACTION:MAILTO:bob@example.com\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"DESCRIPTION:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseCalendar(t *testing.T) {
+	event, err := ParseCalendar([]byte(testInvite), "")
+	if err != nil {
+		t.Fatalf("ParseCalendar failed: %v", err)
+	}
+
+	if event.Method != models.CalendarMethodRequest || !event.IsUpdate() {
+		t.Errorf("method = %q, sequence = %d", event.Method, event.Sequence)
+	}
+	if event.Summary != "Quarterly review, Q3" {
+		t.Errorf("summary = %q", event.Summary)
+	}
+	if event.Description != "Agenda:\nnumbers and a very long line that is folded over two lines" {
+		t.Errorf("description = %q", event.Description)
+	}
+	if event.Organizer != "jane@example.com" || event.OrganizerName != "Doe, Jane" {
+		t.Errorf("organizer = %q (%q)", event.Organizer, event.OrganizerName)
+	}
+	if len(event.Attendees) != 1 || event.Attendees[0] != "bob@example.com" {
+		t.Errorf("attendees = %v", event.Attendees)
+	}
+
+	want := time.Date(2026, 3, 10, 13, 0, 0, 0, time.UTC)
+	if !event.Start.Equal(want) || event.End.Sub(event.Start) != time.Hour {
+		t.Errorf("start = %v, end = %v", event.Start, event.End)
+	}
+}
+
+func TestParseCalendarAllDayCancel(t *testing.T) {
+	data := "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:x\nDTSTART;VALUE=DATE:20260401\nEND:VEVENT\nEND:VCALENDAR\n"
+
+	event, err := ParseCalendar([]byte(data), "cancel")
+	if err != nil {
+		t.Fatalf("ParseCalendar failed: %v", err)
+	}
+	if event.Method != models.CalendarMethodCancel || !event.AllDay {
+		t.Errorf("method = %q, all day = %v", event.Method, event.AllDay)
+	}
+
+	if _, err := ParseCalendar([]byte("BEGIN:VCALENDAR\nEND:VCALENDAR\n"), ""); err == nil {
+		t.Error("expected an error for a calendar without events")
+	}
+}
+
+// As Outlook sends them: Windows zone names, or a display name that only
+// the VTIMEZONE block explains
+const testOutlookInvite = "BEGIN:VCALENDAR\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"PRODID:Microsoft Exchange Server 2010\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:(UTC+01:00) Amsterdam\\, Berlin\\, Bern\\, Rome\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:16010101T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\n" +
+	"TZOFFSETTO:+0100\r\n" +
+	"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=10\r\n" +
+	"END:STANDARD\r\n" +
+	"BEGIN:DAYLIGHT\r\n" +
+	"DTSTART:16010101T020000\r\n" +
+	"TZOFFSETFROM:+0100\r\n" +
+	"TZOFFSETTO:+0200\r\n" +
+	"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=3\r\n" +
+	"END:DAYLIGHT\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:040000008200E00074C5B7101A82E008\r\n" +
+	"DTSTART;TZID=\"(UTC+01:00) Amsterdam\\, Berlin\\, Bern\\, Rome\":20260610T140000\r\n" +
+	"DTEND;TZID=W. Europe Standard Time:20260110T150000\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseCalendarOutlookZones(t *testing.T) {
+	event, err := ParseCalendar([]byte(testOutlookInvite), "")
+	if err != nil {
+		t.Fatalf("ParseCalendar failed: %v", err)
+	}
+
+	// Summer time from the VTIMEZONE rules
+	if want := time.Date(2026, 6, 10, 12, 0, 0, 0, time.UTC); !event.Start.Equal(want) {
+		t.Errorf("start = %v, want %v", event.Start, want)
+	}
+	// Winter time of the Windows zone
+	if want := time.Date(2026, 1, 10, 14, 0, 0, 0, time.UTC); !event.End.Equal(want) {
+		t.Errorf("end = %v, want %v", event.End, want)
+	}
+}
+
+func TestVTimezoneTransitions(t *testing.T) {
+	zones := parseVTimezones(parseICalLines([]byte(testOutlookInvite)))
+	zone := zones["(UTC+01:00) Amsterdam\\, Berlin\\, Bern\\, Rome"]
+	if zone == nil {
+		t.Fatalf("zones = %v", zones)
+	}
+
+	tests := []struct {
+		wall   time.Time
+		offset int
+	}{
+		{time.Date(2026, 3, 29, 1, 59, 0, 0, time.UTC), 3600},
+		{time.Date(2026, 3, 29, 2, 0, 0, 0, time.UTC), 7200},
+		{time.Date(2026, 10, 25, 2, 59, 0, 0, time.UTC), 7200},
+		{time.Date(2026, 10, 25, 3, 0, 0, 0, time.UTC), 3600},
+	}
+	for _, tt := range tests {
+		if _, offset := tt.wall.In(zone.location("x", tt.wall)).Zone(); offset != tt.offset {
+			t.Errorf("offset at %v = %d, want %d", tt.wall, offset, tt.offset)
+		}
+	}
+}
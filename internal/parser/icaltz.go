@@ -0,0 +1,216 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// windowsZones maps the Windows timezone names Outlook and Exchange put in
+// TZID to IANA names, after the CLDR windowsZones table
+var windowsZones = map[string]string{
+	"Dateline Standard Time":          "Etc/GMT+12",
+	"Hawaiian Standard Time":          "Pacific/Honolulu",
+	"Alaskan Standard Time":           "America/Anchorage",
+	"Pacific Standard Time":           "America/Los_Angeles",
+	"US Mountain Standard Time":       "America/Phoenix",
+	"Mountain Standard Time":          "America/Denver",
+	"Central Standard Time":           "America/Chicago",
+	"Central America Standard Time":   "America/Guatemala",
+	"Canada Central Standard Time":    "America/Regina",
+	"Central Standard Time (Mexico)":  "America/Mexico_City",
+	"Eastern Standard Time":           "America/New_York",
+	"US Eastern Standard Time":        "America/Indianapolis",
+	"SA Pacific Standard Time":        "America/Bogota",
+	"Atlantic Standard Time":          "America/Halifax",
+	"Newfoundland Standard Time":      "America/St_Johns",
+	"E. South America Standard Time":  "America/Sao_Paulo",
+	"Argentina Standard Time":         "America/Buenos_Aires",
+	"Pacific SA Standard Time":        "America/Santiago",
+	"UTC":                             "Etc/UTC",
+	"GMT Standard Time":               "Europe/London",
+	"Greenwich Standard Time":         "Atlantic/Reykjavik",
+	"W. Europe Standard Time":         "Europe/Berlin",
+	"Central Europe Standard Time":    "Europe/Budapest",
+	"Central European Standard Time":  "Europe/Warsaw",
+	"Romance Standard Time":           "Europe/Paris",
+	"W. Central Africa Standard Time": "Africa/Lagos",
+	"GTB Standard Time":               "Europe/Bucharest",
+	"E. Europe Standard Time":         "Europe/Chisinau",
+	"FLE Standard Time":               "Europe/Kiev",
+	"Egypt Standard Time":             "Africa/Cairo",
+	"South Africa Standard Time":      "Africa/Johannesburg",
+	"Israel Standard Time":            "Asia/Jerusalem",
+	"Turkey Standard Time":            "Europe/Istanbul",
+	"Russian Standard Time":           "Europe/Moscow",
+	"Arab Standard Time":              "Asia/Riyadh",
+	"E. Africa Standard Time":         "Africa/Nairobi",
+	"Iran Standard Time":              "Asia/Tehran",
+	"Arabian Standard Time":           "Asia/Dubai",
+	"Pakistan Standard Time":          "Asia/Karachi",
+	"India Standard Time":             "Asia/Calcutta",
+	"Nepal Standard Time":             "Asia/Katmandu",
+	"Bangladesh Standard Time":        "Asia/Dhaka",
+	"SE Asia Standard Time":           "Asia/Bangkok",
+	"China Standard Time":             "Asia/Shanghai",
+	"Singapore Standard Time":         "Asia/Singapore",
+	"Taipei Standard Time":            "Asia/Taipei",
+	"W. Australia Standard Time":      "Australia/Perth",
+	"Tokyo Standard Time":             "Asia/Tokyo",
+	"Korea Standard Time":             "Asia/Seoul",
+	"Cen. Australia Standard Time":    "Australia/Adelaide",
+	"AUS Central Standard Time":       "Australia/Darwin",
+	"E. Australia Standard Time":      "Australia/Brisbane",
+	"AUS Eastern Standard Time":       "Australia/Sydney",
+	"New Zealand Standard Time":       "Pacific/Auckland",
+}
+
+// vtimezone holds the offsets of a VTIMEZONE and when they apply
+type vtimezone struct {
+	standard *tzObservance
+	daylight *tzObservance
+}
+
+// tzObservance is a STANDARD or DAYLIGHT block with a yearly rule like
+// FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU
+type tzObservance struct {
+	offset  int // Seconds east of UTC
+	month   time.Month
+	week    int // 1 to 5 from the start of the month, -1 for the last
+	weekday time.Weekday
+	hour    int
+	minute  int
+}
+
+// parseVTimezones reads the VTIMEZONE blocks of a calendar by TZID
+func parseVTimezones(props []icalProperty) map[string]*vtimezone {
+	zones := make(map[string]*vtimezone)
+
+	var zone *vtimezone
+	var tzid string
+	var obs *tzObservance
+	for _, prop := range props {
+		switch {
+		case prop.Name == "BEGIN" && strings.EqualFold(prop.Value, "VTIMEZONE"):
+			zone, tzid = &vtimezone{}, ""
+		case prop.Name == "END" && strings.EqualFold(prop.Value, "VTIMEZONE"):
+			if zone != nil && tzid != "" && zone.standard != nil {
+				zones[tzid] = zone
+			}
+			zone = nil
+		case zone == nil:
+		case prop.Name == "TZID":
+			tzid = prop.Value
+		case prop.Name == "BEGIN" && strings.EqualFold(prop.Value, "STANDARD"):
+			obs = &tzObservance{}
+			zone.standard = obs
+		case prop.Name == "BEGIN" && strings.EqualFold(prop.Value, "DAYLIGHT"):
+			obs = &tzObservance{}
+			zone.daylight = obs
+		case prop.Name == "END":
+			obs = nil
+		case obs == nil:
+		case prop.Name == "TZOFFSETTO":
+			obs.offset = parseUTCOffset(prop.Value)
+		case prop.Name == "DTSTART":
+			if t, err := time.Parse("20060102T150405", prop.Value); err == nil {
+				obs.hour, obs.minute = t.Hour(), t.Minute()
+			}
+		case prop.Name == "RRULE":
+			obs.parseRule(prop.Value)
+		}
+	}
+
+	return zones
+}
+
+func (o *tzObservance) parseRule(rule string) {
+	for _, part := range strings.Split(rule, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "BYMONTH":
+			if m, err := strconv.Atoi(value); err == nil && m >= 1 && m <= 12 {
+				o.month = time.Month(m)
+			}
+		case "BYDAY":
+			days := map[string]time.Weekday{"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday,
+				"WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday}
+			if len(value) < 3 {
+				continue
+			}
+			weekday, ok := days[strings.ToUpper(value[len(value)-2:])]
+			week, err := strconv.Atoi(value[:len(value)-2])
+			if ok && err == nil {
+				o.weekday, o.week = weekday, week
+			}
+		}
+	}
+}
+
+// start returns when the observance begins in a year, as a wall clock
+// time in UTC
+func (o *tzObservance) start(year int) time.Time {
+	if o.week < 0 {
+		last := time.Date(year, o.month+1, 0, o.hour, o.minute, 0, 0, time.UTC)
+		return last.AddDate(0, 0, -((int(last.Weekday()) - int(o.weekday) + 7) % 7))
+	}
+	first := time.Date(year, o.month, 1, o.hour, o.minute, 0, 0, time.UTC)
+	day := (int(o.weekday)-int(first.Weekday())+7)%7 + 7*(o.week-1)
+	t := first.AddDate(0, 0, day)
+	// A fifth week that doesn't exist is the last one
+	for t.Month() != o.month {
+		t = t.AddDate(0, 0, -7)
+	}
+	return t
+}
+
+// location returns a fixed zone with the offset in effect at a wall clock
+// time given in UTC
+func (z *vtimezone) location(name string, wall time.Time) *time.Location {
+	offset := z.standard.offset
+	if d := z.daylight; d != nil && d.month != 0 && z.standard.month != 0 {
+		daylightStart, standardStart := d.start(wall.Year()), z.standard.start(wall.Year())
+		inDaylight := !wall.Before(daylightStart) && wall.Before(standardStart)
+		// Southern hemisphere: daylight time spans the new year
+		if daylightStart.After(standardStart) {
+			inDaylight = !wall.Before(daylightStart) || wall.Before(standardStart)
+		}
+		if inDaylight {
+			offset = d.offset
+		}
+	}
+	return time.FixedZone(name, offset)
+}
+
+// lookupZone resolves a TZID for a wall clock time, UTC when unknown
+func lookupZone(tzid string, wall time.Time, zones map[string]*vtimezone) *time.Location {
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	if name, ok := windowsZones[tzid]; ok {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	if zone := zones[tzid]; zone != nil {
+		return zone.location(tzid, wall)
+	}
+	return time.UTC
+}
+
+// parseUTCOffset reads offsets like +0100, -0530 or +013000
+func parseUTCOffset(value string) int {
+	if len(value) < 5 {
+		return 0
+	}
+	hours, err1 := strconv.Atoi(value[1:3])
+	minutes, err2 := strconv.Atoi(value[3:5])
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	offset := hours*3600 + minutes*60
+	if value[0] == '-' {
+		offset = -offset
+	}
+	return offset
+}
@@ -0,0 +1,215 @@
+package parser
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	mcrypto "github.com/kexi/mail-to-tg/pkg/crypto"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// ImportedKey is key material checked and normalized for storage
+type ImportedKey struct {
+	Kind        string
+	Fingerprint string
+	Identity    string
+	HasPrivate  bool
+	Data        string // PEM for S/MIME, armored for OpenPGP
+}
+
+// KeyRing holds a user's keys, decrypted for one parse
+type KeyRing struct {
+	certs []*x509.Certificate
+	smime []smimeKey
+	pgp   openpgp.EntityList
+}
+
+type smimeKey struct {
+	cert *x509.Certificate
+	key  crypto.PrivateKey
+}
+
+// ParseKey reads an S/MIME certificate (PEM, DER or PKCS#12) or an OpenPGP
+// key. The passphrase unlocks PKCS#12 files and protected OpenPGP keys.
+func ParseKey(data []byte, passphrase string) (*ImportedKey, error) {
+	trimmed := bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(trimmed, []byte("-----BEGIN PGP")) {
+		return parsePGPKey(trimmed, passphrase)
+	}
+
+	var certs []*x509.Certificate
+	var keys []crypto.PrivateKey
+	if bytes.HasPrefix(trimmed, []byte("-----BEGIN")) {
+		certs, keys = parsePEM(trimmed)
+	} else if cert, err := x509.ParseCertificate(data); err == nil {
+		certs = append(certs, cert)
+	} else {
+		key, cert, err := pkcs12.Decode(data, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("unrecognized key format: %w", err)
+		}
+		certs = append(certs, cert)
+		keys = append(keys, key)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	if len(keys) > 1 {
+		return nil, errors.New("more than one private key found")
+	}
+
+	// Normalize to PEM, certificate first
+	var out bytes.Buffer
+	cert := certs[0]
+	pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if len(keys) == 1 {
+		der, err := x509.MarshalPKCS8PrivateKey(keys[0])
+		if err != nil {
+			return nil, fmt.Errorf("unsupported private key: %w", err)
+		}
+		pem.Encode(&out, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	identity := cert.Subject.CommonName
+	if len(cert.EmailAddresses) > 0 {
+		identity = cert.EmailAddresses[0]
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	return &ImportedKey{
+		Kind:        models.CryptoKeySMIME,
+		Fingerprint: hex.EncodeToString(sum[:]),
+		Identity:    identity,
+		HasPrivate:  len(keys) == 1,
+		Data:        out.String(),
+	}, nil
+}
+
+func parsePGPKey(data []byte, passphrase string) (*ImportedKey, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenPGP key: %w", err)
+	}
+	if len(entities) != 1 {
+		return nil, fmt.Errorf("expected one OpenPGP key, got %d", len(entities))
+	}
+
+	entity := entities[0]
+	hasPrivate := entity.PrivateKey != nil
+	if hasPrivate && entity.PrivateKey.Encrypted {
+		if passphrase == "" {
+			return nil, errors.New("the key is protected, send the passphrase as caption")
+		}
+		if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("wrong passphrase: %w", err)
+		}
+	}
+
+	identity := entity.PrimaryKey.KeyIdString()
+	if id := entity.PrimaryIdentity(); id != nil {
+		identity = id.Name
+	}
+
+	return &ImportedKey{
+		Kind:        models.CryptoKeyPGP,
+		Fingerprint: hex.EncodeToString(entity.PrimaryKey.Fingerprint),
+		Identity:    identity,
+		HasPrivate:  hasPrivate,
+		Data:        string(data),
+	}, nil
+}
+
+func parsePEM(data []byte) ([]*x509.Certificate, []crypto.PrivateKey) {
+	var certs []*x509.Certificate
+	var keys []crypto.PrivateKey
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch {
+		case block.Type == "CERTIFICATE":
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				certs = append(certs, cert)
+			}
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			if key, err := parsePrivateKey(block.Bytes); err == nil {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return certs, keys
+}
+
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(der)
+}
+
+// loadKeyRing decrypts a user's stored keys. Keys that fail to load are
+// logged and left out.
+func (p *Parser) loadKeyRing(keys []*models.UserCryptoKey) *KeyRing {
+	ring := &KeyRing{}
+
+	for _, key := range keys {
+		data, err := mcrypto.Decrypt(key.KeyDataEncrypted, p.encryptionKey)
+		if err != nil {
+			log.Error().Err(err).Str("key_id", key.ID).Msg("Failed to decrypt key")
+			continue
+		}
+
+		switch key.Kind {
+		case models.CryptoKeySMIME:
+			certs, privs := parsePEM([]byte(data))
+			if len(certs) == 0 {
+				continue
+			}
+			ring.certs = append(ring.certs, certs...)
+			if len(privs) == 1 {
+				ring.smime = append(ring.smime, smimeKey{cert: certs[0], key: privs[0]})
+			}
+
+		case models.CryptoKeyPGP:
+			entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(data))
+			if err != nil {
+				log.Error().Err(err).Str("key_id", key.ID).Msg("Failed to read OpenPGP key")
+				continue
+			}
+
+			if key.PassphraseEncrypted != nil {
+				passphrase, err := mcrypto.Decrypt(*key.PassphraseEncrypted, p.encryptionKey)
+				if err == nil {
+					for _, entity := range entities {
+						if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+							log.Error().Err(err).Str("key_id", key.ID).Msg("Failed to unlock OpenPGP key")
+						}
+					}
+				}
+			}
+			ring.pgp = append(ring.pgp, entities...)
+		}
+	}
+
+	return ring
+}
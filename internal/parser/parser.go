@@ -2,50 +2,164 @@ package parser
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/jhillyerd/enmime"
 	"github.com/kexi/mail-to-tg/pkg/crypto"
 	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/kexi/mail-to-tg/pkg/textutil"
 	"github.com/rs/zerolog/log"
 )
 
 type Parser struct {
-	sanitizer       *Sanitizer
-	encryptionKey   []byte
-	attachmentsPath string
+	sanitizer     *Sanitizer
+	policy        *AttachmentPolicy
+	auth          *AuthChecker
+	priority      *PriorityRules
+	encryptionKey []byte
 }
 
 type ParsedEmail struct {
-	FromAddress     string
-	FromName        *string
-	ToAddresses     *string
-	Subject         *string
-	Date            time.Time
-	TextBody        *string
-	HTMLBody        *string
-	SanitizedHTML   *string
-	InReplyTo       *string
-	References      *string
-	Attachments     []*models.Attachment
-	AttachmentsJSON *string
+	FromAddress   string
+	FromName      *string
+	ToAddresses   *string
+	Subject       *string
+	Date          time.Time
+	TextBody      *string
+	NewContent    *string
+	HTMLBody      *string
+	HTMLText      *string
+	SanitizedHTML *string
+	InReplyTo     *string
+	References    *string
+	Attachments   []*models.Attachment
+	CalendarEvent *models.CalendarEvent
+	CryptoStatus  *models.CryptoStatus
+	AuthVerdict   *models.AuthVerdict
+
+	ListID              *string
+	ListUnsubscribe     *string
+	ListUnsubscribePost *string
+
+	EmbeddedMessages []*models.EmbeddedMessage
+
+	Bulk          bool // Sent to a list or in bulk, per Precedence and List-* headers
+	AutoForwarded bool // Forwarded automatically, by us or another system
+	Priority      models.Priority
 }
 
-func NewParser(encryptionKey []byte, attachmentsPath string) *Parser {
+func NewParser(encryptionKey []byte, policy *AttachmentPolicy, auth *AuthChecker, priority *PriorityRules) *Parser {
 	return &Parser{
-		sanitizer:       NewSanitizer(),
-		encryptionKey:   encryptionKey,
-		attachmentsPath: attachmentsPath,
+		sanitizer:     NewSanitizer(),
+		policy:        policy,
+		auth:          auth,
+		priority:      priority,
+		encryptionKey: encryptionKey,
+	}
+}
+
+// AttachmentsJSON encodes the attachments for the email_messages column
+func (p *ParsedEmail) AttachmentsJSON() *string {
+	if len(p.Attachments) == 0 {
+		return nil
+	}
+
+	jsonData, err := json.Marshal(p.Attachments)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal attachments")
+		return nil
+	}
+
+	jsonStr := string(jsonData)
+	return &jsonStr
+}
+
+// CalendarEventJSON encodes the invitation for the email_messages column
+func (p *ParsedEmail) CalendarEventJSON() *string {
+	if p.CalendarEvent == nil {
+		return nil
+	}
+
+	jsonData, err := json.Marshal(p.CalendarEvent)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal calendar event")
+		return nil
+	}
+
+	jsonStr := string(jsonData)
+	return &jsonStr
+}
+
+// CryptoStatusJSON encodes the signature and decryption results
+func (p *ParsedEmail) CryptoStatusJSON() *string {
+	if p.CryptoStatus == nil {
+		return nil
+	}
+
+	jsonData, err := json.Marshal(p.CryptoStatus)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal crypto status")
+		return nil
+	}
+
+	jsonStr := string(jsonData)
+	return &jsonStr
+}
+
+// AuthVerdictJSON encodes the sender authentication verdict
+func (p *ParsedEmail) AuthVerdictJSON() *string {
+	if p.AuthVerdict == nil {
+		return nil
 	}
+
+	jsonData, err := json.Marshal(p.AuthVerdict)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal auth verdict")
+		return nil
+	}
+
+	jsonStr := string(jsonData)
+	return &jsonStr
+}
+
+// EmbeddedMessagesJSON encodes forwarded messages for the email_messages column
+func (p *ParsedEmail) EmbeddedMessagesJSON() *string {
+	if len(p.EmbeddedMessages) == 0 {
+		return nil
+	}
+
+	jsonData, err := json.Marshal(p.EmbeddedMessages)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal embedded messages")
+		return nil
+	}
+
+	jsonStr := string(jsonData)
+	return &jsonStr
 }
 
 func (p *Parser) ParseRaw(rawEmail []byte) (*ParsedEmail, error) {
+	return p.ParseRawWithKeys(rawEmail, nil)
+}
+
+// ParseRawWithKeys parses an email, verifying signatures and decrypting
+// S/MIME and OpenPGP content with the user's keys
+func (p *Parser) ParseRawWithKeys(rawEmail []byte, keys []*models.UserCryptoKey) (*ParsedEmail, error) {
+	var ring *KeyRing
+	if len(keys) > 0 {
+		ring = p.loadKeyRing(keys)
+	}
+
+	// Strip signed and encrypted layers, DKIM is checked on the original
+	original := rawEmail
+	rawEmail, cryptoStatus := unwrapSecure(rawEmail, ring)
+
 	envelope, err := enmime.ReadEnvelope(bytes.NewReader(rawEmail))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse email: %w", err)
@@ -72,6 +186,12 @@ func (p *Parser) ParseRaw(rawEmail []byte) (*ParsedEmail, error) {
 		}
 	}
 
+	// Sender authentication
+	if p.auth != nil {
+		parsed.AuthVerdict = p.auth.Check(original,
+			envelope.GetHeaderValues("Authentication-Results"), envelope.GetHeader("From"))
+	}
+
 	// To addresses
 	if to := envelope.GetHeader("To"); to != "" {
 		parsed.ToAddresses = &to
@@ -82,10 +202,15 @@ func (p *Parser) ParseRaw(rawEmail []byte) (*ParsedEmail, error) {
 		parsed.Subject = &subject
 	}
 
-	// Text body
+	// Text body, which may be inline PGP
 	if text := envelope.Text; text != "" {
+		text, cryptoStatus = unwrapInlinePGP(text, cryptoStatus, addressOf(envelope.GetHeader("From")), ring)
 		parsed.TextBody = &text
+
+		newContent := StripQuoted(text)
+		parsed.NewContent = &newContent
 	}
+	parsed.CryptoStatus = cryptoStatus
 
 	// HTML body
 	if html := envelope.HTML; html != "" {
@@ -93,6 +218,17 @@ func (p *Parser) ParseRaw(rawEmail []byte) (*ParsedEmail, error) {
 		// Sanitize HTML
 		sanitized := p.sanitizer.Sanitize(html)
 		parsed.SanitizedHTML = &sanitized
+
+		// Plain rendering for previews, search and the LLM, from the
+		// sanitized HTML so links show their real destination
+		if htmlText := textutil.HTMLToText(sanitized); htmlText != "" {
+			parsed.HTMLText = &htmlText
+
+			if parsed.NewContent == nil {
+				newContent := StripQuoted(htmlText)
+				parsed.NewContent = &newContent
+			}
+		}
 	}
 
 	// In-Reply-To
@@ -105,72 +241,108 @@ func (p *Parser) ParseRaw(rawEmail []byte) (*ParsedEmail, error) {
 		parsed.References = &references
 	}
 
-	// Attachments
-	if len(envelope.Attachments) > 0 {
-		attachments, err := p.saveAttachments(envelope.Attachments)
+	// Mailing list
+	if listID := parseListID(envelope.GetHeader("List-Id")); listID != "" {
+		parsed.ListID = &listID
+	}
+	if unsubscribe := envelope.GetHeader("List-Unsubscribe"); unsubscribe != "" {
+		parsed.ListUnsubscribe = &unsubscribe
+		if post := envelope.GetHeader("List-Unsubscribe-Post"); post != "" {
+			parsed.ListUnsubscribePost = &post
+		}
+	}
+	parsed.Bulk = isBulk(envelope.GetHeader("Precedence"), parsed)
+	parsed.AutoForwarded = isAutoForwarded(envelope.GetHeader("Auto-Submitted"), envelope.GetHeader(models.ForwardedByHeader))
+	parsed.Priority = p.priority.Priority(parsed)
+
+	// Calendar invitation
+	if part := findCalendarPart(envelope.Root); part != nil {
+		event, err := ParseCalendar(part.Content, part.ContentTypeParams["method"])
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to save attachments")
+			log.Warn().Err(err).Msg("Failed to parse calendar invitation")
 		} else {
-			parsed.Attachments = attachments
-			// Convert to JSON
-			jsonData, err := json.Marshal(attachments)
-			if err == nil {
-				jsonStr := string(jsonData)
-				parsed.AttachmentsJSON = &jsonStr
+			parsed.CalendarEvent = event
+		}
+	}
+
+	// Attachments, with forwarded messages and TNEF containers expanded
+	parts := embeddedParts(envelope)
+
+	// The invitation is shown as an event card, not as a file
+	if parsed.CalendarEvent != nil {
+		var kept []*enmime.Part
+		for _, part := range parts {
+			if findCalendarPart(part) == nil {
+				kept = append(kept, part)
 			}
 		}
+		parts = kept
 	}
 
+	files, embedded := p.expandParts(parts, "", 0)
+	parsed.Attachments = p.readAttachments(files)
+	parsed.EmbeddedMessages = embedded
+
 	return parsed, nil
 }
 
-func (p *Parser) saveAttachments(attachments []*enmime.Part) ([]*models.Attachment, error) {
-	emailID := uuid.New().String()
-	emailDir := filepath.Join(p.attachmentsPath, emailID)
-
-	if err := os.MkdirAll(emailDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create attachments directory: %w", err)
-	}
-
+// readAttachments collects attachment contents and their hashes. Storing
+// them is left to the caller once the owning user is known.
+func (p *Parser) readAttachments(files []attachmentFile) []*models.Attachment {
 	var result []*models.Attachment
 
-	for _, part := range attachments {
-		filename := part.FileName
+	for _, file := range files {
+		filename := file.filename
 		if filename == "" {
 			filename = fmt.Sprintf("attachment_%d", len(result)+1)
 		}
 
 		// Sanitize filename
 		filename = filepath.Base(filename)
-		filePath := filepath.Join(emailDir, filename)
 
-		content := part.Content
+		content := file.content
 		if content == nil {
 			log.Error().Str("filename", filename).Msg("Attachment content is nil")
 			continue
 		}
 
-		if err := os.WriteFile(filePath, content, 0644); err != nil {
-			log.Error().Err(err).Str("filename", filename).Msg("Failed to write attachment")
-			continue
-		}
-
+		sum := sha256.Sum256(content)
 		attachment := &models.Attachment{
 			Filename:    filename,
-			ContentType: part.ContentType,
+			ContentType: file.contentType,
 			Size:        int64(len(content)),
-			Path:        filePath,
+			SHA256:      hex.EncodeToString(sum[:]),
+			Parent:      file.parent,
+			Content:     content,
 		}
 
-		result = append(result, attachment)
+		// Check the real type against the attachment policy
+		if p.policy != nil {
+			p.policy.Apply(attachment)
+			if attachment.Safety != "" {
+				log.Warn().
+					Str("filename", filename).
+					Str("safety", attachment.Safety).
+					Str("reason", attachment.SafetyReason).
+					Msg("Attachment flagged by policy")
+			}
+		}
 
-		log.Debug().
-			Str("filename", filename).
-			Int64("size", attachment.Size).
-			Msg("Saved attachment")
+		result = append(result, attachment)
 	}
 
-	return result, nil
+	return result
+}
+
+// parseListID takes the identifier out of "Weekly News <news.example.com>"
+func parseListID(header string) string {
+	header = strings.TrimSpace(header)
+	if start := strings.LastIndex(header, "<"); start >= 0 {
+		if end := strings.Index(header[start:], ">"); end > 0 {
+			header = header[start+1 : start+end]
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(header))
 }
 
 func (p *Parser) DecryptPassword(encrypted string) (string, error) {
@@ -180,3 +352,11 @@ func (p *Parser) DecryptPassword(encrypted string) (string, error) {
 func (p *Parser) EncryptPassword(password string) (string, error) {
 	return crypto.Encrypt(password, p.encryptionKey)
 }
+
+// isAutoForwarded reads the Auto-Submitted header (RFC 3834) and the marker
+// rule forwarding adds
+func isAutoForwarded(autoSubmitted, forwardedBy string) bool {
+	value, _, _ := strings.Cut(autoSubmitted, ";")
+	return strings.EqualFold(strings.TrimSpace(value), "auto-forwarded") ||
+		strings.EqualFold(strings.TrimSpace(forwardedBy), models.ForwardedByValue)
+}
@@ -0,0 +1,15 @@
+package parser
+
+import "testing"
+
+func TestIsAutoForwarded(t *testing.T) {
+	if !isAutoForwarded("Auto-Forwarded", "") {
+		t.Error("Auto-Submitted: auto-forwarded is forwarded")
+	}
+	if !isAutoForwarded("", "mail-to-tg") {
+		t.Error("our marker is forwarded")
+	}
+	if isAutoForwarded("no", "") || isAutoForwarded("auto-replied", "") {
+		t.Error("other mail is not forwarded")
+	}
+}
@@ -0,0 +1,221 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+// Limits for looking inside archives
+const (
+	maxArchiveEntries  = 1000
+	maxArchiveDepth    = 2
+	maxNestedArchiveMB = 10
+)
+
+// Sniffed types that are executable whatever the file is called
+var executableTypes = []string{
+	"application/vnd.microsoft.portable-executable",
+	"application/x-elf",
+	"application/x-mach-binary",
+	"application/x-ms-installer",
+	"application/x-ms-shortcut",
+	"application/jar",
+	"application/x-java-applet",
+}
+
+// Extensions that look harmless and are used to disguise the real one
+var decoyExtensions = map[string]bool{
+	"pdf": true, "doc": true, "docx": true, "xls": true, "xlsx": true,
+	"ppt": true, "pptx": true, "txt": true, "jpg": true, "jpeg": true,
+	"png": true, "gif": true, "zip": true, "rtf": true, "csv": true,
+}
+
+// AttachmentPolicy decides whether attachments are safe to store and offer
+type AttachmentPolicy struct {
+	blocked     map[string]bool
+	quarantined map[string]bool
+}
+
+func NewAttachmentPolicy(cfg *config.AttachmentPolicyConfig) *AttachmentPolicy {
+	p := &AttachmentPolicy{
+		blocked:     make(map[string]bool),
+		quarantined: make(map[string]bool),
+	}
+	for _, ext := range cfg.BlockedExtensions {
+		p.blocked[normalizeExt(ext)] = true
+	}
+	for _, ext := range cfg.QuarantineExtensions {
+		p.quarantined[normalizeExt(ext)] = true
+	}
+	return p
+}
+
+// Apply sniffs the real content type and records the policy verdict on the
+// attachment. Blocked attachments lose their content so they are never stored.
+func (p *AttachmentPolicy) Apply(attachment *models.Attachment) {
+	detected := mimetype.Detect(attachment.Content)
+	if !detected.Is("application/octet-stream") && !detected.Is("text/plain") {
+		if !strings.EqualFold(attachment.ContentType, detected.String()) {
+			attachment.DeclaredContentType = attachment.ContentType
+		}
+		attachment.ContentType = detected.String()
+	}
+
+	verdict, reason := p.check(attachment.Filename, attachment.Content, detected, 0)
+	if verdict == "" {
+		return
+	}
+
+	attachment.Safety = verdict
+	attachment.SafetyReason = reason
+
+	if verdict == models.SafetyBlocked {
+		attachment.Content = nil
+		attachment.Skipped = "blocked: " + reason
+	}
+}
+
+func (p *AttachmentPolicy) check(filename string, content []byte, detected *mimetype.MIME, depth int) (string, string) {
+	name := strings.ToLower(strings.TrimSpace(filename))
+	ext := normalizeExt(path.Ext(name))
+
+	// Real type first, the name can lie
+	for _, t := range executableTypes {
+		if detected.Is(t) {
+			return models.SafetyBlocked, fmt.Sprintf("executable content (%s)", detected.String())
+		}
+	}
+	if bytes.HasPrefix(content, []byte("#!")) {
+		return models.SafetyBlocked, "script content"
+	}
+
+	// A harmless looking inner extension hides the real one, e.g.
+	// invoice.pdf.html, whether or not the real one is blocked
+	prev := normalizeExt(path.Ext(strings.TrimSpace(strings.TrimSuffix(name, path.Ext(name)))))
+	decoy := decoyExtensions[prev] && !decoyExtensions[ext]
+
+	if p.blocked[ext] {
+		if decoy {
+			return models.SafetyBlocked, fmt.Sprintf("double extension (.%s.%s)", prev, ext)
+		}
+		return models.SafetyBlocked, fmt.Sprintf("blocked file type (.%s)", ext)
+	}
+
+	if decoy {
+		return models.SafetyQuarantined, fmt.Sprintf("double extension (.%s.%s)", prev, ext)
+	}
+
+	if hasMacros(content, detected) {
+		return models.SafetyQuarantined, "macro-enabled Office document"
+	}
+
+	if p.quarantined[ext] {
+		return models.SafetyQuarantined, fmt.Sprintf("risky file type (.%s)", ext)
+	}
+
+	if detected.Is("application/zip") && !isOfficeOpenXML(detected) {
+		return p.checkArchive(content, depth)
+	}
+
+	return "", ""
+}
+
+func (p *AttachmentPolicy) checkArchive(content []byte, depth int) (string, string) {
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return models.SafetyQuarantined, "unreadable archive"
+	}
+
+	if len(reader.File) > maxArchiveEntries {
+		return models.SafetyQuarantined, "archive has too many entries"
+	}
+
+	verdict, reason := "", ""
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		// Password-protected archives are a common way to get past scanners
+		if f.Flags&0x1 != 0 {
+			return models.SafetyQuarantined, "encrypted archive"
+		}
+
+		var entry []byte
+		if depth < maxArchiveDepth && f.UncompressedSize64 <= maxNestedArchiveMB<<20 {
+			entry = readZipEntry(f)
+		}
+
+		v, r := p.check(path.Base(f.Name), entry, mimetype.Detect(entry), depth+1)
+		if v == models.SafetyBlocked {
+			return models.SafetyBlocked, fmt.Sprintf("archive contains %s: %s", path.Base(f.Name), r)
+		}
+		if v == models.SafetyQuarantined && verdict == "" {
+			verdict, reason = v, fmt.Sprintf("archive contains %s: %s", path.Base(f.Name), r)
+		}
+	}
+
+	return verdict, reason
+}
+
+func readZipEntry(f *zip.File) []byte {
+	rc, err := f.Open()
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxNestedArchiveMB<<20))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// hasMacros looks for a VBA project in OOXML zips and legacy OLE documents
+func hasMacros(content []byte, detected *mimetype.MIME) bool {
+	if isOfficeOpenXML(detected) || detected.Is("application/zip") {
+		reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			return false
+		}
+		for _, f := range reader.File {
+			if strings.HasSuffix(strings.ToLower(f.Name), "vbaproject.bin") {
+				return true
+			}
+		}
+		return false
+	}
+
+	for m := detected; m != nil; m = m.Parent() {
+		if m.Is("application/x-ole-storage") {
+			return bytes.Contains(content, utf16LE("_VBA_PROJECT"))
+		}
+	}
+	return false
+}
+
+func isOfficeOpenXML(detected *mimetype.MIME) bool {
+	return strings.HasPrefix(detected.String(), "application/vnd.openxmlformats-officedocument.")
+}
+
+func utf16LE(s string) []byte {
+	var buf []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		buf = append(buf, byte(r), byte(r>>8))
+	}
+	return buf
+}
+
+func normalizeExt(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+}
@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+func testPolicy() *AttachmentPolicy {
+	return NewAttachmentPolicy(&config.AttachmentPolicyConfig{
+		BlockedExtensions:    []string{"exe", "js"},
+		QuarantineExtensions: []string{".ISO"},
+	})
+}
+
+func zipOf(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAttachmentPolicy(t *testing.T) {
+	pe := append([]byte("MZ"), make([]byte, 128)...)
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		safety   string
+	}{
+		{"plain text", "notes.txt", []byte("hello"), ""},
+		{"blocked extension", "run.JS", []byte("alert(1)"), models.SafetyBlocked},
+		{"double extension", "invoice.pdf.exe", []byte("x"), models.SafetyBlocked},
+		{"double extension, other type", "invoice.pdf.html", []byte("<html></html>"), models.SafetyQuarantined},
+		{"double extension, padded", "scan.JPG .lnk", []byte("x"), models.SafetyQuarantined},
+		{"two harmless extensions", "photo.jpg.png", []byte("x"), ""},
+		{"version in the name", "release-1.2.txt", []byte("x"), ""},
+		{"disguised executable", "photo.jpg", pe, models.SafetyBlocked},
+		{"quarantined extension", "disk.iso", []byte("x"), models.SafetyQuarantined},
+		{"archive with executable", "files.zip", zipOf(t, map[string][]byte{"setup.exe": pe}), models.SafetyBlocked},
+		{"clean archive", "files.zip", zipOf(t, map[string][]byte{"a.txt": []byte("hi")}), ""},
+		{"macros", "report.docx", zipOf(t, map[string][]byte{
+			"[Content_Types].xml": []byte("<Types/>"),
+			"word/vbaProject.bin": []byte("vba"),
+			"word/document.xml":   []byte("<doc/>"),
+		}), models.SafetyQuarantined},
+	}
+
+	policy := testPolicy()
+	for _, tt := range tests {
+		attachment := &models.Attachment{Filename: tt.filename, Content: tt.content}
+		policy.Apply(attachment)
+
+		if attachment.Safety != tt.safety {
+			t.Errorf("%s: got safety %q (%s), want %q", tt.name, attachment.Safety, attachment.SafetyReason, tt.safety)
+		}
+		if tt.safety == models.SafetyBlocked && attachment.Content != nil {
+			t.Errorf("%s: blocked attachment kept its content", tt.name)
+		}
+	}
+}
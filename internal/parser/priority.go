@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+var (
+	// Wording of one-time password and login code emails
+	otpKeywordPattern = regexp.MustCompile(`(?i)(verification|security|confirmation|login|log-in|sign[- ]?in|access|authentication|one[- ]time) (code|pin|passcode)|one[- ]time pass(word|code)|\b(otp|2fa|mfa)\b|two[- ]factor|验证码|校验码|動態密碼|动态密码|認証コード|確認コード|인증번호`)
+
+	// The code itself, 4 to 8 digits possibly split by a space or dash
+	otpCodePattern = regexp.MustCompile(`\b\d{3,4}[ -]?\d{3,4}\b|\b\d{4,8}\b`)
+)
+
+// How far from the wording a code is looked for, in bytes
+const otpCodeDistance = 120
+
+// PriorityRules computes the notification priority of parsed emails
+type PriorityRules struct {
+	vipAddresses map[string]bool
+	vipDomains   []string
+}
+
+// NewPriorityRules reads the VIP senders, full addresses or @domain
+func NewPriorityRules(cfg *config.QueueConfig) *PriorityRules {
+	rules := &PriorityRules{vipAddresses: make(map[string]bool)}
+	for _, sender := range cfg.VIPSenders {
+		sender = strings.ToLower(strings.TrimSpace(sender))
+		switch {
+		case sender == "":
+		case strings.HasPrefix(sender, "@"):
+			rules.vipDomains = append(rules.vipDomains, sender[1:])
+		default:
+			rules.vipAddresses[sender] = true
+		}
+	}
+	return rules
+}
+
+// Priority puts one-time passwords and VIP senders first and bulk mail
+// last. A code email sent through a mailing service still counts as a code.
+// Only authenticated senders are put first, anyone can write a From header
+// or a subject that looks like a code.
+func (r *PriorityRules) Priority(parsed *ParsedEmail) models.Priority {
+	if isAuthenticated(parsed) && (isOTP(parsed) || r.isVIP(parsed.FromAddress)) {
+		return models.PriorityHigh
+	}
+	if parsed.Bulk {
+		return models.PriorityLow
+	}
+	return models.PriorityNormal
+}
+
+// isAuthenticated reports whether SPF or DKIM aligned with the From domain
+// passed, or DMARC did
+func isAuthenticated(parsed *ParsedEmail) bool {
+	return parsed.AuthVerdict != nil && parsed.AuthVerdict.Verdict == models.AuthPass
+}
+
+func (r *PriorityRules) isVIP(address string) bool {
+	if r == nil {
+		return false
+	}
+
+	address = strings.ToLower(address)
+	if r.vipAddresses[address] {
+		return true
+	}
+
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return false
+	}
+	domain := address[at+1:]
+	for _, vip := range r.vipDomains {
+		if domain == vip || strings.HasSuffix(domain, "."+vip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOTP spots one-time password emails: the wording in the subject, or in
+// the body next to something that looks like a code
+func isOTP(parsed *ParsedEmail) bool {
+	if parsed.Subject != nil && otpKeywordPattern.MatchString(*parsed.Subject) {
+		return true
+	}
+
+	body := ""
+	switch {
+	case parsed.NewContent != nil:
+		body = *parsed.NewContent
+	case parsed.TextBody != nil:
+		body = *parsed.TextBody
+	case parsed.HTMLText != nil:
+		body = *parsed.HTMLText
+	}
+	if len(body) > 2000 {
+		body = body[:2000]
+	}
+
+	// The code has to be close to the wording, not a year in the footer
+	for _, loc := range otpKeywordPattern.FindAllStringIndex(body, -1) {
+		start, end := loc[0]-otpCodeDistance, loc[1]+otpCodeDistance
+		if start < 0 {
+			start = 0
+		}
+		if end > len(body) {
+			end = len(body)
+		}
+		if otpCodePattern.MatchString(body[start:end]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBulk reads the headers bulk senders and mailing lists add
+func isBulk(precedence string, parsed *ParsedEmail) bool {
+	switch strings.ToLower(strings.TrimSpace(precedence)) {
+	case "bulk", "list", "junk":
+		return true
+	}
+	return parsed.ListID != nil || parsed.ListUnsubscribe != nil
+}
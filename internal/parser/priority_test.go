@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+func TestPriority(t *testing.T) {
+	rules := NewPriorityRules(&config.QueueConfig{
+		VIPSenders: []string{"Boss@Example.com", "@bank.example"},
+	})
+
+	str := func(s string) *string { return &s }
+	listID := str("news.shop.example")
+	pass := &models.AuthVerdict{Verdict: models.AuthPass}
+	fail := &models.AuthVerdict{Verdict: models.AuthFail}
+
+	tests := []struct {
+		name   string
+		parsed *ParsedEmail
+		want   models.Priority
+	}{
+		{"otp subject", &ParsedEmail{AuthVerdict: pass, FromAddress: "no-reply@service.example", Subject: str("Your verification code")}, models.PriorityHigh},
+		{"chinese otp subject", &ParsedEmail{AuthVerdict: pass, FromAddress: "no-reply@qq.example", Subject: str("QQ邮箱验证码")}, models.PriorityHigh},
+		{"otp body", &ParsedEmail{AuthVerdict: pass, FromAddress: "a@service.example", Subject: str("Sign in"), NewContent: str("Use the code 482 913 to sign in. This one-time password expires soon.")}, models.PriorityHigh},
+		{"otp sent as bulk", &ParsedEmail{AuthVerdict: pass, FromAddress: "a@service.example", Subject: str("Your login code"), ListID: listID, Bulk: true}, models.PriorityHigh},
+		{"vip address", &ParsedEmail{AuthVerdict: pass, FromAddress: "boss@example.com", Subject: str("Quick question")}, models.PriorityHigh},
+		{"vip subdomain", &ParsedEmail{AuthVerdict: pass, FromAddress: "alerts@mail.bank.example", Subject: str("Statement ready")}, models.PriorityHigh},
+		{"lookalike domain", &ParsedEmail{AuthVerdict: pass, FromAddress: "alerts@notbank.example", Subject: str("Statement ready")}, models.PriorityNormal},
+		{"newsletter", &ParsedEmail{AuthVerdict: pass, FromAddress: "news@shop.example", Subject: str("Spring sale"), ListID: listID, Bulk: true}, models.PriorityLow},
+		{"unauthenticated vip", &ParsedEmail{FromAddress: "boss@example.com", Subject: str("Quick question")}, models.PriorityNormal},
+		{"spoofed vip", &ParsedEmail{AuthVerdict: fail, FromAddress: "boss@example.com", Subject: str("Wire transfer")}, models.PriorityNormal},
+		{"spoofed otp sent as bulk", &ParsedEmail{AuthVerdict: fail, FromAddress: "a@service.example", Subject: str("Your login code"), ListID: listID, Bulk: true}, models.PriorityLow},
+		{"plain", &ParsedEmail{AuthVerdict: pass, FromAddress: "friend@example.org", Subject: str("Dinner?"), NewContent: str("Are you free on Friday?")}, models.PriorityNormal},
+		{
+			"keyword far from a number",
+			&ParsedEmail{AuthVerdict: pass, FromAddress: "friend@example.org", Subject: str("Security tips"), NewContent: str("Turn on two-factor authentication." + strings.Repeat(" Lorem ipsum.", 30) + " © 2026")},
+			models.PriorityNormal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Priority(tt.parsed); got != tt.want {
+				t.Errorf("Priority = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBulk(t *testing.T) {
+	if !isBulk("bulk", &ParsedEmail{}) {
+		t.Error("Precedence: bulk is bulk")
+	}
+	unsubscribe := "<mailto:leave@list.example>"
+	if !isBulk("", &ParsedEmail{ListUnsubscribe: &unsubscribe}) {
+		t.Error("List-Unsubscribe is bulk")
+	}
+	if isBulk("", &ParsedEmail{}) {
+		t.Error("plain mail is not bulk")
+	}
+}
+
+func TestPriorityWithoutRules(t *testing.T) {
+	var rules *PriorityRules
+	subject := "Hello"
+	if got := rules.Priority(&ParsedEmail{FromAddress: "a@example.org", Subject: &subject}); got != models.PriorityNormal {
+		t.Errorf("Priority = %s, want normal", got)
+	}
+}
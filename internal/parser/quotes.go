@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Lines that start the quoted previous message in replies and forwards
+var quoteHeaderPatterns = []*regexp.Regexp{
+	// On Mon, 3 Mar 2026 at 10:00, Jane <jane@example.com> wrote:
+	regexp.MustCompile(`(?i)^on\b.+\bwrote:?$`),
+	// 在 2026年3月3日 10:00，Jane <jane@example.com> 写道：
+	regexp.MustCompile(`^在.+写道[:：]?$`),
+	// -----Original Message----- / -----原始邮件-----
+	regexp.MustCompile(`(?i)^-{2,}\s*(original message|forwarded message|原始邮件|转发邮件)\s*-{2,}$`),
+	// Outlook draws a rule above the quoted headers
+	regexp.MustCompile(`^_{10,}$`),
+}
+
+// Outlook and Foxmail quote with a header block instead of ">"
+var outlookHeaderPattern = regexp.MustCompile(`(?i)^\*?(from|发件人)\s*[:：]`)
+var outlookFieldPattern = regexp.MustCompile(`(?i)^\*?(sent|date|to|subject|发送时间|日期|收件人|主题)\s*[:：]`)
+
+// Phone clients append these in place of a signature
+var mobileSignaturePattern = regexp.MustCompile(`(?i)^(sent from my \w+|get outlook for \w+|发自我的\S+)`)
+
+// StripQuoted returns the part of a plain-text body written for this
+// message, without quoted replies, forwarded headers and the signature
+func StripQuoted(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	end := len(lines)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+
+		if isQuoteHeader(lines, i) {
+			end = i
+			break
+		}
+
+		// "-- " is the standard signature delimiter
+		if lines[i] == "-- " || line == "--" || mobileSignaturePattern.MatchString(line) {
+			end = i
+			break
+		}
+	}
+
+	// Drop ">" quoted lines, e.g. from inline replies
+	var kept []string
+	for _, line := range lines[:end] {
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	result := strings.TrimSpace(strings.Join(kept, "\n"))
+
+	// A message that is only a quote, e.g. a bare forward, keeps its text
+	if result == "" {
+		return strings.TrimSpace(text)
+	}
+	return result
+}
+
+func isQuoteHeader(lines []string, i int) bool {
+	line := strings.TrimSpace(lines[i])
+	if line == "" {
+		return false
+	}
+
+	for _, pattern := range quoteHeaderPatterns {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+
+	// Clients wrap long "On ... wrote:" lines
+	if i+1 < len(lines) && strings.HasPrefix(strings.ToLower(line), "on ") {
+		joined := line + " " + strings.TrimSpace(lines[i+1])
+		if quoteHeaderPatterns[0].MatchString(joined) {
+			return true
+		}
+	}
+
+	// "From: ..." followed by "Sent:" or "To:" within the next lines
+	if outlookHeaderPattern.MatchString(line) {
+		for j := i + 1; j < len(lines) && j <= i+3; j++ {
+			if outlookFieldPattern.MatchString(strings.TrimSpace(lines[j])) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
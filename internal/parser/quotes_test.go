@@ -0,0 +1,58 @@
+package parser
+
+import "testing"
+
+func TestStripQuoted(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "gmail reply",
+			text: "Sounds good, see you then.\n\nOn Mon, 3 Mar 2026 at 10:00, Jane Doe <jane@example.com> wrote:\n> Lunch at noon?\n",
+			want: "Sounds good, see you then.",
+		},
+		{
+			name: "wrapped attribution",
+			text: "Yes.\n\nOn Mon, 3 Mar 2026 at 10:00, Jane Doe\n<jane@example.com> wrote:\n> Ready?",
+			want: "Yes.",
+		},
+		{
+			name: "chinese reply",
+			text: "好的，收到。\n\n在 2026年3月3日 10:00，张三 <zhang@example.com> 写道：\n> 请确认\n",
+			want: "好的，收到。",
+		},
+		{
+			name: "outlook",
+			text: "Please see below.\r\n\r\nFrom: Jane Doe <jane@example.com>\r\nSent: Monday, March 3, 2026 10:00 AM\r\nTo: Bob\r\nSubject: Report\r\n\r\nOld text",
+			want: "Please see below.",
+		},
+		{
+			name: "original message separator",
+			text: "FYI\n-----Original Message-----\nFrom: Jane",
+			want: "FYI",
+		},
+		{
+			name: "signature",
+			text: "Thanks!\n-- \nBob\nACME Corp",
+			want: "Thanks!",
+		},
+		{
+			name: "inline replies",
+			text: "> Can you make it?\nYes\n> And Bob?\nNo",
+			want: "Yes\nNo",
+		},
+		{
+			name: "bare forward keeps text",
+			text: "-----Forwarded Message-----\nFrom: Jane\nHello",
+			want: "-----Forwarded Message-----\nFrom: Jane\nHello",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := StripQuoted(tt.text); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
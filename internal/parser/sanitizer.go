@@ -1,9 +1,34 @@
 package parser
 
 import (
+	"bytes"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/kexi/mail-to-tg/pkg/links"
+	"github.com/kexi/mail-to-tg/pkg/models"
 	"github.com/microcosm-cc/bluemonday"
+	"golang.org/x/net/html"
 )
 
+// Hosts and paths of well-known open-tracking pixels
+var trackingPixelPatterns = []string{
+	"list-manage.com/track/",
+	"sendgrid.net/wf/open",
+	"mandrillapp.com/track/open",
+	"mailtrack.io/trace",
+	"mailgun.org/o/",
+	"/track/open",
+	"/open.php",
+	"/open.aspx",
+	"google-analytics.com/collect",
+	"t.sidekickopen",
+	"mixpanel.com/track",
+	"/pixel.gif",
+	"/pixel.png",
+}
+
 type Sanitizer struct {
 	policy *bluemonday.Policy
 }
@@ -37,6 +62,88 @@ func NewSanitizer() *Sanitizer {
 	return &Sanitizer{policy: p}
 }
 
+// Sanitize cleans untrusted HTML. Remote images are blocked so that viewing
+// the mail doesn't reveal it was read, and tracking pixels are removed.
+// Links are unwrapped from redirectors and annotated with where they go.
 func (s *Sanitizer) Sanitize(html string) string {
-	return s.policy.Sanitize(html)
+	return links.AnnotateHTML(blockRemoteImages(s.policy.Sanitize(html)))
+}
+
+// blockRemoteImages moves the src of remote images to models.RemoteImageAttr and
+// drops tracking pixels entirely
+func blockRemoteImages(sanitized string) string {
+	var out bytes.Buffer
+	z := html.NewTokenizer(strings.NewReader(sanitized))
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				// Can't happen for bluemonday output, keep what we have
+				return sanitized
+			}
+			return out.String()
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			out.Write(z.Raw())
+			continue
+		}
+
+		token := z.Token()
+		if token.Data != "img" {
+			out.Write(z.Raw())
+			continue
+		}
+
+		src := attrValue(token.Attr, "src")
+		if !isRemoteURL(src) {
+			out.Write(z.Raw())
+			continue
+		}
+		if isTrackingPixel(token.Attr, src) {
+			continue
+		}
+
+		for i := range token.Attr {
+			if token.Attr[i].Key == "src" {
+				token.Attr[i].Key = models.RemoteImageAttr
+			}
+		}
+		out.WriteString(token.String())
+	}
+}
+
+func isRemoteURL(src string) bool {
+	u, err := url.Parse(src)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// isTrackingPixel spots 1x1 images and known tracking endpoints
+func isTrackingPixel(attrs []html.Attribute, src string) bool {
+	width := strings.TrimSuffix(strings.TrimSpace(attrValue(attrs, "width")), "px")
+	height := strings.TrimSuffix(strings.TrimSpace(attrValue(attrs, "height")), "px")
+	if (width == "0" || width == "1") && (height == "0" || height == "1") {
+		return true
+	}
+
+	lower := strings.ToLower(src)
+	for _, pattern := range trackingPixelPatterns {
+		if strings.Contains(lower, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func attrValue(attrs []html.Attribute, key string) string {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
 }
@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+func TestSanitizeBlocksRemoteImages(t *testing.T) {
+	s := NewSanitizer()
+
+	out := s.Sanitize(`<p>Hello</p><img src="https://cdn.example.com/logo.png" alt="Logo" width="120">`)
+
+	if strings.Contains(out, ` src=`) {
+		t.Errorf("remote src kept: %s", out)
+	}
+	if !strings.Contains(out, models.RemoteImageAttr+`="https://cdn.example.com/logo.png"`) {
+		t.Errorf("remote URL not kept for loading: %s", out)
+	}
+	if !strings.Contains(out, `alt="Logo"`) || !strings.Contains(out, "<p>Hello</p>") {
+		t.Errorf("content lost: %s", out)
+	}
+}
+
+func TestSanitizeRemovesTrackingPixels(t *testing.T) {
+	s := NewSanitizer()
+
+	tests := []string{
+		`<img src="https://news.example.com/o.gif?u=1" width="1" height="1">`,
+		`<img src="https://news.example.com/o.gif?u=1" width="0px" height="0px">`,
+		`<img src="https://example.us1.list-manage.com/track/open.php?u=1">`,
+		`<img src="https://u123.ct.sendgrid.net/wf/open?upn=abc">`,
+	}
+
+	for _, input := range tests {
+		out := s.Sanitize("<p>Hi</p>" + input)
+		if strings.Contains(out, "<img") {
+			t.Errorf("tracking pixel kept for %s: %s", input, out)
+		}
+	}
+}
+
+func TestSanitizeStillStripsScripts(t *testing.T) {
+	s := NewSanitizer()
+
+	out := s.Sanitize(`<p onclick="x()">Hi</p><script>alert(1)</script><img src="javascript:alert(1)">`)
+	if strings.Contains(out, "script") || strings.Contains(out, "onclick") || strings.Contains(out, "javascript") {
+		t.Errorf("dangerous content kept: %s", out)
+	}
+}
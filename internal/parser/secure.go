@@ -0,0 +1,443 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/smallstep/pkcs7"
+)
+
+// Signed and encrypted layers can nest, e.g. signed then encrypted
+const maxSecureLayers = 3
+
+// unwrapSecure strips S/MIME and PGP/MIME layers off a message. It returns
+// the message with its innermost content and what was verified on the way,
+// or a nil status when the message wasn't signed or encrypted.
+func unwrapSecure(raw []byte, ring *KeyRing) ([]byte, *models.CryptoStatus) {
+	var status *models.CryptoStatus
+	raw = canonicalCRLF(raw)
+
+	for layer := 0; layer < maxSecureLayers; layer++ {
+		header, body := splitEntity(raw)
+		fields, err := readHeader(header)
+		if err != nil {
+			break
+		}
+
+		mediaType, params, err := mime.ParseMediaType(fields.Get("Content-Type"))
+		if err != nil {
+			break
+		}
+
+		var inner []byte
+		switch {
+		case mediaType == "multipart/signed":
+			parts := splitMultipart(body, params["boundary"])
+			if len(parts) != 2 {
+				return raw, status
+			}
+			status = ensureStatus(status, params["protocol"])
+			verifyDetached(status, parts[0], parts[1], params["protocol"], fromAddress(fields), ring)
+			inner = parts[0]
+
+		case mediaType == "application/pkcs7-mime" || mediaType == "application/x-pkcs7-mime":
+			status = ensureStatus(status, "application/pkcs7")
+			inner = unwrapPKCS7(status, fields, body, fromAddress(fields), ring)
+
+		case mediaType == "multipart/encrypted" && params["protocol"] == "application/pgp-encrypted":
+			status = ensureStatus(status, "application/pgp")
+			parts := splitMultipart(body, params["boundary"])
+			if len(parts) == 2 {
+				_, armored := splitEntity(parts[1])
+				inner = decryptPGP(status, armored, fromAddress(fields), ring)
+			}
+		}
+
+		if inner == nil {
+			break
+		}
+		raw = replaceContent(header, inner)
+	}
+
+	return raw, status
+}
+
+// unwrapInlinePGP handles PGP messages and clearsigned text in a plain body
+// of mail from the address from
+func unwrapInlinePGP(text string, status *models.CryptoStatus, from string, ring *KeyRing) (string, *models.CryptoStatus) {
+	switch {
+	case strings.Contains(text, "-----BEGIN PGP MESSAGE-----"):
+		status = ensureStatus(status, "application/pgp")
+		start := strings.Index(text, "-----BEGIN PGP MESSAGE-----")
+		if plain := decryptPGP(status, []byte(text[start:]), from, ring); plain != nil {
+			return string(plain), status
+		}
+
+	case strings.Contains(text, "-----BEGIN PGP SIGNED MESSAGE-----"):
+		start := strings.Index(text, "-----BEGIN PGP SIGNED MESSAGE-----")
+		block, _ := clearsign.Decode([]byte(text[start:]))
+		if block == nil {
+			return text, status
+		}
+
+		status = ensureStatus(status, "application/pgp")
+		status.Signed = true
+		keyring := openpgp.EntityList{}
+		if ring != nil {
+			keyring = ring.pgp
+		}
+		signer, err := block.VerifySignature(keyring, nil)
+		setPGPSignature(status, signer, err, from)
+		return string(block.Plaintext), status
+	}
+
+	return text, status
+}
+
+func ensureStatus(status *models.CryptoStatus, protocol string) *models.CryptoStatus {
+	if status == nil {
+		status = &models.CryptoStatus{}
+	}
+	if status.Protocol == "" {
+		status.Protocol = models.CryptoKeySMIME
+		if strings.Contains(protocol, "pgp") {
+			status.Protocol = models.CryptoKeyPGP
+		}
+	}
+	return status
+}
+
+func verifyDetached(status *models.CryptoStatus, signed, signaturePart []byte, protocol, from string, ring *KeyRing) {
+	status.Signed = true
+
+	sigHeader, sigBody := splitEntity(signaturePart)
+	fields, _ := readHeader(sigHeader)
+
+	if strings.Contains(protocol, "pgp") {
+		keyring := openpgp.EntityList{}
+		if ring != nil {
+			keyring = ring.pgp
+		}
+		signer, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(signed), bytes.NewReader(sigBody), nil)
+		setPGPSignature(status, signer, err, from)
+		return
+	}
+
+	signature, err := decodeTransfer(fields, sigBody)
+	if err != nil {
+		status.Signature = models.SignatureInvalid
+		status.SignatureError = "unreadable signature"
+		return
+	}
+
+	p7, err := pkcs7.Parse(signature)
+	if err != nil {
+		status.Signature = models.SignatureInvalid
+		status.SignatureError = "unreadable signature"
+		return
+	}
+	p7.Content = signed
+	verifyPKCS7(status, p7, from, ring)
+}
+
+func unwrapPKCS7(status *models.CryptoStatus, fields textproto.MIMEHeader, body []byte, from string, ring *KeyRing) []byte {
+	der, err := decodeTransfer(fields, body)
+	if err != nil {
+		return nil
+	}
+
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		status.DecryptError = "unreadable S/MIME content"
+		return nil
+	}
+
+	// Opaque signed data carries the content itself
+	if len(p7.Signers) > 0 {
+		status.Signed = true
+		verifyPKCS7(status, p7, from, ring)
+		return p7.Content
+	}
+
+	status.Encrypted = true
+	if ring == nil || len(ring.smime) == 0 {
+		status.DecryptError = "no S/MIME key imported"
+		return nil
+	}
+
+	for _, key := range ring.smime {
+		plain, err := p7.Decrypt(key.cert, key.key)
+		if err == nil {
+			status.Decrypted = true
+			status.DecryptError = ""
+			return canonicalCRLF(plain)
+		}
+		status.DecryptError = "no matching S/MIME key"
+	}
+	return nil
+}
+
+func verifyPKCS7(status *models.CryptoStatus, p7 *pkcs7.PKCS7, from string, ring *KeyRing) {
+	// Integrity first, trust second
+	if err := p7.Verify(); err != nil {
+		status.Signature = models.SignatureInvalid
+		status.SignatureError = "signature does not match the content"
+		return
+	}
+
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		status.Signature = models.SignatureUnverified
+		status.SignatureError = "no signer certificate"
+		return
+	}
+
+	status.Signer = signer.Subject.CommonName
+	if len(signer.EmailAddresses) > 0 {
+		status.Signer = signer.EmailAddresses[0]
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if ring != nil {
+		for _, cert := range ring.certs {
+			roots.AddCert(cert)
+		}
+	}
+
+	if err := p7.VerifyWithChain(roots); err != nil {
+		status.Signature = models.SignatureUnverified
+		status.SignatureError = "certificate is not trusted"
+		return
+	}
+
+	if !certMatchesAddress(signer, from) {
+		status.Signature = models.SignatureUnverified
+		status.SignatureError = "certificate does not belong to the sender"
+		return
+	}
+
+	status.Signature = models.SignatureVerified
+}
+
+func decryptPGP(status *models.CryptoStatus, armored []byte, from string, ring *KeyRing) []byte {
+	status.Encrypted = true
+
+	block, err := armor.Decode(bytes.NewReader(armored))
+	if err != nil {
+		status.DecryptError = "unreadable PGP message"
+		return nil
+	}
+
+	if ring == nil || len(ring.pgp.DecryptionKeys()) == 0 {
+		status.DecryptError = "no OpenPGP key imported"
+		return nil
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, ring.pgp, nil, nil)
+	if err != nil {
+		status.DecryptError = "no matching OpenPGP key"
+		return nil
+	}
+
+	plain, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil && !md.IsSigned {
+		status.DecryptError = "message is corrupted"
+		return nil
+	}
+
+	status.Decrypted = true
+	status.DecryptError = ""
+
+	if md.IsSigned {
+		status.Signed = true
+		var signer *openpgp.Entity
+		if md.SignedBy != nil {
+			signer = md.SignedBy.Entity
+		}
+		sigErr := md.SignatureError
+		if signer == nil {
+			sigErr = fmt.Errorf("unknown key %X", md.SignedByKeyId)
+		}
+		setPGPSignature(status, signer, sigErr, from)
+	}
+
+	return canonicalCRLF(plain)
+}
+
+// setPGPSignature records a signature check. A good signature only counts
+// as verified when the key has a user ID with the sender's address.
+func setPGPSignature(status *models.CryptoStatus, signer *openpgp.Entity, err error, from string) {
+	if signer != nil {
+		status.Signer = signer.PrimaryKey.KeyIdString()
+		if id := signer.PrimaryIdentity(); id != nil {
+			status.Signer = id.Name
+		}
+	}
+
+	switch {
+	case err == nil && signer != nil && !entityMatchesAddress(signer, from):
+		status.Signature = models.SignatureUnverified
+		status.SignatureError = "key does not belong to the sender"
+	case err == nil && signer != nil:
+		status.Signature = models.SignatureVerified
+	case signer == nil:
+		status.Signature = models.SignatureUnverified
+		status.SignatureError = "signer key is not imported"
+	default:
+		status.Signature = models.SignatureInvalid
+		status.SignatureError = "signature does not match the content"
+	}
+}
+
+func certMatchesAddress(cert *x509.Certificate, address string) bool {
+	for _, email := range cert.EmailAddresses {
+		if strings.EqualFold(email, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func entityMatchesAddress(entity *openpgp.Entity, address string) bool {
+	for _, id := range entity.Identities {
+		if id.UserId != nil && strings.EqualFold(id.UserId.Email, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func fromAddress(fields textproto.MIMEHeader) string {
+	return addressOf(fields.Get("From"))
+}
+
+// addressOf returns the address of a From header, empty when unreadable
+func addressOf(from string) string {
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return ""
+	}
+	return addr.Address
+}
+
+func decodeTransfer(fields textproto.MIMEHeader, body []byte) ([]byte, error) {
+	if !strings.EqualFold(strings.TrimSpace(fields.Get("Content-Transfer-Encoding")), "base64") {
+		return body, nil
+	}
+
+	clean := bytes.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, body)
+	return base64.StdEncoding.DecodeString(string(clean))
+}
+
+// canonicalCRLF converts line endings to CRLF, which signatures are made over
+func canonicalCRLF(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+}
+
+// splitEntity splits a MIME entity into its header block (ending in CRLF)
+// and body
+func splitEntity(entity []byte) ([]byte, []byte) {
+	if bytes.HasPrefix(entity, []byte("\r\n")) {
+		return nil, entity[2:]
+	}
+	i := bytes.Index(entity, []byte("\r\n\r\n"))
+	if i < 0 {
+		return entity, nil
+	}
+	return entity[:i+2], entity[i+4:]
+}
+
+func readHeader(header []byte) (textproto.MIMEHeader, error) {
+	reader := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(header), strings.NewReader("\r\n"))))
+	return reader.ReadMIMEHeader()
+}
+
+// splitMultipart returns the raw bytes of each body part, exactly as they
+// were signed
+func splitMultipart(body []byte, boundary string) [][]byte {
+	if boundary == "" {
+		return nil
+	}
+
+	delimiter := []byte("\r\n--" + boundary)
+	data := append([]byte("\r\n"), body...)
+
+	var parts [][]byte
+	pos, start := 0, -1
+	for {
+		i := bytes.Index(data[pos:], delimiter)
+		if i < 0 {
+			return parts
+		}
+		i += pos
+
+		if start >= 0 {
+			if start > i {
+				start = i
+			}
+			parts = append(parts, data[start:i])
+		}
+
+		rest := data[i+len(delimiter):]
+		if bytes.HasPrefix(rest, []byte("--")) {
+			return parts
+		}
+
+		eol := bytes.Index(rest, []byte("\r\n"))
+		if eol < 0 {
+			return parts
+		}
+
+		// The next search starts at the CRLF ending the delimiter line, so
+		// an empty part is still found
+		pos = i + len(delimiter) + eol
+		start = pos + 2
+	}
+}
+
+// replaceContent swaps the content headers of the outer message for those
+// of the inner entity, keeping From, Subject and the other envelope fields
+func replaceContent(header, inner []byte) []byte {
+	var out bytes.Buffer
+	skipping := false
+
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			name := strings.ToLower(strings.TrimSpace(strings.SplitN(line, ":", 2)[0]))
+			skipping = strings.HasPrefix(name, "content-")
+		}
+		if !skipping {
+			out.WriteString(line)
+		}
+	}
+
+	innerHeader, innerBody := splitEntity(inner)
+	out.Write(innerHeader)
+	out.WriteString("\r\n")
+	out.Write(innerBody)
+	return out.Bytes()
+}
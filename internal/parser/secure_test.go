@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/smallstep/pkcs7"
+)
+
+const testInner = "Content-Type: text/plain; charset=utf-8\r\n\r\nWire the money today.\r\n"
+
+func TestUnwrapPGPEncrypted(t *testing.T) {
+	entity, err := openpgp.NewEntity("Vendor", "", "vendor@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var armored bytes.Buffer
+	aw, _ := armor.Encode(&armored, "PGP MESSAGE", nil)
+	pw, err := openpgp.Encrypt(aw, []*openpgp.Entity{entity}, entity, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.Write([]byte(testInner))
+	pw.Close()
+	aw.Close()
+
+	raw := "From: vendor@example.com\r\n" +
+		"Subject: Invoice\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: application/pgp-encrypted\r\n\r\nVersion: 1\r\n" +
+		"--b1\r\n" +
+		"Content-Type: application/octet-stream\r\n\r\n" + armored.String() + "\r\n" +
+		"--b1--\r\n"
+
+	// Without keys the message stays encrypted
+	_, status := unwrapSecure([]byte(raw), nil)
+	if status == nil || !status.Encrypted || status.Decrypted {
+		t.Fatalf("unexpected status without keys: %+v", status)
+	}
+
+	out, status := unwrapSecure([]byte(raw), &KeyRing{pgp: openpgp.EntityList{entity}})
+	if !status.Decrypted || status.Signature != models.SignatureVerified {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if !strings.Contains(string(out), "Subject: Invoice") || !strings.Contains(string(out), "Wire the money") {
+		t.Errorf("unexpected content:\n%s", out)
+	}
+
+	// A key of another sender doesn't vouch for this one
+	spoofed := strings.Replace(raw, "From: vendor@example.com", "From: ceo@example.com", 1)
+	_, status = unwrapSecure([]byte(spoofed), &KeyRing{pgp: openpgp.EntityList{entity}})
+	if status.Signature != models.SignatureUnverified || status.SignatureError != "key does not belong to the sender" {
+		t.Errorf("unexpected status for another sender: %+v", status)
+	}
+}
+
+func TestUnwrapSMIMESigned(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: "Vendor"},
+		EmailAddresses: []string{"vendor@example.com"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	sd, _ := pkcs7.NewSignedData([]byte(testInner))
+	if err := sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	sd.Detach()
+	signature, _ := sd.Finish()
+
+	build := func(content string) []byte {
+		return []byte("From: Vendor <vendor@example.com>\r\n" +
+			"Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=\"s1\"\r\n" +
+			"\r\n" +
+			"--s1\r\n" + content + "\r\n" +
+			"--s1\r\n" +
+			"Content-Type: application/pkcs7-signature; name=smime.p7s\r\n" +
+			"Content-Transfer-Encoding: base64\r\n\r\n" +
+			base64.StdEncoding.EncodeToString(signature) + "\r\n" +
+			"--s1--\r\n")
+	}
+
+	// Self-signed, so only trusted once the user imported the certificate
+	_, status := unwrapSecure(build(testInner), nil)
+	if status.Signature != models.SignatureUnverified {
+		t.Errorf("untrusted signature: got %+v", status)
+	}
+
+	out, status := unwrapSecure(build(testInner), &KeyRing{certs: []*x509.Certificate{cert}})
+	if status.Signature != models.SignatureVerified || status.Signer != "vendor@example.com" {
+		t.Errorf("trusted signature: got %+v", status)
+	}
+	if strings.Contains(string(out), "smime.p7s") {
+		t.Error("signature part was not removed")
+	}
+
+	_, status = unwrapSecure(build(strings.Replace(testInner, "today", "now", 1)), nil)
+	if status.Signature != models.SignatureInvalid {
+		t.Errorf("tampered content: got %+v", status)
+	}
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A Message-ID in In-Reply-To or References, with its angle brackets
+var messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+
+// How many referenced messages are looked up to find a thread
+const maxReferencedIDs = 20
+
+// ReferencedIDs returns the Message-IDs the email replies to, nearest
+// first: In-Reply-To, then References from the end
+func (p *ParsedEmail) ReferencedIDs() []string {
+	var ids []string
+	seen := make(map[string]bool)
+	add := func(id string) {
+		if !seen[id] && len(ids) < maxReferencedIDs {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if p.InReplyTo != nil {
+		for _, id := range parseMessageIDs(*p.InReplyTo) {
+			add(id)
+		}
+	}
+	if p.References != nil {
+		refs := parseMessageIDs(*p.References)
+		for i := len(refs) - 1; i >= 0; i-- {
+			add(refs[i])
+		}
+	}
+	return ids
+}
+
+// ThreadRoot returns the Message-ID of the first email of the thread as
+// far as the headers tell, or messageID for an email that starts one
+func (p *ParsedEmail) ThreadRoot(messageID string) string {
+	if p.References != nil {
+		if refs := parseMessageIDs(*p.References); len(refs) > 0 {
+			return refs[0]
+		}
+	}
+	if p.InReplyTo != nil {
+		if ids := parseMessageIDs(*p.InReplyTo); len(ids) > 0 {
+			return ids[0]
+		}
+	}
+	return messageID
+}
+
+// parseMessageIDs reads the Message-IDs of a header. Some mailers leave
+// out the angle brackets, their IDs are split on whitespace.
+func parseMessageIDs(header string) []string {
+	if ids := messageIDPattern.FindAllString(header, -1); len(ids) > 0 {
+		return ids
+	}
+
+	var ids []string
+	for _, id := range strings.Fields(header) {
+		if strings.Contains(id, "@") {
+			ids = append(ids, "<"+id+">")
+		}
+	}
+	return ids
+}
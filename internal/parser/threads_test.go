@@ -0,0 +1,42 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReferencedIDs(t *testing.T) {
+	inReplyTo := "<c@example.com>"
+	references := "<a@example.com>\r\n <b@example.com> <c@example.com>"
+	parsed := &ParsedEmail{InReplyTo: &inReplyTo, References: &references}
+
+	want := []string{"<c@example.com>", "<b@example.com>", "<a@example.com>"}
+	if got := parsed.ReferencedIDs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReferencedIDs() = %v, want %v", got, want)
+	}
+	if got := parsed.ThreadRoot("<d@example.com>"); got != "<a@example.com>" {
+		t.Errorf("ThreadRoot() = %q, want <a@example.com>", got)
+	}
+}
+
+func TestThreadRoot(t *testing.T) {
+	bare := "b@example.com"
+
+	tests := []struct {
+		name      string
+		inReplyTo *string
+		want      string
+	}{
+		{"new thread", nil, "<d@example.com>"},
+		{"reply without references", &bare, "<b@example.com>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed := &ParsedEmail{InReplyTo: tt.inReplyTo}
+			if got := parsed.ThreadRoot("<d@example.com>"); got != tt.want {
+				t.Errorf("ThreadRoot() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// MailActionQueueKey is the list telegram-service asks mail-fetcher to
+// change messages on the mail server through
+const MailActionQueueKey = "mail-to-tg:queue:actions"
+
+// Mail actions
+const (
+	MailActionMarkRead = "mark_read"
+)
+
+type MailAction struct {
+	Action    string `json:"action"`
+	EmailID   string `json:"email_id"`
+	AccountID string `json:"account_id"`
+}
+
+func (p *Publisher) PublishMailAction(action *MailAction) error {
+	data, err := json.Marshal(action)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mail action: %w", err)
+	}
+
+	if err := p.redis.LPush(MailActionQueueKey, data); err != nil {
+		return fmt.Errorf("failed to push mail action: %w", err)
+	}
+
+	log.Debug().
+		Str("action", action.Action).
+		Str("email_id", action.EmailID).
+		Msg("Published mail action")
+
+	return nil
+}
+
+// ActionConsumer runs mail actions in mail-fetcher, which holds the
+// connections to the mail servers. Actions are best effort: a failed one
+// is logged and dropped.
+type ActionConsumer struct {
+	redis   *storage.Redis
+	handler func(*MailAction) error
+	stopped bool
+}
+
+func NewActionConsumer(redis *storage.Redis, handler func(*MailAction) error) *ActionConsumer {
+	return &ActionConsumer{
+		redis:   redis,
+		handler: handler,
+	}
+}
+
+func (c *ActionConsumer) Start() error {
+	log.Info().Msg("Starting mail action consumer")
+
+	for !c.stopped {
+		result, err := c.redis.BRPop(5*time.Second, MailActionQueueKey)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to pop from mail action queue")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		// result is [queueKey, value], nil on timeout
+		if len(result) < 2 {
+			continue
+		}
+
+		var action MailAction
+		if err := json.Unmarshal([]byte(result[1]), &action); err != nil {
+			log.Error().Err(err).Str("data", result[1]).Msg("Failed to unmarshal mail action")
+			continue
+		}
+
+		if err := c.handler(&action); err != nil {
+			log.Error().
+				Err(err).
+				Str("action", action.Action).
+				Str("email_id", action.EmailID).
+				Msg("Failed to run mail action")
+		}
+	}
+
+	log.Info().Msg("Mail action consumer stopped")
+	return nil
+}
+
+func (c *ActionConsumer) Stop() {
+	log.Info().Msg("Stopping mail action consumer")
+	c.stopped = true
+}
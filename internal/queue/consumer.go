@@ -2,65 +2,104 @@ package queue
 
 import (
 	"encoding/json"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/kexi/mail-to-tg/pkg/config"
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	readCount = 10
+	readBlock = 5 * time.Second
+	noBlock   = -1
+
+	// Reads that start at a lower lane, see read
+	normalLaneEvery = 4
+	lowLaneEvery    = 8
+)
+
+// Consumer reads email events from the stream as a member of the notifier
+// group. An event is acknowledged once the handler succeeded or the failure
+// was recorded: failed events are retried with exponential backoff and end
+// up in the dead-letter set after MaxAttempts. Events left pending by a
+// crashed instance are claimed again after ClaimIdleSeconds.
 type Consumer struct {
-	redis   *storage.Redis
-	handler func(*EmailEvent) error
-	stopped bool
+	redis       *storage.Redis
+	handler     func(*EmailEvent) error
+	dispatch    func(event *EmailEvent, run func())
+	name        string
+	claimIdle   time.Duration
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+	stopped     bool
+	reads       int
+
+	// Entries handed to dispatch and not finished yet, so reclaiming our
+	// own pending entries doesn't run them twice, by stream and ID
+	mu       sync.Mutex
+	inFlight map[string]bool
 }
 
-func NewConsumer(redis *storage.Redis, handler func(*EmailEvent) error) *Consumer {
+func NewConsumer(redis *storage.Redis, cfg *config.QueueConfig, handler func(*EmailEvent) error) *Consumer {
 	return &Consumer{
-		redis:   redis,
-		handler: handler,
+		redis:       redis,
+		handler:     handler,
+		name:        cfg.ConsumerName,
+		claimIdle:   time.Duration(cfg.ClaimIdleSeconds) * time.Second,
+		maxAttempts: cfg.MaxAttempts,
+		retryBase:   time.Duration(cfg.RetryBaseSeconds) * time.Second,
+		retryMax:    time.Duration(cfg.RetryMaxSeconds) * time.Second,
+		inFlight:    make(map[string]bool),
 	}
 }
 
+// SetDispatch lets events be handled concurrently. dispatch must call run
+// exactly once, which handles the event and acknowledges it; it may block
+// to hold back reading. Without it events are handled one at a time.
+func (c *Consumer) SetDispatch(dispatch func(event *EmailEvent, run func())) {
+	c.dispatch = dispatch
+}
+
 func (c *Consumer) Start() error {
-	log.Info().Msg("Starting queue consumer")
+	log.Info().Str("consumer", c.name).Msg("Starting queue consumer")
 
-	for !c.stopped {
-		result, err := c.redis.BRPop(5*time.Second, EmailQueueKey)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to pop from queue")
-			time.Sleep(time.Second)
-			continue
+	for _, stream := range laneStreams {
+		if err := c.redis.XGroupCreate(stream, NotifierGroup); err != nil {
+			return fmt.Errorf("failed to create consumer group on %s: %w", stream, err)
 		}
+	}
 
-		// BRPop returns nil when timeout
-		if result == nil {
-			continue
-		}
+	if err := c.MigrateLegacyQueue(); err != nil {
+		log.Error().Err(err).Msg("Failed to migrate legacy queue")
+	}
 
-		// result is [queueKey, value]
-		if len(result) < 2 {
-			continue
+	var lastClaim time.Time
+	for !c.stopped {
+		// Pick up what crashed or failed consumers left behind
+		if time.Since(lastClaim) >= c.claimIdle/2 {
+			for _, stream := range laneStreams {
+				c.reclaim(stream)
+			}
+			lastClaim = time.Now()
 		}
+		c.promoteRetries()
 
-		data := result[1]
-		var event EmailEvent
-		if err := json.Unmarshal([]byte(data), &event); err != nil {
-			log.Error().Err(err).Str("data", data).Msg("Failed to unmarshal email event")
+		streams, err := c.read()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read from stream")
+			time.Sleep(time.Second)
 			continue
 		}
 
-		log.Debug().
-			Str("email_id", event.EmailID).
-			Str("account_id", event.AccountID).
-			Str("user_id", event.UserID).
-			Msg("Processing email event from queue")
-
-		if err := c.handler(&event); err != nil {
-			log.Error().
-				Err(err).
-				Str("email_id", event.EmailID).
-				Msg("Failed to handle email event")
-			// In production, consider re-queuing or dead-letter queue
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				c.process(stream.Stream, message)
+			}
 		}
 	}
 
@@ -72,3 +111,160 @@ func (c *Consumer) Stop() {
 	log.Info().Msg("Stopping queue consumer")
 	c.stopped = true
 }
+
+// read takes the next batch from the highest lane that has events waiting,
+// blocking until any lane has some. Every few reads a lower lane is tried
+// first, so a steady flow of high priority mail can't starve the rest.
+func (c *Consumer) read() ([]redis.XStream, error) {
+	c.reads++
+
+	first := 0
+	switch {
+	case c.reads%lowLaneEvery == 0:
+		first = 2
+	case c.reads%normalLaneEvery == 0:
+		first = 1
+	}
+
+	order := []string{laneStreams[first]}
+	for i, stream := range laneStreams {
+		if i != first {
+			order = append(order, stream)
+		}
+	}
+
+	for _, stream := range order {
+		streams, err := c.redis.XReadGroup(NotifierGroup, c.name, []string{stream}, readCount, noBlock)
+		if err != nil {
+			return nil, err
+		}
+		if len(streams) > 0 && len(streams[0].Messages) > 0 {
+			return streams, nil
+		}
+	}
+
+	// Nothing waiting anywhere
+	return c.redis.XReadGroup(NotifierGroup, c.name, laneStreams, readCount, readBlock)
+}
+
+// MigrateLegacyQueue moves events still in the old list onto the stream,
+// oldest first
+func (c *Consumer) MigrateLegacyQueue() error {
+	moved := 0
+	for {
+		data, err := c.redis.LPop(EmailQueueKey)
+		if err != nil {
+			return fmt.Errorf("failed to pop legacy event: %w", err)
+		}
+		if data == "" {
+			break
+		}
+
+		if _, err := c.redis.XAdd(EmailStreamKey, map[string]interface{}{eventField: data}); err != nil {
+			// Put it back at the head so nothing is lost
+			c.redis.LPush(EmailQueueKey, data)
+			return fmt.Errorf("failed to add legacy event to stream: %w", err)
+		}
+		moved++
+	}
+
+	if moved > 0 {
+		log.Info().Int("count", moved).Msg("Moved events from the legacy queue to the stream")
+	}
+	return nil
+}
+
+// reclaim takes over entries of a lane that have been pending for too long
+func (c *Consumer) reclaim(stream string) {
+	start := "0-0"
+	for {
+		messages, next, err := c.redis.XAutoClaim(stream, NotifierGroup, c.name, c.claimIdle, start, readCount)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to claim pending events")
+			return
+		}
+
+		for _, message := range messages {
+			log.Warn().Str("stream", stream).Str("entry_id", message.ID).Msg("Claimed pending email event")
+			c.process(stream, message)
+		}
+
+		if next == "0-0" || next == "" {
+			return
+		}
+		start = next
+	}
+}
+
+func (c *Consumer) process(stream string, message redis.XMessage) {
+	data, _ := message.Values[eventField].(string)
+
+	var event EmailEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		// Can never succeed, don't let it come back
+		log.Error().Err(err).Str("entry_id", message.ID).Str("data", data).Msg("Failed to unmarshal email event")
+		c.ack(stream, message.ID)
+		return
+	}
+
+	key := stream + "/" + message.ID
+	c.mu.Lock()
+	if c.inFlight[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.inFlight[key] = true
+	c.mu.Unlock()
+
+	run := func() {
+		c.handle(stream, message.ID, &event)
+
+		c.mu.Lock()
+		delete(c.inFlight, key)
+		c.mu.Unlock()
+	}
+
+	if c.dispatch != nil {
+		c.dispatch(&event, run)
+		return
+	}
+	run()
+}
+
+func (c *Consumer) handle(stream, id string, event *EmailEvent) {
+	log.Debug().
+		Str("email_id", event.EmailID).
+		Str("account_id", event.AccountID).
+		Str("user_id", event.UserID).
+		Str("priority", string(event.Priority)).
+		Str("entry_id", id).
+		Msg("Processing email event from stream")
+
+	if err := c.handler(event); err != nil {
+		log.Error().
+			Err(err).
+			Str("email_id", event.EmailID).
+			Str("entry_id", id).
+			Msg("Failed to handle email event")
+
+		if err := c.handleFailure(event, err); err != nil {
+			// Left pending, it is claimed again after claimIdle
+			log.Error().Err(err).Str("entry_id", id).Msg("Failed to record email event failure")
+			return
+		}
+	}
+
+	c.ack(stream, id)
+}
+
+// ack acknowledges an entry and removes it, so the stream only holds
+// events that still need work
+func (c *Consumer) ack(stream, id string) {
+	if err := c.redis.XAck(stream, NotifierGroup, id); err != nil {
+		log.Error().Err(err).Str("entry_id", id).Msg("Failed to acknowledge email event")
+		return
+	}
+	if err := c.redis.XDel(stream, id); err != nil {
+		log.Error().Err(err).Str("entry_id", id).Msg("Failed to delete email event")
+	}
+}
@@ -0,0 +1,303 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+func newTestRedis(t *testing.T) (*storage.Redis, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	redis, err := storage.NewRedis(&config.RedisConfig{
+		Host: mr.Host(),
+		Port: mr.Server().Addr().Port,
+	})
+	if err != nil {
+		t.Fatalf("NewRedis: %v", err)
+	}
+	t.Cleanup(func() { redis.Close() })
+	return redis, mr
+}
+
+func createGroups(t *testing.T, redis *storage.Redis) {
+	t.Helper()
+	for _, stream := range laneStreams {
+		if err := redis.XGroupCreate(stream, NotifierGroup); err != nil {
+			t.Fatalf("XGroupCreate: %v", err)
+		}
+	}
+}
+
+// readEntries reads what is waiting in the normal lane
+func readEntries(redis *storage.Redis, consumer string) ([]goredis.XMessage, error) {
+	streams, err := redis.XReadGroup(NotifierGroup, consumer, []string{EmailStreamKey}, 10, noBlock)
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	return streams[0].Messages, nil
+}
+
+func TestConsumerAcknowledgesHandledEvents(t *testing.T) {
+	redis, _ := newTestRedis(t)
+	publisher := NewPublisher(redis)
+
+	var handled []string
+	consumer := NewConsumer(redis, &config.QueueConfig{ConsumerName: "a", ClaimIdleSeconds: 60}, func(event *EmailEvent) error {
+		handled = append(handled, event.EmailID)
+		return nil
+	})
+	createGroups(t, redis)
+
+	for _, id := range []string{"e1", "e2"} {
+		if err := publisher.PublishEmailEvent(&EmailEvent{EmailID: id}); err != nil {
+			t.Fatalf("PublishEmailEvent: %v", err)
+		}
+	}
+
+	messages, err := readEntries(redis, "a")
+	if err != nil {
+		t.Fatalf("XReadGroup: %v", err)
+	}
+	for _, message := range messages {
+		consumer.process(EmailStreamKey, message)
+	}
+
+	if len(handled) != 2 || handled[0] != "e1" || handled[1] != "e2" {
+		t.Errorf("handled = %v, want [e1 e2]", handled)
+	}
+	if n, _ := publisher.GetQueueLength(); n != 0 {
+		t.Errorf("stream length = %d, want 0", n)
+	}
+	if n, _ := redis.XPendingCount(EmailStreamKey, NotifierGroup); n != 0 {
+		t.Errorf("pending = %d, want 0", n)
+	}
+}
+
+func TestConsumerReclaimsAbandonedEvents(t *testing.T) {
+	redis, _ := newTestRedis(t)
+	publisher := NewPublisher(redis)
+	createGroups(t, redis)
+
+	if err := publisher.PublishEmailEvent(&EmailEvent{EmailID: "e1"}); err != nil {
+		t.Fatalf("PublishEmailEvent: %v", err)
+	}
+
+	// Instance a reads the event and dies before handling it
+	messages, err := readEntries(redis, "a")
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("XReadGroup = %v, %v", messages, err)
+	}
+	if n, _ := redis.XPendingCount(EmailStreamKey, NotifierGroup); n != 1 {
+		t.Fatalf("pending = %d, want 1", n)
+	}
+
+	// Another instance takes it over
+	var handled []string
+	other := NewConsumer(redis, &config.QueueConfig{ConsumerName: "b"}, func(event *EmailEvent) error {
+		handled = append(handled, event.EmailID)
+		return nil
+	})
+	other.reclaim(EmailStreamKey)
+
+	if len(handled) != 1 || handled[0] != "e1" {
+		t.Errorf("handled = %v, want [e1]", handled)
+	}
+	if n, _ := redis.XPendingCount(EmailStreamKey, NotifierGroup); n != 0 {
+		t.Errorf("pending = %d, want 0", n)
+	}
+}
+
+func TestConsumerRetriesAndDeadLetters(t *testing.T) {
+	redis, _ := newTestRedis(t)
+	publisher := NewPublisher(redis)
+	createGroups(t, redis)
+
+	cfg := &config.QueueConfig{ConsumerName: "a", MaxAttempts: 2}
+	attempts := 0
+	consumer := NewConsumer(redis, cfg, func(*EmailEvent) error {
+		attempts++
+		return errors.New("telegram unavailable")
+	})
+
+	if err := publisher.PublishEmailEvent(&EmailEvent{EmailID: "e1", UserID: "u1"}); err != nil {
+		t.Fatalf("PublishEmailEvent: %v", err)
+	}
+
+	deliver := func() {
+		t.Helper()
+		messages, err := readEntries(redis, "a")
+		if err != nil || len(messages) != 1 {
+			t.Fatalf("XReadGroup = %v, %v", messages, err)
+		}
+		consumer.process(EmailStreamKey, messages[0])
+	}
+
+	// First failure is scheduled for a retry, due immediately with no backoff
+	deliver()
+	if n, _ := redis.ZCard(EmailRetryKey); n != 1 {
+		t.Fatalf("retry schedule = %d, want 1", n)
+	}
+	if n, _ := redis.XPendingCount(EmailStreamKey, NotifierGroup); n != 0 {
+		t.Errorf("pending = %d, want 0", n)
+	}
+
+	consumer.promoteRetries()
+	if n, _ := redis.ZCard(EmailRetryKey); n != 0 {
+		t.Fatalf("retry schedule = %d after promotion, want 0", n)
+	}
+
+	// Second failure runs out of attempts
+	deliver()
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+
+	letters, err := publisher.DeadLetters()
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("DeadLetters = %v, %v", letters, err)
+	}
+	if letters[0].Event.EmailID != "e1" || letters[0].Attempts != 2 || letters[0].Error != "telegram unavailable" {
+		t.Errorf("dead letter = %+v", letters[0])
+	}
+
+	// Replaying puts it back with fresh attempts
+	if ok, err := publisher.ReplayDeadLetter("e1"); err != nil || !ok {
+		t.Fatalf("ReplayDeadLetter = %v, %v", ok, err)
+	}
+	if letters, _ := publisher.DeadLetters(); len(letters) != 0 {
+		t.Errorf("dead letters after replay = %d, want 0", len(letters))
+	}
+	messages, _ := readEntries(redis, "a")
+	if len(messages) != 1 || messages[0].Values[eventField] != `{"email_id":"e1","account_id":"","user_id":"u1"}` {
+		t.Errorf("replayed = %v", messages)
+	}
+}
+
+func TestConsumerPermanentFailure(t *testing.T) {
+	redis, _ := newTestRedis(t)
+	consumer := NewConsumer(redis, &config.QueueConfig{ConsumerName: "a", MaxAttempts: 5}, nil)
+
+	if err := consumer.handleFailure(&EmailEvent{EmailID: "e1"}, Permanent(errors.New("blocked"))); err != nil {
+		t.Fatalf("handleFailure: %v", err)
+	}
+
+	if n, _ := redis.ZCard(EmailRetryKey); n != 0 {
+		t.Errorf("retry schedule = %d, want 0", n)
+	}
+	if letters, _ := NewPublisher(redis).DeadLetters(); len(letters) != 1 {
+		t.Errorf("dead letters = %d, want 1", len(letters))
+	}
+}
+
+func TestReplayUserDeadLetters(t *testing.T) {
+	redis, _ := newTestRedis(t)
+	consumer := NewConsumer(redis, &config.QueueConfig{ConsumerName: "a", MaxAttempts: 5}, nil)
+
+	consumer.handleFailure(&EmailEvent{EmailID: "e1", UserID: "u1"}, Permanent(errors.New("blocked")))
+	consumer.handleFailure(&EmailEvent{EmailID: "e2", UserID: "u2"}, Permanent(errors.New("blocked")))
+
+	replayed, err := NewPublisher(redis).ReplayUserDeadLetters("u1")
+	if err != nil || replayed != 1 {
+		t.Fatalf("ReplayUserDeadLetters = %d, %v; want 1", replayed, err)
+	}
+
+	if dead, _ := NewPublisher(redis).IsDeadLettered("e1"); dead {
+		t.Error("e1 is still dead-lettered")
+	}
+	if dead, _ := NewPublisher(redis).IsDeadLettered("e2"); !dead {
+		t.Error("e2 of another user was replayed")
+	}
+	if n, _ := redis.XLen(EmailStreamKey); n != 1 {
+		t.Errorf("stream length = %d, want 1", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	consumer := &Consumer{retryBase: 5 * time.Second, retryMax: time.Minute}
+
+	want := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, w := range want {
+		if got := consumer.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestMigrateLegacyQueue(t *testing.T) {
+	redis, _ := newTestRedis(t)
+
+	redis.RPush(EmailQueueKey, `{"email_id":"old1"}`)
+	redis.RPush(EmailQueueKey, `{"email_id":"old2"}`)
+
+	consumer := NewConsumer(redis, &config.QueueConfig{ConsumerName: "a"}, nil)
+	if err := consumer.MigrateLegacyQueue(); err != nil {
+		t.Fatalf("MigrateLegacyQueue: %v", err)
+	}
+
+	if n, _ := redis.LLen(EmailQueueKey); n != 0 {
+		t.Errorf("legacy queue length = %d, want 0", n)
+	}
+	if n, _ := redis.XLen(EmailStreamKey); n != 2 {
+		t.Errorf("stream length = %d, want 2", n)
+	}
+}
+
+func TestConsumerDrainsHighPriorityFirst(t *testing.T) {
+	redis, _ := newTestRedis(t)
+	publisher := NewPublisher(redis)
+	createGroups(t, redis)
+
+	for i := 0; i < 100; i++ {
+		if i < 20 {
+			publisher.PublishEmailEvent(&EmailEvent{EmailID: fmt.Sprintf("low%d", i), Priority: models.PriorityLow})
+		}
+		publisher.PublishEmailEvent(&EmailEvent{EmailID: fmt.Sprintf("normal%d", i)})
+	}
+	publisher.PublishEmailEvent(&EmailEvent{EmailID: "otp", Priority: models.PriorityHigh})
+
+	var handled []string
+	consumer := NewConsumer(redis, &config.QueueConfig{ConsumerName: "a"}, func(event *EmailEvent) error {
+		handled = append(handled, event.EmailID)
+		return nil
+	})
+
+	for len(handled) < 121 {
+		streams, err := consumer.read()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				consumer.process(stream.Stream, message)
+			}
+		}
+	}
+
+	if handled[0] != "otp" {
+		t.Errorf("first handled = %s, want otp", handled[0])
+	}
+
+	// Low priority events get a turn before the normal lane is empty
+	firstLow, lastNormal := -1, -1
+	for i, id := range handled {
+		if strings.HasPrefix(id, "low") && firstLow < 0 {
+			firstLow = i
+		}
+		if strings.HasPrefix(id, "normal") {
+			lastNormal = i
+		}
+	}
+	if firstLow > lastNormal {
+		t.Errorf("low lane starved until the normal lane was empty: %v", handled)
+	}
+}
@@ -5,17 +5,47 @@ import (
 	"fmt"
 
 	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/kexi/mail-to-tg/pkg/models"
 	"github.com/rs/zerolog/log"
 )
 
 const (
+	// EmailStreamKey is the Redis stream for normal priority email events,
+	// the high and low priority lanes have their own
+	EmailStreamKey     = "mail-to-tg:stream:emails"
+	EmailHighStreamKey = "mail-to-tg:stream:emails:high"
+	EmailLowStreamKey  = "mail-to-tg:stream:emails:low"
+
+	// NotifierGroup is the consumer group of the telegram-service instances
+	NotifierGroup = "notifier"
+
+	// EmailQueueKey is the list used before the stream. Events still in it
+	// are moved over by Consumer.MigrateLegacyQueue.
 	EmailQueueKey = "mail-to-tg:queue:emails"
+
+	eventField = "event"
 )
 
+// laneStreams lists the lanes in the order they are drained
+var laneStreams = []string{EmailHighStreamKey, EmailStreamKey, EmailLowStreamKey}
+
+// StreamFor returns the lane of a priority, normal when unknown
+func StreamFor(priority models.Priority) string {
+	switch priority {
+	case models.PriorityHigh:
+		return EmailHighStreamKey
+	case models.PriorityLow:
+		return EmailLowStreamKey
+	}
+	return EmailStreamKey
+}
+
 type EmailEvent struct {
-	EmailID   string `json:"email_id"`
-	AccountID string `json:"account_id"`
-	UserID    string `json:"user_id"`
+	EmailID   string          `json:"email_id"`
+	AccountID string          `json:"account_id"`
+	UserID    string          `json:"user_id"`
+	Priority  models.Priority `json:"priority,omitempty"`
+	Attempts  int             `json:"attempts,omitempty"` // Failed deliveries so far
 }
 
 type Publisher struct {
@@ -32,19 +62,32 @@ func (p *Publisher) PublishEmailEvent(event *EmailEvent) error {
 		return fmt.Errorf("failed to marshal email event: %w", err)
 	}
 
-	if err := p.redis.RPush(EmailQueueKey, data); err != nil {
-		return fmt.Errorf("failed to push to queue: %w", err)
+	id, err := p.redis.XAdd(StreamFor(event.Priority), map[string]interface{}{eventField: data})
+	if err != nil {
+		return fmt.Errorf("failed to add to stream: %w", err)
 	}
 
 	log.Debug().
 		Str("email_id", event.EmailID).
 		Str("account_id", event.AccountID).
 		Str("user_id", event.UserID).
-		Msg("Published email event to queue")
+		Str("priority", string(event.Priority)).
+		Str("entry_id", id).
+		Msg("Published email event to stream")
 
 	return nil
 }
 
+// GetQueueLength returns the events not yet acknowledged in all lanes,
+// including those being handled
 func (p *Publisher) GetQueueLength() (int64, error) {
-	return p.redis.LLen(EmailQueueKey)
+	var total int64
+	for _, stream := range laneStreams {
+		n, err := p.redis.XLen(stream)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
 }
@@ -0,0 +1,275 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// EmailRetryKey holds events waiting for another attempt, scored by
+	// when they are due in Unix milliseconds
+	EmailRetryKey = "mail-to-tg:queue:retry"
+
+	// DeadLetterKey holds events that ran out of attempts or failed
+	// permanently, by email ID
+	DeadLetterKey = "mail-to-tg:queue:dead"
+
+	// DroppedKeyPrefix marks emails whose dead letter an admin dropped,
+	// so they aren't queued again
+	DroppedKeyPrefix = "mail-to-tg:queue:dropped:"
+	droppedTTL       = 30 * 24 * time.Hour
+)
+
+// RetryError asks for an event to be retried no sooner than After, e.g.
+// when Telegram answered with a flood wait
+type RetryError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryError) Error() string { return e.Err.Error() }
+func (e *RetryError) Unwrap() error { return e.Err }
+
+// RetryAfter wraps err so the event is retried after at least d
+func RetryAfter(err error, d time.Duration) error {
+	return &RetryError{Err: err, After: d}
+}
+
+// PermanentError marks a failure retrying cannot fix; the event goes to
+// the dead-letter set straight away
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent wraps err so the event is not retried
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// DeadLetter is an event that could not be delivered
+type DeadLetter struct {
+	Event    EmailEvent `json:"event"`
+	Error    string     `json:"error"`
+	Attempts int        `json:"attempts"`
+	FailedAt time.Time  `json:"failed_at"`
+}
+
+// handleFailure schedules another attempt for a failed event, or moves it
+// to the dead-letter set once it failed permanently or too often
+func (c *Consumer) handleFailure(event *EmailEvent, err error) error {
+	event.Attempts++
+
+	var permanent *PermanentError
+	if errors.As(err, &permanent) || event.Attempts >= c.maxAttempts {
+		return c.deadLetter(event, err)
+	}
+
+	delay := c.backoff(event.Attempts)
+	var retry *RetryError
+	if errors.As(err, &retry) && retry.After > delay {
+		delay = retry.After
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal email event: %w", err)
+	}
+
+	due := time.Now().Add(delay)
+	if err := c.redis.ZAdd(EmailRetryKey, float64(due.UnixMilli()), string(data)); err != nil {
+		return fmt.Errorf("failed to schedule retry: %w", err)
+	}
+
+	log.Warn().
+		Str("email_id", event.EmailID).
+		Int("attempt", event.Attempts).
+		Dur("delay", delay).
+		Msg("Scheduled email event for retry")
+
+	return nil
+}
+
+// backoff doubles the delay with every attempt, up to retryMax
+func (c *Consumer) backoff(attempts int) time.Duration {
+	delay := c.retryBase
+	for i := 1; i < attempts && delay < c.retryMax; i++ {
+		delay *= 2
+	}
+	if delay > c.retryMax {
+		delay = c.retryMax
+	}
+	return delay
+}
+
+func (c *Consumer) deadLetter(event *EmailEvent, cause error) error {
+	letter := &DeadLetter{
+		Event:    *event,
+		Error:    cause.Error(),
+		Attempts: event.Attempts,
+		FailedAt: time.Now(),
+	}
+
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+
+	if err := c.redis.HSet(DeadLetterKey, event.EmailID, string(data)); err != nil {
+		return fmt.Errorf("failed to save dead letter: %w", err)
+	}
+
+	log.Error().
+		Err(cause).
+		Str("email_id", event.EmailID).
+		Str("user_id", event.UserID).
+		Int("attempts", event.Attempts).
+		Msg("Moved email event to the dead-letter set")
+
+	return nil
+}
+
+// promoteRetries moves events whose retry is due back onto the stream
+func (c *Consumer) promoteRetries() {
+	due, err := c.redis.ZRangeByScore(EmailRetryKey, float64(time.Now().UnixMilli()), 100)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read retry schedule")
+		return
+	}
+
+	for _, data := range due {
+		// Only the instance that removed it adds it back
+		removed, err := c.redis.ZRem(EmailRetryKey, data)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to take event from retry schedule")
+			return
+		}
+		if removed == 0 {
+			continue
+		}
+
+		var event EmailEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			log.Error().Err(err).Str("data", data).Msg("Failed to unmarshal retried email event")
+			continue
+		}
+
+		if _, err := c.redis.XAdd(StreamFor(event.Priority), map[string]interface{}{eventField: data}); err != nil {
+			log.Error().Err(err).Str("data", data).Msg("Failed to requeue email event")
+			c.redis.ZAdd(EmailRetryKey, float64(time.Now().UnixMilli()), data)
+			return
+		}
+	}
+}
+
+// DeadLetters returns the undeliverable events, most recent first
+func (p *Publisher) DeadLetters() ([]*DeadLetter, error) {
+	entries, err := p.redis.HGetAll(DeadLetterKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+
+	letters := make([]*DeadLetter, 0, len(entries))
+	for emailID, data := range entries {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(data), &letter); err != nil {
+			log.Warn().Err(err).Str("email_id", emailID).Msg("Failed to unmarshal dead letter")
+			continue
+		}
+		letters = append(letters, &letter)
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].FailedAt.After(letters[j].FailedAt)
+	})
+	return letters, nil
+}
+
+// ReplayDeadLetter publishes a dead-lettered event again with a fresh
+// set of attempts. It returns false if there was no such event.
+func (p *Publisher) ReplayDeadLetter(emailID string) (bool, error) {
+	data, err := p.redis.HGet(DeadLetterKey, emailID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+	if data == "" {
+		return false, nil
+	}
+
+	var letter DeadLetter
+	if err := json.Unmarshal([]byte(data), &letter); err != nil {
+		return false, fmt.Errorf("failed to unmarshal dead letter: %w", err)
+	}
+
+	event := letter.Event
+	event.Attempts = 0
+	if err := p.PublishEmailEvent(&event); err != nil {
+		return false, err
+	}
+
+	if err := p.redis.HDel(DeadLetterKey, emailID); err != nil {
+		return true, fmt.Errorf("failed to remove dead letter: %w", err)
+	}
+	return true, nil
+}
+
+// ReplayUserDeadLetters replays the dead-lettered events of a user, e.g.
+// those held while delivery to them was disabled. It returns how many were
+// replayed.
+func (p *Publisher) ReplayUserDeadLetters(userID string) (int, error) {
+	letters, err := p.DeadLetters()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, letter := range letters {
+		if letter.Event.UserID != userID {
+			continue
+		}
+		ok, err := p.ReplayDeadLetter(letter.Event.EmailID)
+		if err != nil {
+			return replayed, err
+		}
+		if ok {
+			replayed++
+		}
+	}
+	return replayed, nil
+}
+
+// IsDeadLettered reports whether an email's event is in the dead-letter set
+func (p *Publisher) IsDeadLettered(emailID string) (bool, error) {
+	data, err := p.redis.HGet(DeadLetterKey, emailID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+	return data != "", nil
+}
+
+// DropDeadLetter discards a dead-lettered event and remembers the drop
+func (p *Publisher) DropDeadLetter(emailID string) error {
+	if err := p.redis.Set(DroppedKeyPrefix+emailID, "1", droppedTTL); err != nil {
+		return fmt.Errorf("failed to record dropped dead letter: %w", err)
+	}
+	if err := p.redis.HDel(DeadLetterKey, emailID); err != nil {
+		return fmt.Errorf("failed to remove dead letter: %w", err)
+	}
+	return nil
+}
+
+// IsDropped reports whether an admin dropped an email's dead letter
+func (p *Publisher) IsDropped(emailID string) (bool, error) {
+	dropped, err := p.redis.Exists(DroppedKeyPrefix + emailID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check dropped dead letter: %w", err)
+	}
+	return dropped, nil
+}
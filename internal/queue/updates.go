@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kexi/mail-to-tg/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// EmailUpdateQueueKey is the list of emails whose state changed, so
+// telegram-service edits their notifications
+const EmailUpdateQueueKey = "mail-to-tg:queue:updates"
+
+type EmailUpdate struct {
+	EmailID string `json:"email_id"`
+}
+
+func (p *Publisher) PublishEmailUpdate(emailID string) error {
+	data, err := json.Marshal(&EmailUpdate{EmailID: emailID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal email update: %w", err)
+	}
+
+	if err := p.redis.LPush(EmailUpdateQueueKey, data); err != nil {
+		return fmt.Errorf("failed to push email update: %w", err)
+	}
+
+	log.Debug().Str("email_id", emailID).Msg("Published email update")
+	return nil
+}
+
+// UpdateConsumer hands email updates to the notification editor. Updates
+// are best effort: a failed one is logged and dropped, the next change
+// shows the whole state again.
+type UpdateConsumer struct {
+	redis   *storage.Redis
+	handler func(*EmailUpdate) error
+	stopped bool
+}
+
+func NewUpdateConsumer(redis *storage.Redis, handler func(*EmailUpdate) error) *UpdateConsumer {
+	return &UpdateConsumer{
+		redis:   redis,
+		handler: handler,
+	}
+}
+
+func (c *UpdateConsumer) Start() error {
+	log.Info().Msg("Starting email update consumer")
+
+	for !c.stopped {
+		result, err := c.redis.BRPop(5*time.Second, EmailUpdateQueueKey)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to pop from email update queue")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		// result is [queueKey, value], nil on timeout
+		if len(result) < 2 {
+			continue
+		}
+
+		var update EmailUpdate
+		if err := json.Unmarshal([]byte(result[1]), &update); err != nil {
+			log.Error().Err(err).Str("data", result[1]).Msg("Failed to unmarshal email update")
+			continue
+		}
+
+		if err := c.handler(&update); err != nil {
+			log.Error().
+				Err(err).
+				Str("email_id", update.EmailID).
+				Msg("Failed to handle email update")
+		}
+	}
+
+	log.Info().Msg("Email update consumer stopped")
+	return nil
+}
+
+func (c *UpdateConsumer) Stop() {
+	log.Info().Msg("Stopping email update consumer")
+	c.stopped = true
+}
@@ -0,0 +1,168 @@
+package rules
+
+import (
+	"strings"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Outcome is what the rules matching an email ask for. Flags add up over
+// the matching rules, tags are collected, and of the actions taking one
+// value the first rule to set it wins.
+type Outcome struct {
+	models.RuleActions
+	Matched []string // IDs of the matching rules, in order
+}
+
+// Evaluate runs a user's rules, in order, against an email. category is
+// the AI summary's category, empty when there is none yet; rules with a
+// category condition don't match then. Returns nil when no rule matched.
+func Evaluate(rules []*models.Rule, email *models.EmailMessage, category string) *Outcome {
+	var outcome *Outcome
+	for _, rule := range rules {
+		conditions, err := rule.ParseConditions()
+		if err != nil {
+			log.Error().Err(err).Str("rule_id", rule.ID).Msg("Failed to parse rule conditions")
+			continue
+		}
+		if !Matches(conditions, email, category) {
+			continue
+		}
+
+		actions, err := rule.ParseActions()
+		if err != nil {
+			log.Error().Err(err).Str("rule_id", rule.ID).Msg("Failed to parse rule actions")
+			continue
+		}
+
+		if outcome == nil {
+			outcome = &Outcome{}
+		}
+		outcome.merge(actions)
+		outcome.Matched = append(outcome.Matched, rule.ID)
+
+		if actions.Stop {
+			break
+		}
+	}
+	return outcome
+}
+
+func (o *Outcome) merge(a *models.RuleActions) {
+	o.Skip = o.Skip || a.Skip
+	o.Silent = o.Silent || a.Silent
+	o.Digest = o.Digest || a.Digest
+	o.MarkRead = o.MarkRead || a.MarkRead
+
+	if o.ChatID == 0 && a.ChatID != 0 {
+		o.ChatID = a.ChatID
+		o.TopicID = a.TopicID
+	}
+	if o.Priority == "" {
+		o.Priority = a.Priority
+	}
+	if o.ForwardTo == "" {
+		o.ForwardTo = a.ForwardTo
+	}
+
+	for _, tag := range a.Tags {
+		if !contains(o.Tags, tag) {
+			o.Tags = append(o.Tags, tag)
+		}
+	}
+}
+
+// Matches reports whether an email meets all of the conditions
+func Matches(c *models.RuleConditions, email *models.EmailMessage, category string) bool {
+	if c.AccountID != "" && c.AccountID != email.AccountID {
+		return false
+	}
+
+	if c.From != "" && !matchesSender(c.From, email.FromAddress) {
+		return false
+	}
+
+	if c.Subject != "" {
+		subject := ""
+		if email.Subject != nil {
+			subject = *email.Subject
+		}
+		if !matchesPattern(c.Subject, subject) {
+			return false
+		}
+	}
+
+	if c.Body != "" && !matchesPattern(c.Body, body(email)) {
+		return false
+	}
+
+	if c.HasAttachment != nil && *c.HasAttachment != email.HasAttachments {
+		return false
+	}
+
+	if c.ListID != "" {
+		if email.ListID == nil || !strings.Contains(strings.ToLower(*email.ListID), c.ListID) {
+			return false
+		}
+	}
+
+	if c.Category != "" && c.Category != category {
+		return false
+	}
+
+	return true
+}
+
+// UsesCategory reports whether any rule waits for the AI category
+func UsesCategory(rules []*models.Rule) bool {
+	for _, rule := range rules {
+		conditions, err := rule.ParseConditions()
+		if err == nil && conditions.Category != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSender takes an address, or @domain which includes subdomains
+func matchesSender(from, address string) bool {
+	address = strings.ToLower(address)
+	if !strings.HasPrefix(from, "@") {
+		return address == from
+	}
+
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return false
+	}
+	domain := address[at+1:]
+	return domain == from[1:] || strings.HasSuffix(domain, "."+from[1:])
+}
+
+func matchesPattern(pattern, s string) bool {
+	re, err := compile(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
+
+func body(email *models.EmailMessage) string {
+	switch {
+	case email.TextBody != nil && *email.TextBody != "":
+		return *email.TextBody
+	case email.HTMLText != nil:
+		return *email.HTMLText
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
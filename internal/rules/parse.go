@@ -0,0 +1,220 @@
+// Package rules parses and evaluates the rules users write to filter and
+// route their notifications.
+//
+// A rule reads as conditions, an arrow and actions:
+//
+//	from:@github.com subject:"review requested" => silent tag:review
+//	list:announce.example.com => skip markread
+//	category:finance attachment:yes => chat:-1001234567890 topic:12 priority:high
+//
+// Conditions are from (address, or @domain), subject and body (regular
+// expressions, case-insensitive), attachment (yes or no), list (part of the
+// List-Id), category (from the AI summary) and account (address of one of
+// the user's accounts). Actions are skip, silent, digest, chat, topic,
+// priority, markread, forward, tag and stop.
+package rules
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kexi/mail-to-tg/pkg/llm"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+// Arrow separates the conditions of a rule from its actions
+const Arrow = "=>"
+
+// Parse reads a rule definition. The account condition is returned as
+// written, the caller resolves it to an account ID.
+func Parse(definition string) (*models.RuleConditions, *models.RuleActions, error) {
+	left, right, ok := strings.Cut(definition, Arrow)
+	if !ok {
+		return nil, nil, fmt.Errorf("missing %q between conditions and actions", Arrow)
+	}
+
+	conditionTokens, err := tokenize(left)
+	if err != nil {
+		return nil, nil, err
+	}
+	actionTokens, err := tokenize(right)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(conditionTokens) == 0 {
+		return nil, nil, fmt.Errorf("a rule needs at least one condition")
+	}
+	if len(actionTokens) == 0 {
+		return nil, nil, fmt.Errorf("a rule needs at least one action")
+	}
+
+	conditions := &models.RuleConditions{}
+	for _, token := range conditionTokens {
+		if err := parseCondition(conditions, token); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	actions := &models.RuleActions{}
+	for _, token := range actionTokens {
+		if err := parseAction(actions, token); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if actions.TopicID != 0 && actions.ChatID == 0 {
+		return nil, nil, fmt.Errorf("topic needs a chat")
+	}
+
+	return conditions, actions, nil
+}
+
+type token struct {
+	key   string
+	value string
+}
+
+// tokenize splits on spaces into key:value pairs, values may be quoted
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	s = strings.TrimSpace(s)
+	for s != "" {
+		var word string
+		colon := strings.IndexByte(s, ':')
+		space := strings.IndexAny(s, " \t")
+
+		if colon >= 0 && (space < 0 || colon < space) && colon+1 < len(s) && s[colon+1] == '"' {
+			// key:"quoted value"
+			end := strings.IndexByte(s[colon+2:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote after %s:", s[:colon])
+			}
+			end += colon + 2
+			tokens = append(tokens, token{key: strings.ToLower(s[:colon]), value: s[colon+2 : end]})
+			s = strings.TrimSpace(s[end+1:])
+			continue
+		}
+
+		if space < 0 {
+			word, s = s, ""
+		} else {
+			word, s = s[:space], strings.TrimSpace(s[space:])
+		}
+
+		key, value, _ := strings.Cut(word, ":")
+		tokens = append(tokens, token{key: strings.ToLower(key), value: value})
+	}
+	return tokens, nil
+}
+
+func parseCondition(c *models.RuleConditions, t token) error {
+	if t.value == "" {
+		return fmt.Errorf("condition %s needs a value", t.key)
+	}
+
+	switch t.key {
+	case "account":
+		c.AccountID = t.value
+	case "from":
+		from := strings.ToLower(t.value)
+		if !strings.HasPrefix(from, "@") {
+			if _, err := mail.ParseAddress(from); err != nil {
+				return fmt.Errorf("from needs an address or @domain: %s", t.value)
+			}
+		}
+		c.From = from
+	case "subject", "body":
+		if _, err := compile(t.value); err != nil {
+			return fmt.Errorf("invalid %s pattern: %w", t.key, err)
+		}
+		if t.key == "subject" {
+			c.Subject = t.value
+		} else {
+			c.Body = t.value
+		}
+	case "attachment":
+		var has bool
+		switch strings.ToLower(t.value) {
+		case "yes", "true":
+			has = true
+		case "no", "false":
+		default:
+			return fmt.Errorf("attachment is yes or no")
+		}
+		c.HasAttachment = &has
+	case "list":
+		c.ListID = strings.ToLower(t.value)
+	case "category":
+		category := llm.GetCategory(map[string]interface{}{"category": t.value})
+		if category == "" {
+			return fmt.Errorf("unknown category %s, use one of: %s", t.value, strings.Join(llm.Categories, ", "))
+		}
+		c.Category = category
+	default:
+		return fmt.Errorf("unknown condition %s", t.key)
+	}
+	return nil
+}
+
+func parseAction(a *models.RuleActions, t token) error {
+	switch t.key {
+	case "skip":
+		a.Skip = true
+	case "silent":
+		a.Silent = true
+	case "digest":
+		a.Digest = true
+	case "markread":
+		a.MarkRead = true
+	case "stop":
+		a.Stop = true
+	case "chat":
+		id, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil || id == 0 {
+			return fmt.Errorf("chat needs a numeric chat ID")
+		}
+		a.ChatID = id
+	case "topic":
+		id, err := strconv.Atoi(t.value)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("topic needs a numeric topic ID")
+		}
+		a.TopicID = id
+	case "priority":
+		priority := models.Priority(strings.ToLower(t.value))
+		if !priority.Valid() {
+			return fmt.Errorf("priority is high, normal or low")
+		}
+		a.Priority = priority
+	case "forward":
+		address, err := mail.ParseAddress(t.value)
+		if err != nil {
+			return fmt.Errorf("forward needs an address: %s", t.value)
+		}
+		a.ForwardTo = address.Address
+	case "tag":
+		if t.value == "" {
+			return fmt.Errorf("tag needs a name")
+		}
+		a.Tags = append(a.Tags, t.value)
+	default:
+		return fmt.Errorf("unknown action %s", t.key)
+	}
+
+	switch t.key {
+	case "skip", "silent", "digest", "markread", "stop":
+		if t.value != "" {
+			return fmt.Errorf("action %s takes no value", t.key)
+		}
+	}
+	return nil
+}
+
+// compile makes patterns case-insensitive
+func compile(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pattern)
+}
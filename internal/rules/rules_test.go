@@ -0,0 +1,111 @@
+package rules
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+func TestParse(t *testing.T) {
+	conditions, actions, err := Parse(`from:@GitHub.com subject:"review requested" attachment:no => silent chat:-100123 topic:7 tag:review tag:work priority:low`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if conditions.From != "@github.com" || conditions.Subject != "review requested" {
+		t.Errorf("conditions = %+v", conditions)
+	}
+	if conditions.HasAttachment == nil || *conditions.HasAttachment {
+		t.Errorf("HasAttachment = %v, want false", conditions.HasAttachment)
+	}
+	if !actions.Silent || actions.ChatID != -100123 || actions.TopicID != 7 || actions.Priority != models.PriorityLow {
+		t.Errorf("actions = %+v", actions)
+	}
+	if !reflect.DeepEqual(actions.Tags, []string{"review", "work"}) {
+		t.Errorf("Tags = %v", actions.Tags)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, definition := range []string{
+		"from:a@example.com",                 // No arrow
+		"=> skip",                            // No condition
+		"from:a@example.com =>",              // No action
+		"from:not-an-address => skip",        // Bad sender
+		`subject:"(unclosed" => skip`,        // Bad pattern
+		`subject:"unterminated => skip`,      // Bad quote
+		"category:gossip => skip",            // Unknown category
+		"from:a@example.com => topic:3",      // Topic without chat
+		"from:a@example.com => priority:max", // Unknown priority
+		"from:a@example.com => forward:nope", // Bad forward address
+		"from:a@example.com => explode",      // Unknown action
+		"colour:red => skip",                 // Unknown condition
+		"from:a@example.com => skip:please",  // Flag with a value
+	} {
+		if _, _, err := Parse(definition); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", definition)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	rule := func(id, definition string) *models.Rule {
+		conditions, actions, err := Parse(definition)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", definition, err)
+		}
+		c, _ := json.Marshal(conditions)
+		a, _ := json.Marshal(actions)
+		return &models.Rule{ID: id, Definition: definition, Conditions: string(c), Actions: string(a), Enabled: true}
+	}
+
+	str := func(s string) *string { return &s }
+
+	rules := []*models.Rule{
+		rule("news", "list:announce.example.com => skip markread stop"),
+		rule("github", `from:@github.com subject:"^\[.*\] review" => silent tag:review`),
+		rule("bank", "category:finance => chat:-100999 priority:high tag:money"),
+		rule("all", "body:. => tag:mail chat:-100111"),
+	}
+
+	tests := []struct {
+		name     string
+		email    *models.EmailMessage
+		category string
+		want     []string
+	}{
+		{"list stops", &models.EmailMessage{FromAddress: "a@example.com", ListID: str("<Announce.Example.com>"), TextBody: str("hi")}, "", []string{"news"}},
+		{"subdomain sender", &models.EmailMessage{FromAddress: "noreply@mail.github.com", Subject: str("[repo] Review requested"), TextBody: str("hi")}, "", []string{"github", "all"}},
+		{"category needs summary", &models.EmailMessage{FromAddress: "a@bank.example", TextBody: str("statement")}, "", []string{"all"}},
+		{"category", &models.EmailMessage{FromAddress: "a@bank.example", TextBody: str("statement")}, "finance", []string{"bank", "all"}},
+		{"nothing", &models.EmailMessage{FromAddress: "a@example.com"}, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome := Evaluate(rules, tt.email, tt.category)
+			var got []string
+			if outcome != nil {
+				got = outcome.Matched
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Matched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The first rule to route wins, tags add up
+	outcome := Evaluate(rules, &models.EmailMessage{FromAddress: "a@bank.example", TextBody: str("x")}, "finance")
+	if outcome.ChatID != -100999 || outcome.Priority != models.PriorityHigh {
+		t.Errorf("outcome = %+v", outcome.RuleActions)
+	}
+	if !reflect.DeepEqual(outcome.Tags, []string{"money", "mail"}) {
+		t.Errorf("Tags = %v", outcome.Tags)
+	}
+
+	if !UsesCategory(rules) || UsesCategory(rules[:2]) {
+		t.Error("UsesCategory is wrong")
+	}
+}
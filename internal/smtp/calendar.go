@@ -0,0 +1,169 @@
+package smtp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kexi/mail-to-tg/pkg/crypto"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/wneessen/go-mail"
+)
+
+// iCalendar participation statuses for RSVP replies
+const (
+	PartStatAccepted  = "ACCEPTED"
+	PartStatTentative = "TENTATIVE"
+	PartStatDeclined  = "DECLINED"
+)
+
+var replySubjectPrefix = map[string]string{
+	PartStatAccepted:  "Accepted",
+	PartStatTentative: "Tentative",
+	PartStatDeclined:  "Declined",
+}
+
+// SendCalendarReply answers an invitation with an iMIP REPLY (RFC 6047) to
+// the organizer, on behalf of the account's address
+func (c *Client) SendCalendarReply(account *models.EmailAccount, originalEmail *models.EmailMessage, event *models.CalendarEvent, partstat string) error {
+	if account.SMTPServer == nil || account.SMTPPort == nil ||
+		account.SMTPUsername == nil || account.SMTPPasswordEncrypted == nil {
+		return fmt.Errorf("SMTP credentials not configured")
+	}
+
+	prefix, ok := replySubjectPrefix[partstat]
+	if !ok {
+		return fmt.Errorf("invalid participation status: %s", partstat)
+	}
+
+	organizer := event.Organizer
+	if organizer == "" {
+		organizer = originalEmail.FromAddress
+	}
+
+	// Decrypt password
+	encKey, err := base64.StdEncoding.DecodeString(c.cfg.Security.EncryptionKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode encryption key: %w", err)
+	}
+
+	password, err := crypto.Decrypt(*account.SMTPPasswordEncrypted, encKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt SMTP password: %w", err)
+	}
+
+	// Create message
+	m := mail.NewMsg()
+
+	if err := m.From(account.EmailAddress); err != nil {
+		return fmt.Errorf("failed to set from: %w", err)
+	}
+
+	if err := m.To(organizer); err != nil {
+		return fmt.Errorf("failed to set to: %w", err)
+	}
+
+	m.Subject(fmt.Sprintf("%s: %s", prefix, event.Summary))
+	m.SetBodyString(mail.TypeTextPlain, fmt.Sprintf("%s has %s the invitation: %s",
+		account.EmailAddress, strings.ToLower(prefix), event.Summary))
+	m.AddAlternativeString(mail.ContentType("text/calendar; method=REPLY"),
+		buildCalendarReply(event, organizer, account.EmailAddress, partstat))
+	m.SetMessageID()
+
+	if originalEmail.MessageID != "" {
+		m.SetHeader("In-Reply-To", originalEmail.MessageID)
+		m.SetHeader("References", originalEmail.MessageID)
+	}
+
+	// Create SMTP client
+	smtpClient, err := mail.NewClient(*account.SMTPServer,
+		mail.WithPort(*account.SMTPPort),
+		mail.WithSMTPAuth(mail.SMTPAuthPlain),
+		mail.WithUsername(*account.SMTPUsername),
+		mail.WithPassword(password),
+		mail.WithTLSPolicy(mail.TLSMandatory),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create SMTP client: %w", err)
+	}
+
+	// Send message
+	if err := smtpClient.DialAndSend(m); err != nil {
+		return fmt.Errorf("failed to send calendar reply: %w", err)
+	}
+
+	return nil
+}
+
+// buildCalendarReply renders the VCALENDAR of a REPLY for one attendee
+func buildCalendarReply(event *models.CalendarEvent, organizer, attendee, partstat string) string {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"PRODID:-//mail-to-tg//EN",
+		"VERSION:2.0",
+		"METHOD:REPLY",
+		"BEGIN:VEVENT",
+		"UID:" + event.UID,
+		fmt.Sprintf("SEQUENCE:%d", event.Sequence),
+		"DTSTAMP:" + time.Now().UTC().Format("20060102T150405Z"),
+	}
+
+	if event.RecurrenceID != "" {
+		if event.RecurrenceTZ != "" {
+			lines = append(lines, fmt.Sprintf("RECURRENCE-ID;TZID=%s:%s", event.RecurrenceTZ, event.RecurrenceID))
+		} else {
+			lines = append(lines, "RECURRENCE-ID:"+event.RecurrenceID)
+		}
+	}
+
+	if event.AllDay {
+		lines = append(lines, "DTSTART;VALUE=DATE:"+event.Start.Format("20060102"))
+	} else {
+		lines = append(lines, "DTSTART:"+event.Start.UTC().Format("20060102T150405Z"))
+		if !event.End.IsZero() {
+			lines = append(lines, "DTEND:"+event.End.UTC().Format("20060102T150405Z"))
+		}
+	}
+
+	lines = append(lines,
+		"ORGANIZER:mailto:"+organizer,
+		fmt.Sprintf("ATTENDEE;PARTSTAT=%s:mailto:%s", partstat, attendee),
+		"SUMMARY:"+escapeICalText(event.Summary),
+		"END:VEVENT",
+		"END:VCALENDAR",
+	)
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(foldICalLine(line))
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+func escapeICalText(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	return replacer.Replace(value)
+}
+
+// foldICalLine splits lines longer than 75 octets without breaking UTF-8
+// sequences, as RFC 5545 requires
+func foldICalLine(line string) string {
+	if len(line) <= 75 {
+		return line
+	}
+
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	return b.String()
+}
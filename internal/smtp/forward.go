@@ -0,0 +1,108 @@
+package smtp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kexi/mail-to-tg/pkg/crypto"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/wneessen/go-mail"
+)
+
+// ForwardEmail forwards an email from the account it arrived on. The
+// original message is attached as message/rfc822 when raw is not nil,
+// otherwise only its text is quoted.
+func (c *Client) ForwardEmail(account *models.EmailAccount, originalEmail *models.EmailMessage, to string, raw io.Reader) error {
+	if account.SMTPServer == nil || account.SMTPPort == nil ||
+		account.SMTPUsername == nil || account.SMTPPasswordEncrypted == nil {
+		return fmt.Errorf("SMTP credentials not configured")
+	}
+
+	// Decrypt password
+	encKey, err := base64.StdEncoding.DecodeString(c.cfg.Security.EncryptionKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode encryption key: %w", err)
+	}
+
+	password, err := crypto.Decrypt(*account.SMTPPasswordEncrypted, encKey)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt SMTP password: %w", err)
+	}
+
+	// Create message
+	m := mail.NewMsg()
+
+	if err := m.From(account.EmailAddress); err != nil {
+		return fmt.Errorf("failed to set from: %w", err)
+	}
+
+	if err := m.To(to); err != nil {
+		return fmt.Errorf("failed to set to: %w", err)
+	}
+
+	subject := ""
+	if originalEmail.Subject != nil {
+		subject = *originalEmail.Subject
+	}
+	m.Subject("Fwd: " + subject)
+	m.SetBodyString(mail.TypeTextPlain, buildForwardBody(originalEmail, raw == nil))
+	m.SetMessageID()
+
+	// RFC 3834, and our own marker, so the copy isn't forwarded again
+	m.SetGenHeader("Auto-Submitted", "auto-forwarded")
+	m.SetGenHeader(models.ForwardedByHeader, models.ForwardedByValue)
+
+	if raw != nil {
+		m.AttachReader("original.eml", raw, mail.WithFileContentType("message/rfc822"))
+	}
+
+	// Create SMTP client
+	smtpClient, err := mail.NewClient(*account.SMTPServer,
+		mail.WithPort(*account.SMTPPort),
+		mail.WithSMTPAuth(mail.SMTPAuthPlain),
+		mail.WithUsername(*account.SMTPUsername),
+		mail.WithPassword(password),
+		mail.WithTLSPolicy(mail.TLSMandatory),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create SMTP client: %w", err)
+	}
+
+	// Send message
+	if err := smtpClient.DialAndSend(m); err != nil {
+		return fmt.Errorf("failed to forward email: %w", err)
+	}
+
+	return nil
+}
+
+// buildForwardBody renders the usual forwarded message header block,
+// followed by the text when the original isn't attached
+func buildForwardBody(email *models.EmailMessage, withText bool) string {
+	var b strings.Builder
+	b.WriteString("---------- Forwarded message ---------\n")
+
+	from := email.FromAddress
+	if email.FromName != nil && *email.FromName != "" {
+		from = fmt.Sprintf("%s <%s>", *email.FromName, email.FromAddress)
+	}
+	b.WriteString("From: " + from + "\n")
+	if !email.Date.IsZero() {
+		b.WriteString("Date: " + email.Date.Format("Mon, 2 Jan 2006 15:04 -0700") + "\n")
+	}
+	if email.Subject != nil {
+		b.WriteString("Subject: " + *email.Subject + "\n")
+	}
+	if email.ToAddresses != nil && *email.ToAddresses != "" {
+		b.WriteString("To: " + *email.ToAddresses + "\n")
+	}
+
+	if withText && email.TextBody != nil {
+		b.WriteString("\n")
+		b.WriteString(*email.TextBody)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+// Attachment blob operations
+func (m *MariaDB) UpsertAttachmentBlob(blob *models.AttachmentBlob) error {
+	query := `INSERT INTO attachment_blobs (sha256, size, content_type)
+		VALUES (:sha256, :size, :content_type)
+		ON DUPLICATE KEY UPDATE last_referenced_at = NOW()`
+	_, err := m.db.NamedExec(query, blob)
+	return err
+}
+
+func (m *MariaDB) CreateEmailAttachment(attachment *models.EmailAttachment) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO email_attachments (
+		id, email_id, blob_sha256, filename, content_type, size, position
+	) VALUES (
+		:id, :email_id, :blob_sha256, :filename, :content_type, :size, :position
+	)`
+	if _, err := tx.NamedExec(query, attachment); err != nil {
+		return err
+	}
+
+	query = `UPDATE attachment_blobs SET ref_count = ref_count + 1, last_referenced_at = NOW()
+		WHERE sha256 = ?`
+	if _, err := tx.Exec(query, attachment.BlobSHA256); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetUserAttachmentUsage returns the bytes of distinct blobs referenced by a user's emails
+func (m *MariaDB) GetUserAttachmentUsage(userID string) (int64, error) {
+	var usage int64
+	query := `SELECT COALESCE(SUM(b.size), 0) FROM attachment_blobs b
+		WHERE b.sha256 IN (
+			SELECT ea.blob_sha256 FROM email_attachments ea
+			JOIN email_messages em ON em.id = ea.email_id
+			JOIN email_accounts acc ON acc.id = em.account_id
+			WHERE acc.user_id = ?
+			UNION
+			SELECT em.raw_sha256 FROM email_messages em
+			JOIN email_accounts acc ON acc.id = em.account_id
+			WHERE acc.user_id = ? AND em.raw_sha256 IS NOT NULL
+		)`
+	err := m.db.Get(&usage, query, userID, userID)
+	return usage, err
+}
+
+func (m *MariaDB) UserReferencesAttachmentBlob(userID, sha256 string) (bool, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM email_messages em
+		JOIN email_accounts acc ON acc.id = em.account_id
+		LEFT JOIN email_attachments ea ON ea.email_id = em.id AND ea.blob_sha256 = ?
+		WHERE acc.user_id = ? AND (ea.id IS NOT NULL OR em.raw_sha256 = ?)`
+	err := m.db.Get(&count, query, sha256, userID, sha256)
+	return count > 0, err
+}
+
+// DeleteEmailAttachmentsBefore drops attachment references of emails
+// received before the cutoff, and marks them expired in the emails'
+// attachment lists so their download links stop being offered
+func (m *MariaDB) DeleteEmailAttachmentsBefore(cutoff time.Time) (int64, error) {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var emails []*models.EmailMessage
+	query := `SELECT id, attachments FROM email_messages em
+		WHERE em.created_at < ? AND EXISTS (
+			SELECT 1 FROM email_attachments ea WHERE ea.email_id = em.id
+		)`
+	if err := tx.Select(&emails, query, cutoff); err != nil {
+		return 0, err
+	}
+
+	for _, email := range emails {
+		if err := email.ExpireAttachments(); err != nil {
+			return 0, err
+		}
+		query = `UPDATE email_messages SET attachments = ? WHERE id = ?`
+		if _, err := tx.Exec(query, email.Attachments, email.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	query = `DELETE ea FROM email_attachments ea
+		JOIN email_messages em ON em.id = ea.email_id
+		WHERE em.created_at < ?`
+	result, err := tx.Exec(query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, tx.Commit()
+}
+
+// ClearRawMessagesBefore drops the raw message reference of emails received before the cutoff
+func (m *MariaDB) ClearRawMessagesBefore(cutoff time.Time) (int64, error) {
+	query := `UPDATE email_messages SET raw_sha256 = NULL
+		WHERE raw_sha256 IS NOT NULL AND created_at < ?`
+	result, err := m.db.Exec(query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RecountAttachmentBlobRefs resyncs ref_count with the references that still
+// exist, e.g. after emails were removed by a cascading delete
+func (m *MariaDB) RecountAttachmentBlobRefs() error {
+	query := `UPDATE attachment_blobs b SET ref_count = (
+		SELECT COUNT(*) FROM email_attachments ea WHERE ea.blob_sha256 = b.sha256
+	) + (
+		SELECT COUNT(*) FROM email_messages em WHERE em.raw_sha256 = b.sha256
+	)`
+	_, err := m.db.Exec(query)
+	return err
+}
+
+func (m *MariaDB) GetUnreferencedAttachmentBlobs(olderThan time.Time, limit int) ([]*models.AttachmentBlob, error) {
+	var blobs []*models.AttachmentBlob
+	query := `SELECT * FROM attachment_blobs
+		WHERE ref_count = 0 AND last_referenced_at < ?
+		ORDER BY last_referenced_at ASC
+		LIMIT ?`
+	err := m.db.Select(&blobs, query, olderThan, limit)
+	return blobs, err
+}
+
+// DeleteAttachmentBlob removes a blob row if it is still unreferenced and
+// reports whether it was deleted
+func (m *MariaDB) DeleteAttachmentBlob(sha256 string, olderThan time.Time) (bool, error) {
+	query := `DELETE FROM attachment_blobs
+		WHERE sha256 = ? AND ref_count = 0 AND last_referenced_at < ?
+		AND NOT EXISTS (SELECT 1 FROM email_attachments ea WHERE ea.blob_sha256 = ?)
+		AND NOT EXISTS (SELECT 1 FROM email_messages em WHERE em.raw_sha256 = ?)`
+	result, err := m.db.Exec(query, sha256, olderThan, sha256, sha256)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+// User crypto key operations
+func (m *MariaDB) CreateUserCryptoKey(key *models.UserCryptoKey) error {
+	query := `INSERT INTO user_crypto_keys (
+		id, user_id, kind, fingerprint, identity, has_private,
+		key_data_encrypted, passphrase_encrypted
+	) VALUES (
+		:id, :user_id, :kind, :fingerprint, :identity, :has_private,
+		:key_data_encrypted, :passphrase_encrypted
+	) ON DUPLICATE KEY UPDATE
+		identity = VALUES(identity), has_private = VALUES(has_private),
+		key_data_encrypted = VALUES(key_data_encrypted),
+		passphrase_encrypted = VALUES(passphrase_encrypted)`
+	_, err := m.db.NamedExec(query, key)
+	return err
+}
+
+func (m *MariaDB) GetUserCryptoKeys(userID string) ([]*models.UserCryptoKey, error) {
+	var keys []*models.UserCryptoKey
+	query := `SELECT * FROM user_crypto_keys WHERE user_id = ? ORDER BY created_at`
+	err := m.db.Select(&keys, query, userID)
+	return keys, err
+}
+
+func (m *MariaDB) GetUserCryptoKeyByID(id string) (*models.UserCryptoKey, error) {
+	var key models.UserCryptoKey
+	query := `SELECT * FROM user_crypto_keys WHERE id = ?`
+	err := m.db.Get(&key, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &key, err
+}
+
+func (m *MariaDB) DeleteUserCryptoKey(id string) error {
+	query := `DELETE FROM user_crypto_keys WHERE id = ?`
+	_, err := m.db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+// Digest operations
+func (m *MariaDB) AddDigestItem(item *models.DigestItem) error {
+	query := `INSERT IGNORE INTO digest_items (id, user_id, email_id)
+		VALUES (:id, :user_id, :email_id)`
+	_, err := m.db.NamedExec(query, item)
+	return err
+}
+
+// GetPendingDigests returns the users with mail waiting for a digest
+func (m *MariaDB) GetPendingDigests() ([]*models.PendingDigest, error) {
+	var pending []*models.PendingDigest
+	query := `SELECT user_id, MIN(created_at) AS since FROM digest_items GROUP BY user_id`
+	err := m.db.Select(&pending, query)
+	return pending, err
+}
+
+// GetDigestEmails returns the emails waiting for a user's digest, in the
+// order they were collected
+func (m *MariaDB) GetDigestEmails(userID string) ([]*models.EmailMessage, error) {
+	var emails []*models.EmailMessage
+	query := `SELECT e.* FROM digest_items d
+		JOIN email_messages e ON e.id = d.email_id
+		WHERE d.user_id = ?
+		ORDER BY d.created_at, e.date`
+	err := m.db.Select(&emails, query, userID)
+	return emails, err
+}
+
+// DeleteDigestItems removes emails from a user's digest once it was sent
+func (m *MariaDB) DeleteDigestItems(userID string, emailIDs []string) error {
+	if len(emailIDs) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In(`DELETE FROM digest_items WHERE user_id = ? AND email_id IN (?)`, userID, emailIDs)
+	if err != nil {
+		return err
+	}
+	_, err = m.db.Exec(m.db.Rebind(query), args...)
+	return err
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+// List unsubscribe operations
+func (m *MariaDB) SaveListUnsubscribe(record *models.ListUnsubscribe) error {
+	query := `INSERT INTO list_unsubscribes (
+		id, user_id, list_key, name, method, target, success, error, muted
+	) VALUES (
+		:id, :user_id, :list_key, :name, :method, :target, :success, :error, :muted
+	) ON DUPLICATE KEY UPDATE
+		name = VALUES(name), method = VALUES(method), target = VALUES(target),
+		success = VALUES(success), error = VALUES(error), muted = VALUES(muted)`
+	_, err := m.db.NamedExec(query, record)
+	return err
+}
+
+func (m *MariaDB) GetListUnsubscribes(userID string) ([]*models.ListUnsubscribe, error) {
+	var records []*models.ListUnsubscribe
+	query := `SELECT * FROM list_unsubscribes WHERE user_id = ? ORDER BY updated_at DESC`
+	err := m.db.Select(&records, query, userID)
+	return records, err
+}
+
+func (m *MariaDB) GetListUnsubscribeByID(id string) (*models.ListUnsubscribe, error) {
+	var record models.ListUnsubscribe
+	query := `SELECT * FROM list_unsubscribes WHERE id = ?`
+	err := m.db.Get(&record, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &record, err
+}
+
+// IsListMuted reports whether a user muted mail from a list
+func (m *MariaDB) IsListMuted(userID, listKey string) (bool, error) {
+	var muted bool
+	query := `SELECT muted FROM list_unsubscribes WHERE user_id = ? AND list_key = ?`
+	err := m.db.Get(&muted, query, userID, listKey)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return muted, err
+}
+
+func (m *MariaDB) SetListMuted(id string, muted bool) error {
+	query := `UPDATE list_unsubscribes SET muted = ? WHERE id = ?`
+	_, err := m.db.Exec(query, muted, id)
+	return err
+}
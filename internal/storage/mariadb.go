@@ -3,6 +3,7 @@ package storage
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -41,8 +42,17 @@ func (m *MariaDB) Ping() error {
 
 // User operations
 func (m *MariaDB) CreateUser(user *models.User) error {
-	query := `INSERT INTO users (id, telegram_id, username, first_name, last_name, is_active)
-		VALUES (:id, :telegram_id, :username, :first_name, :last_name, :is_active)`
+	if user.DigestSchedule == "" {
+		user.DigestSchedule = models.DigestOff
+	}
+	if user.DigestTime == "" {
+		user.DigestTime = models.DefaultDigestTime
+	}
+
+	query := `INSERT INTO users (id, telegram_id, username, first_name, last_name, is_active,
+		digest_schedule, digest_time)
+		VALUES (:id, :telegram_id, :username, :first_name, :last_name, :is_active,
+		:digest_schedule, :digest_time)`
 	_, err := m.db.NamedExec(query, user)
 	return err
 }
@@ -69,7 +79,8 @@ func (m *MariaDB) GetUserByID(id string) (*models.User, error) {
 
 func (m *MariaDB) UpdateUser(user *models.User) error {
 	query := `UPDATE users SET username = :username, first_name = :first_name,
-		last_name = :last_name, is_active = :is_active, updated_at = NOW()
+		last_name = :last_name, is_active = :is_active, timezone = :timezone,
+		digest_schedule = :digest_schedule, digest_time = :digest_time, updated_at = NOW()
 		WHERE id = :id`
 	_, err := m.db.NamedExec(query, user)
 	return err
@@ -136,6 +147,35 @@ func (m *MariaDB) UpdateEmailAccount(account *models.EmailAccount) error {
 	return err
 }
 
+// SetAccountDeliveryTarget sends an account's notifications to a chat and
+// optional forum topic, or back to the owner's private chat when chatID is
+// nil. The caller has verified the owner administers the chat, which the
+// notifier checks again from time to time.
+func (m *MariaDB) SetAccountDeliveryTarget(id string, chatID *int64, threadID *int) error {
+	query := `UPDATE email_accounts SET
+		delivery_chat_id = ?, delivery_thread_id = ?,
+		delivery_verified_at = IF(? IS NULL, NULL, NOW()), updated_at = NOW()
+		WHERE id = ?`
+	_, err := m.db.Exec(query, chatID, threadID, chatID, id)
+	return err
+}
+
+// SetAccountDeliveryTopic moves an account's notifications to another forum
+// topic of the same chat, keeping when the owner was last verified
+func (m *MariaDB) SetAccountDeliveryTopic(id string, threadID int) error {
+	query := `UPDATE email_accounts SET delivery_thread_id = ?, updated_at = NOW() WHERE id = ?`
+	_, err := m.db.Exec(query, threadID, id)
+	return err
+}
+
+// MarkAccountDeliveryVerified records that the owner still administers the
+// account's delivery chat
+func (m *MariaDB) MarkAccountDeliveryVerified(id string) error {
+	query := `UPDATE email_accounts SET delivery_verified_at = NOW() WHERE id = ? AND delivery_chat_id IS NOT NULL`
+	_, err := m.db.Exec(query, id)
+	return err
+}
+
 func (m *MariaDB) DeleteEmailAccount(id string) error {
 	query := `DELETE FROM email_accounts WHERE id = ?`
 	_, err := m.db.Exec(query, id)
@@ -148,12 +188,18 @@ func (m *MariaDB) CreateEmailMessage(email *models.EmailMessage) error {
 		id, account_id, message_id, thread_id, gmail_id, imap_uid,
 		from_address, from_name, to_addresses, subject, date,
 		text_body, html_body, sanitized_html, has_attachments, attachments,
-		in_reply_to, ` + "`references`" + `, is_read, is_notified
+		in_reply_to, ` + "`references`" + `, is_read, is_notified, calendar_event,
+		crypto_status, auth_verdict, new_content, html_text,
+		list_id, list_unsubscribe, list_unsubscribe_post, embedded_messages,
+		raw_sha256, priority, auto_forwarded
 	) VALUES (
 		:id, :account_id, :message_id, :thread_id, :gmail_id, :imap_uid,
 		:from_address, :from_name, :to_addresses, :subject, :date,
 		:text_body, :html_body, :sanitized_html, :has_attachments, :attachments,
-		:in_reply_to, :references, :is_read, :is_notified
+		:in_reply_to, :references, :is_read, :is_notified, :calendar_event,
+		:crypto_status, :auth_verdict, :new_content, :html_text,
+		:list_id, :list_unsubscribe, :list_unsubscribe_post, :embedded_messages,
+		:raw_sha256, :priority, :auto_forwarded
 	)`
 	_, err := m.db.NamedExec(query, email)
 	return err
@@ -179,13 +225,18 @@ func (m *MariaDB) GetEmailMessageByAccountAndMessageID(accountID, messageID stri
 	return &email, err
 }
 
-func (m *MariaDB) GetUnnotifiedEmails(limit int) ([]*models.EmailMessage, error) {
+// GetUnnotifiedEmails returns emails of active users saved between after
+// and before that haven't been notified, oldest first
+func (m *MariaDB) GetUnnotifiedEmails(after, before time.Time, limit int) ([]*models.EmailMessage, error) {
 	var emails []*models.EmailMessage
-	query := `SELECT * FROM email_messages
-		WHERE is_notified = FALSE
-		ORDER BY date ASC
+	query := `SELECT m.* FROM email_messages m
+		JOIN email_accounts a ON a.id = m.account_id
+		JOIN users u ON u.id = a.user_id
+		WHERE m.is_notified = FALSE AND m.created_at > ? AND m.created_at < ?
+			AND a.is_active = TRUE AND u.is_active = TRUE
+		ORDER BY m.created_at ASC
 		LIMIT ?`
-	err := m.db.Select(&emails, query, limit)
+	err := m.db.Select(&emails, query, after, before, limit)
 	return emails, err
 }
 
@@ -204,6 +255,19 @@ func (m *MariaDB) MarkEmailAsNotified(id string) error {
 	return err
 }
 
+// MarkEmailAsRead records that an email was marked read on the server
+func (m *MariaDB) MarkEmailAsRead(id string) error {
+	query := `UPDATE email_messages SET is_read = TRUE WHERE id = ?`
+	_, err := m.db.Exec(query, id)
+	return err
+}
+
+func (m *MariaDB) SetEmailCalendarRSVP(id, partstat string) error {
+	query := `UPDATE email_messages SET calendar_rsvp = ?, updated_at = NOW() WHERE id = ?`
+	_, err := m.db.Exec(query, partstat, id)
+	return err
+}
+
 func (m *MariaDB) UpdateEmailSummary(emailID string, summary *string, extractedData *string, model *string, summaryError *string) error {
 	query := `UPDATE email_messages SET
 		ai_summary = ?,
@@ -217,6 +281,24 @@ func (m *MariaDB) UpdateEmailSummary(emailID string, summary *string, extractedD
 	return err
 }
 
+// SearchEmails finds a user's emails by sender, subject or body text
+func (m *MariaDB) SearchEmails(userID, query string, limit int) ([]*models.EmailMessage, error) {
+	var emails []*models.EmailMessage
+	pattern := "%" + escapeLike(query) + "%"
+	sqlQuery := `SELECT em.* FROM email_messages em
+		JOIN email_accounts acc ON acc.id = em.account_id
+		WHERE acc.user_id = ?
+		AND (em.subject LIKE ? OR em.from_address LIKE ? OR em.from_name LIKE ? OR em.new_content LIKE ? OR em.html_text LIKE ?)
+		ORDER BY em.date DESC
+		LIMIT ?`
+	err := m.db.Select(&emails, sqlQuery, userID, pattern, pattern, pattern, pattern, pattern, limit)
+	return emails, err
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 // Email view token operations
 func (m *MariaDB) CreateEmailViewToken(token *models.EmailViewToken) error {
 	query := `INSERT INTO email_view_tokens (id, email_id, token, expires_at)
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -45,6 +46,24 @@ func (r *Redis) Set(key string, value interface{}, expiration time.Duration) err
 	return r.client.Set(r.ctx, key, value, expiration).Err()
 }
 
+// SetNX sets key only if it doesn't exist, reporting whether it did
+func (r *Redis) SetNX(key string, value interface{}, expiration time.Duration) (bool, error) {
+	return r.client.SetNX(r.ctx, key, value, expiration).Result()
+}
+
+// Deletes key only while it still holds the given value
+var delIfEqualScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// DelIfEqual deletes key if it holds value, so a lock is only released by
+// its owner
+func (r *Redis) DelIfEqual(key, value string) error {
+	return delIfEqualScript.Run(r.ctx, r.client, []string{key}, value).Err()
+}
+
 func (r *Redis) Get(key string) (string, error) {
 	val, err := r.client.Get(r.ctx, key).Result()
 	if err == redis.Nil {
@@ -104,6 +123,140 @@ func (r *Redis) LLen(key string) (int64, error) {
 	return r.client.LLen(r.ctx, key).Result()
 }
 
+func (r *Redis) LPop(key string) (string, error) {
+	val, err := r.client.LPop(r.ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return val, err
+}
+
+// Sorted set operations
+func (r *Redis) ZAdd(key string, score float64, member string) error {
+	return r.client.ZAdd(r.ctx, key, &redis.Z{Score: score, Member: member}).Err()
+}
+
+// ZRangeByScore returns up to count members scored at most max, lowest first
+func (r *Redis) ZRangeByScore(key string, max float64, count int64) ([]string, error) {
+	return r.client.ZRangeByScore(r.ctx, key, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   fmt.Sprintf("%f", max),
+		Count: count,
+	}).Result()
+}
+
+// ZRem returns how many members were removed, letting concurrent callers
+// find out which of them took a member
+func (r *Redis) ZRem(key string, members ...interface{}) (int64, error) {
+	return r.client.ZRem(r.ctx, key, members...).Result()
+}
+
+func (r *Redis) ZCard(key string) (int64, error) {
+	return r.client.ZCard(r.ctx, key).Result()
+}
+
+// Stream operations for the notification queue
+func (r *Redis) XAdd(stream string, values map[string]interface{}) (string, error) {
+	return r.client.XAdd(r.ctx, &redis.XAddArgs{
+		Stream: stream,
+		Values: values,
+	}).Result()
+}
+
+// XGroupCreate creates a consumer group reading the stream from the start,
+// creating the stream if needed. An existing group is left as it is.
+func (r *Redis) XGroupCreate(stream, group string) error {
+	err := r.client.XGroupCreateMkStream(r.ctx, stream, group, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// XReadGroup reads new entries from streams for a consumer, returning nil
+// on timeout. A negative block returns right away.
+func (r *Redis) XReadGroup(group, consumer string, streams []string, count int64, block time.Duration) ([]redis.XStream, error) {
+	args := make([]string, 0, 2*len(streams))
+	args = append(args, streams...)
+	for range streams {
+		args = append(args, ">")
+	}
+
+	result, err := r.client.XReadGroup(r.ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  args,
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (r *Redis) XAck(stream, group string, ids ...string) error {
+	return r.client.XAck(r.ctx, stream, group, ids...).Err()
+}
+
+func (r *Redis) XDel(stream string, ids ...string) error {
+	return r.client.XDel(r.ctx, stream, ids...).Err()
+}
+
+// XAutoClaim takes over entries pending longer than minIdle, starting at
+// start. It returns the entries and where the next call should start,
+// "0-0" once the pending list has been scanned.
+//
+// The reply is parsed here since go-redis v8 only understands the two
+// element reply of Redis 6.2, Redis 7 adds the list of deleted IDs.
+func (r *Redis) XAutoClaim(stream, group, consumer string, minIdle time.Duration, start string, count int64) ([]redis.XMessage, string, error) {
+	reply, err := r.client.Do(r.ctx, "xautoclaim", stream, group, consumer,
+		minIdle.Milliseconds(), start, "count", count).Slice()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(reply) < 2 {
+		return nil, "", fmt.Errorf("unexpected XAUTOCLAIM reply of %d elements", len(reply))
+	}
+
+	next, _ := reply[0].(string)
+	entries, _ := reply[1].([]interface{})
+
+	messages := make([]redis.XMessage, 0, len(entries))
+	for _, entry := range entries {
+		// Entries deleted while pending are nil on Redis 6.2
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) != 2 {
+			continue
+		}
+
+		id, _ := fields[0].(string)
+		pairs, _ := fields[1].([]interface{})
+		values := make(map[string]interface{}, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if key, ok := pairs[i].(string); ok {
+				values[key] = pairs[i+1]
+			}
+		}
+		messages = append(messages, redis.XMessage{ID: id, Values: values})
+	}
+
+	return messages, next, nil
+}
+
+func (r *Redis) XLen(stream string) (int64, error) {
+	return r.client.XLen(r.ctx, stream).Result()
+}
+
+// XPendingCount returns how many entries were delivered but not acknowledged
+func (r *Redis) XPendingCount(stream, group string) (int64, error) {
+	pending, err := r.client.XPending(r.ctx, stream, group).Result()
+	if err != nil {
+		return 0, err
+	}
+	return pending.Count, nil
+}
+
 // Expiration
 func (r *Redis) Expire(key string, expiration time.Duration) error {
 	return r.client.Expire(r.ctx, key, expiration).Err()
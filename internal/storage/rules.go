@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+// Notification rule operations
+func (m *MariaDB) CreateRule(rule *models.Rule) error {
+	query := `INSERT INTO notification_rules (
+		id, user_id, position, definition, conditions, actions, enabled
+	) VALUES (
+		:id, :user_id,
+		(SELECT COALESCE(MAX(r.position), 0) + 1 FROM notification_rules r WHERE r.user_id = :user_id),
+		:definition, :conditions, :actions, :enabled
+	)`
+	_, err := m.db.NamedExec(query, rule)
+	return err
+}
+
+// GetRules returns all of a user's rules in evaluation order
+func (m *MariaDB) GetRules(userID string) ([]*models.Rule, error) {
+	var rules []*models.Rule
+	query := `SELECT * FROM notification_rules WHERE user_id = ? ORDER BY position, created_at`
+	err := m.db.Select(&rules, query, userID)
+	return rules, err
+}
+
+// GetEnabledRules returns the rules to evaluate for a user's email
+func (m *MariaDB) GetEnabledRules(userID string) ([]*models.Rule, error) {
+	var rules []*models.Rule
+	query := `SELECT * FROM notification_rules WHERE user_id = ? AND enabled = TRUE ORDER BY position, created_at`
+	err := m.db.Select(&rules, query, userID)
+	return rules, err
+}
+
+func (m *MariaDB) GetRuleByID(id string) (*models.Rule, error) {
+	var rule models.Rule
+	query := `SELECT * FROM notification_rules WHERE id = ?`
+	err := m.db.Get(&rule, query, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &rule, err
+}
+
+func (m *MariaDB) SetRuleEnabled(id string, enabled bool) error {
+	query := `UPDATE notification_rules SET enabled = ? WHERE id = ?`
+	_, err := m.db.Exec(query, enabled, id)
+	return err
+}
+
+func (m *MariaDB) DeleteRule(id string) error {
+	query := `DELETE FROM notification_rules WHERE id = ?`
+	_, err := m.db.Exec(query, id)
+	return err
+}
+
+// SetEmailTags stores the tags rules put on an email, as a JSON array
+func (m *MariaDB) SetEmailTags(emailID string, tags *string) error {
+	query := `UPDATE email_messages SET tags = ?, updated_at = NOW() WHERE id = ?`
+	_, err := m.db.Exec(query, tags, emailID)
+	return err
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+// Telegram message operations
+func (m *MariaDB) SaveTelegramMessage(msg *models.TelegramMessage) error {
+	query := `INSERT INTO telegram_messages (id, email_id, chat_id, message_id, thread_id)
+		VALUES (:id, :email_id, :chat_id, :message_id, :thread_id)
+		ON DUPLICATE KEY UPDATE email_id = VALUES(email_id)`
+	_, err := m.db.NamedExec(query, msg)
+	return err
+}
+
+// GetTelegramMessages returns the notifications sent for an email, oldest first
+func (m *MariaDB) GetTelegramMessages(emailID string) ([]*models.TelegramMessage, error) {
+	var messages []*models.TelegramMessage
+	query := `SELECT * FROM telegram_messages WHERE email_id = ? ORDER BY created_at`
+	err := m.db.Select(&messages, query, emailID)
+	return messages, err
+}
+
+// GetTelegramMessage looks up the notification a chat message is
+func (m *MariaDB) GetTelegramMessage(chatID int64, messageID int) (*models.TelegramMessage, error) {
+	var msg models.TelegramMessage
+	query := `SELECT * FROM telegram_messages WHERE chat_id = ? AND message_id = ?`
+	err := m.db.Get(&msg, query, chatID, messageID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &msg, err
+}
+
+func (m *MariaDB) MarkEmailAsReplied(id string) error {
+	query := `UPDATE email_messages SET replied_at = NOW() WHERE id = ?`
+	_, err := m.db.Exec(query, id)
+	return err
+}
+
+func (m *MariaDB) MarkEmailAsDeleted(id string) error {
+	query := `UPDATE email_messages SET is_deleted = TRUE WHERE id = ?`
+	_, err := m.db.Exec(query, id)
+	return err
+}
+
+func (m *MariaDB) GetEmailMessageByGmailID(accountID, gmailID string) (*models.EmailMessage, error) {
+	var email models.EmailMessage
+	query := `SELECT * FROM email_messages WHERE account_id = ? AND gmail_id = ?`
+	err := m.db.Get(&email, query, accountID, gmailID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &email, err
+}
+
+// GetTrackedIMAPEmails returns the state of an account's IMAP emails since
+// a time that are still on the server as far as we know. Only the ID, UID
+// and flags are loaded.
+func (m *MariaDB) GetTrackedIMAPEmails(accountID string, since time.Time) ([]*models.EmailMessage, error) {
+	var emails []*models.EmailMessage
+	query := `SELECT id, imap_uid, is_read, is_deleted FROM email_messages
+		WHERE account_id = ? AND imap_uid IS NOT NULL AND is_deleted = FALSE AND created_at > ?`
+	err := m.db.Select(&emails, query, accountID, since)
+	return emails, err
+}
+
+// FindThreadID returns the thread of the newest of the account's emails
+// with one of the Message-IDs
+func (m *MariaDB) FindThreadID(accountID string, messageIDs []string) (*string, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	query, args, err := sqlx.In(`SELECT thread_id FROM email_messages
+		WHERE account_id = ? AND message_id IN (?) AND thread_id IS NOT NULL
+		ORDER BY date DESC LIMIT 1`, accountID, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var threadID string
+	err = m.db.Get(&threadID, m.db.Rebind(query), args...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &threadID, err
+}
+
+// GetLastThreadMessage returns the latest notification in a chat, or forum
+// topic, for another email of the same thread
+func (m *MariaDB) GetLastThreadMessage(accountID, threadID, emailID string, chatID int64, topicID *int) (*models.TelegramMessage, error) {
+	var msg models.TelegramMessage
+	query := `SELECT tm.* FROM telegram_messages tm
+		JOIN email_messages e ON e.id = tm.email_id
+		WHERE e.account_id = ? AND e.thread_id = ? AND e.id <> ?
+		AND tm.chat_id = ? AND tm.thread_id <=> ?
+		ORDER BY tm.created_at DESC LIMIT 1`
+	err := m.db.Get(&msg, query, accountID, threadID, emailID, chatID, topicID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &msg, err
+}
+
+// SetIMAPUIDValidity records the UIDVALIDITY of INBOX the account's stored
+// UIDs belong to
+func (m *MariaDB) SetIMAPUIDValidity(accountID string, validity int64) error {
+	query := `UPDATE email_accounts SET imap_uid_validity = ? WHERE id = ?`
+	_, err := m.db.Exec(query, validity, accountID)
+	return err
+}
+
+// ClearIMAPUIDs forgets the UIDs of an account's emails stored before a
+// time, after the UIDVALIDITY of INBOX changed
+func (m *MariaDB) ClearIMAPUIDs(accountID string, before time.Time) error {
+	query := `UPDATE email_messages SET imap_uid = NULL
+		WHERE account_id = ? AND imap_uid IS NOT NULL AND created_at < ?`
+	_, err := m.db.Exec(query, accountID, before)
+	return err
+}
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"html/template"
+
+	"github.com/kexi/mail-to-tg/pkg/links"
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+// embeddedView is a forwarded message prepared for the email template
+type embeddedView struct {
+	From        string
+	To          string
+	Cc          string
+	Subject     string
+	Date        string
+	Body        template.HTML
+	Attachments []string
+	Embedded    []embeddedView
+}
+
+// embeddedViews renders forwarded messages like the outer email, and
+// returns how many remote images they contain
+func (s *Server) embeddedViews(messages []*models.EmbeddedMessage, loadImages bool) ([]embeddedView, int) {
+	var views []embeddedView
+	remoteImages := 0
+
+	for _, message := range messages {
+		view := embeddedView{
+			From:        message.From,
+			To:          message.To,
+			Cc:          message.Cc,
+			Subject:     message.Subject,
+			Attachments: message.Attachments,
+		}
+		if view.Subject == "" {
+			view.Subject = "No subject"
+		}
+		if message.Date != nil {
+			view.Date = message.Date.Format("2006-01-02 15:04:05")
+		}
+
+		body := "<p>No content available</p>"
+		if message.SanitizedHTML != "" {
+			body = links.AnnotateHTML(message.SanitizedHTML)
+		} else if message.TextBody != "" {
+			body = "<pre>" + template.HTMLEscapeString(message.TextBody) + "</pre>"
+		}
+
+		body, n := s.rewriteImages(body, loadImages)
+		view.Body = template.HTML(body)
+		remoteImages += n
+
+		var nested int
+		view.Embedded, nested = s.embeddedViews(message.Embedded, loadImages)
+		remoteImages += nested
+
+		views = append(views, view)
+	}
+
+	return views, remoteImages
+}
@@ -1,14 +1,22 @@
 package web
 
 import (
+	"errors"
 	"html/template"
+	"mime"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/kexi/mail-to-tg/internal/blobstore"
+	"github.com/kexi/mail-to-tg/pkg/crypto"
+	"github.com/kexi/mail-to-tg/pkg/links"
+	"github.com/kexi/mail-to-tg/pkg/models"
 	"github.com/rs/zerolog/log"
 )
 
-func (s *Server) handleViewEmail(c *gin.Context) {
+// emailByViewToken resolves the :token parameter to its email, writing
+// the error response when there is none
+func (s *Server) emailByViewToken(c *gin.Context) (*models.EmailMessage, bool) {
 	token := c.Param("token")
 
 	// Get and validate token
@@ -16,12 +24,12 @@ func (s *Server) handleViewEmail(c *gin.Context) {
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get view token")
 		c.String(http.StatusInternalServerError, "Internal server error")
-		return
+		return nil, false
 	}
 
 	if viewToken == nil {
 		c.String(http.StatusNotFound, "Email not found or link has expired")
-		return
+		return nil, false
 	}
 
 	// Get email
@@ -29,11 +37,20 @@ func (s *Server) handleViewEmail(c *gin.Context) {
 	if err != nil || email == nil {
 		log.Error().Err(err).Str("email_id", viewToken.EmailID).Msg("Failed to get email")
 		c.String(http.StatusNotFound, "Email not found")
+		return nil, false
+	}
+
+	return email, true
+}
+
+func (s *Server) handleViewEmail(c *gin.Context) {
+	email, ok := s.emailByViewToken(c)
+	if !ok {
 		return
 	}
 
 	// Increment view count
-	s.db.IncrementTokenViewCount(token)
+	s.db.IncrementTokenViewCount(c.Param("token"))
 
 	// Render email
 	htmlContent := ""
@@ -46,6 +63,21 @@ func (s *Server) handleViewEmail(c *gin.Context) {
 		htmlContent = "<p>No content available</p>"
 	}
 
+	// Emails stored earlier have wrapped links, unwrapping again is a no-op
+	htmlContent = links.AnnotateHTML(htmlContent)
+
+	// Remote images stay blocked until the viewer asks for them
+	loadImages := c.Query("images") == "1"
+	htmlContent, remoteImages := s.rewriteImages(htmlContent, loadImages)
+
+	// Messages forwarded as attachments are shown below the body
+	embedded, err := email.ParseEmbeddedMessages()
+	if err != nil {
+		log.Warn().Err(err).Str("email_id", email.ID).Msg("Failed to parse embedded messages")
+	}
+	embeddedViews, embeddedImages := s.embeddedViews(embedded, loadImages)
+	remoteImages += embeddedImages
+
 	subject := "No subject"
 	if email.Subject != nil {
 		subject = *email.Subject
@@ -56,13 +88,114 @@ func (s *Server) handleViewEmail(c *gin.Context) {
 		fromName = *email.FromName + " <" + email.FromAddress + ">"
 	}
 
+	cryptoStatus, err := email.ParseCryptoStatus()
+	if err != nil {
+		log.Warn().Err(err).Str("email_id", email.ID).Msg("Failed to parse crypto status")
+	}
+
 	data := gin.H{
 		"Subject":     subject,
 		"From":        fromName,
 		"To":          email.ToAddresses,
 		"Date":        email.Date.Format("2006-01-02 15:04:05"),
 		"HTMLContent": template.HTML(htmlContent),
+		"Crypto":      cryptoStatus,
+		"Embedded":    embeddedViews,
+
+		"BlockedImages": 0,
+		"LoadImagesURL": c.Request.URL.Path + "?images=1",
+		"RawURL":        c.Request.URL.Path + "/raw",
+		"PrintURL":      c.Request.URL.Path + "/print",
+		"HasRaw":        email.RawSHA256 != nil && !hasBlockedAttachments(email),
+	}
+	if !loadImages {
+		data["BlockedImages"] = remoteImages
 	}
 
 	c.HTML(http.StatusOK, "email.html", data)
 }
+
+func (s *Server) handleDownloadAttachment(c *gin.Context) {
+	payload, err := s.signer.Verify(c.Param("token"))
+	if errors.Is(err, crypto.ErrTokenExpired) {
+		c.String(http.StatusGone, "Download link has expired")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusNotFound, "Attachment not found")
+		return
+	}
+
+	emailID, index, err := models.ParseAttachmentRef(payload)
+	if err != nil {
+		c.String(http.StatusNotFound, "Attachment not found")
+		return
+	}
+
+	// Get email
+	email, err := s.db.GetEmailMessageByID(emailID)
+	if err != nil || email == nil {
+		log.Error().Err(err).Str("email_id", emailID).Msg("Failed to get email")
+		c.String(http.StatusNotFound, "Attachment not found")
+		return
+	}
+
+	attachments, err := email.ParseAttachments()
+	if err != nil || index >= len(attachments) {
+		log.Error().Err(err).Str("email_id", emailID).Int("index", index).Msg("Failed to resolve attachment")
+		c.String(http.StatusNotFound, "Attachment not found")
+		return
+	}
+	attachment := attachments[index]
+
+	if attachment.Skipped == models.SkippedExpired {
+		c.String(http.StatusGone, "Attachment has expired")
+		return
+	}
+
+	if attachment.Path == "" {
+		c.String(http.StatusNotFound, "Attachment was not stored")
+		return
+	}
+
+	// Quarantined files need an explicit confirmation
+	if attachment.Safety == models.SafetyQuarantined && c.Query("confirm") != "1" {
+		c.HTML(http.StatusOK, "quarantine.html", gin.H{
+			"Filename":    attachment.Filename,
+			"ContentType": attachment.ContentType,
+			"Reason":      attachment.SafetyReason,
+			"DownloadURL": c.Request.URL.Path + "?confirm=1",
+		})
+		return
+	}
+
+	obj, info, err := s.blobs.Open(attachment.Path)
+	if err == blobstore.ErrNotFound {
+		c.String(http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("email_id", emailID).Str("path", attachment.Path).Msg("Failed to open attachment")
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer obj.Close()
+
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{
+		"filename": attachment.Filename,
+	})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", disposition)
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(c.Writer, c.Request, attachment.Filename, info.ModTime, obj)
+}
@@ -0,0 +1,222 @@
+package web
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kexi/mail-to-tg/pkg/crypto"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/html"
+)
+
+// Proxied image links only need to live as long as the page is open
+const imageTokenTTL = time.Hour
+
+const imageFetchTimeout = 15 * time.Second
+
+// rewriteImages prepares remote images for display. Unless load is set
+// they stay blocked; otherwise they point at the image proxy. It returns
+// the number of remote images found.
+func (s *Server) rewriteImages(content string, load bool) (string, int) {
+	var out bytes.Buffer
+	remote := 0
+	z := html.NewTokenizer(strings.NewReader(content))
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return content, remote
+			}
+			return out.String(), remote
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			out.Write(z.Raw())
+			continue
+		}
+
+		token := z.Token()
+		if token.Data != "img" {
+			out.Write(z.Raw())
+			continue
+		}
+
+		// Emails stored before images were blocked still have a remote src
+		var attrs []html.Attribute
+		var target string
+		for _, attr := range token.Attr {
+			switch {
+			case attr.Key == models.RemoteImageAttr:
+				target = attr.Val
+			case attr.Key == "src" && isRemoteImage(attr.Val):
+				target = attr.Val
+			default:
+				attrs = append(attrs, attr)
+			}
+		}
+
+		if target == "" {
+			out.Write(z.Raw())
+			continue
+		}
+		remote++
+
+		if load {
+			attrs = append(attrs, html.Attribute{Key: "src", Val: s.imageProxyURL(target)})
+		} else {
+			attrs = append(attrs, html.Attribute{Key: models.RemoteImageAttr, Val: target})
+		}
+		token.Attr = attrs
+		out.WriteString(token.String())
+	}
+}
+
+func (s *Server) imageProxyURL(target string) string {
+	return "/image/" + s.signer.Sign(target, time.Now().Add(imageTokenTTL))
+}
+
+func isRemoteImage(src string) bool {
+	u, err := url.Parse(src)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// handleImageProxy fetches a remote image on behalf of the viewer, so the
+// sender sees our server instead of the user's browser
+func (s *Server) handleImageProxy(c *gin.Context) {
+	target, err := s.signer.Verify(c.Param("token"))
+	if errors.Is(err, crypto.ErrTokenExpired) {
+		c.String(http.StatusGone, "Image link has expired")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusNotFound, "Image not found")
+		return
+	}
+
+	img, ok := s.images.get(target)
+	if !ok {
+		img, err = s.fetchImage(target)
+		if err != nil {
+			log.Warn().Err(err).Str("url", target).Msg("Failed to proxy image")
+			c.String(http.StatusBadGateway, "Failed to load image")
+			return
+		}
+		s.images.put(target, img)
+	}
+
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'")
+	c.Data(http.StatusOK, img.contentType, img.data)
+}
+
+func (s *Server) fetchImage(target string) (*cachedImage, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image URL: %w", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; mail-to-tg image proxy)")
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := s.imageClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image request returned %s", resp.Status)
+	}
+
+	maxSize := int64(s.cfg.ImageProxyMaxSizeMB) << 20
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("image is larger than %d MB", s.cfg.ImageProxyMaxSizeMB)
+	}
+
+	// Trust the bytes, not the header. SVG can carry scripts and is refused.
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") || strings.Contains(contentType, "svg") {
+		return nil, fmt.Errorf("not an image: %s", contentType)
+	}
+
+	return &cachedImage{contentType: contentType, data: data}, nil
+}
+
+type cachedImage struct {
+	url         string
+	contentType string
+	data        []byte
+}
+
+// imageCache is a least-recently-used cache bounded by total size
+type imageCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+func newImageCache(maxBytes int64) *imageCache {
+	return &imageCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *imageCache) get(url string) (*cachedImage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedImage), true
+}
+
+func (c *imageCache) put(url string, img *cachedImage) {
+	size := int64(len(img.data))
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[url]; ok {
+		c.size -= int64(len(elem.Value.(*cachedImage).data))
+		c.order.Remove(elem)
+	}
+
+	img.url = url
+	c.entries[url] = c.order.PushFront(img)
+	c.size += size
+
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		evicted := c.order.Remove(oldest).(*cachedImage)
+		delete(c.entries, evicted.url)
+		c.size -= int64(len(evicted.data))
+	}
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kexi/mail-to-tg/pkg/crypto"
+)
+
+func TestRewriteImages(t *testing.T) {
+	s := &Server{signer: crypto.NewSigner([]byte("test-secret"))}
+	content := `<p>Hi</p><img data-remote-src="https://cdn.example.com/a.png" alt="A"><img src="http://old.example.com/b.png">`
+
+	blocked, n := s.rewriteImages(content, false)
+	if n != 2 {
+		t.Fatalf("found %d remote images, want 2", n)
+	}
+	if strings.Contains(blocked, " src=") {
+		t.Errorf("blocked view still loads images: %s", blocked)
+	}
+
+	loaded, _ := s.rewriteImages(content, true)
+	if strings.Contains(loaded, "example.com") {
+		t.Errorf("loaded view points at the sender: %s", loaded)
+	}
+	if strings.Count(loaded, `src="/image/`) != 2 {
+		t.Errorf("images not proxied: %s", loaded)
+	}
+	if !strings.Contains(loaded, `alt="A"`) {
+		t.Errorf("attributes lost: %s", loaded)
+	}
+}
+
+func TestImageCacheEvictsOldest(t *testing.T) {
+	cache := newImageCache(10)
+
+	cache.put("a", &cachedImage{data: make([]byte, 4)})
+	cache.put("b", &cachedImage{data: make([]byte, 4)})
+	cache.get("a")
+	cache.put("c", &cachedImage{data: make([]byte, 4)})
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if _, ok := cache.get("a"); !ok {
+		t.Error("recently used entry was evicted")
+	}
+	if cache.size != 8 {
+		t.Errorf("size = %d, want 8", cache.size)
+	}
+
+	cache.put("big", &cachedImage{data: make([]byte, 11)})
+	if _, ok := cache.get("big"); ok {
+		t.Error("entry larger than the cache was stored")
+	}
+}
@@ -0,0 +1,243 @@
+package web
+
+import (
+	"bufio"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kexi/mail-to-tg/internal/attachments"
+	"github.com/kexi/mail-to-tg/internal/blobstore"
+	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/kexi/mail-to-tg/pkg/textutil"
+	"github.com/rs/zerolog/log"
+)
+
+// Headers past this size are not shown on the print page
+const maxHeaderBytes = 256 << 10
+
+type headerField struct {
+	Name  string
+	Value string
+}
+
+// handleRawEmail downloads the original message as an .eml file
+func (s *Server) handleRawEmail(c *gin.Context) {
+	email, ok := s.emailByViewToken(c)
+	if !ok {
+		return
+	}
+
+	if email.RawSHA256 == nil {
+		c.String(http.StatusNotFound, "The original message was not kept")
+		return
+	}
+
+	// The original carries the attachments the safety policy withheld
+	blocked, quarantined := withheldAttachments(email)
+	if len(blocked) > 0 {
+		c.String(http.StatusForbidden, "The original message contains blocked attachments and can't be downloaded")
+		return
+	}
+	if len(quarantined) > 0 && c.Query("confirm") != "1" {
+		c.HTML(http.StatusOK, "quarantine.html", gin.H{
+			"Filename":    emlFilename(email),
+			"ContentType": "message/rfc822",
+			"Reason":      "it contains quarantined attachments (" + strings.Join(quarantined, ", ") + ")",
+			"DownloadURL": c.Request.URL.Path + "?confirm=1",
+		})
+		return
+	}
+
+	obj, info, err := s.blobs.Open(attachments.BlobKey(*email.RawSHA256))
+	if err == blobstore.ErrNotFound {
+		c.String(http.StatusNotFound, "The original message was not kept")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("email_id", email.ID).Msg("Failed to open raw message")
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer obj.Close()
+
+	filename := emlFilename(email)
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	c.Header("Content-Type", "message/rfc822")
+	c.Header("Content-Disposition", disposition)
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(c.Writer, c.Request, filename, info.ModTime, obj)
+}
+
+// handlePrintEmail renders a print layout with the full headers,
+// recipients and attachment list
+func (s *Server) handlePrintEmail(c *gin.Context) {
+	email, ok := s.emailByViewToken(c)
+	if !ok {
+		return
+	}
+
+	var headers []headerField
+	if email.RawSHA256 != nil {
+		var err error
+		headers, err = s.readRawHeaders(*email.RawSHA256)
+		if err != nil {
+			log.Warn().Err(err).Str("email_id", email.ID).Msg("Failed to read raw headers")
+		}
+	}
+
+	// Printing never loads remote images
+	body := "<p>No content available</p>"
+	if email.SanitizedHTML != nil {
+		body, _ = s.rewriteImages(*email.SanitizedHTML, false)
+	} else if email.TextBody != nil {
+		body = "<pre>" + template.HTMLEscapeString(*email.TextBody) + "</pre>"
+	}
+
+	attachmentList, err := email.ParseAttachments()
+	if err != nil {
+		log.Warn().Err(err).Str("email_id", email.ID).Msg("Failed to parse attachments")
+	}
+	var files []gin.H
+	for _, attachment := range attachmentList {
+		files = append(files, gin.H{
+			"Filename":    attachment.Filename,
+			"ContentType": attachment.ContentType,
+			"Size":        textutil.FormatSize(attachment.Size),
+			"Parent":      attachment.Parent,
+		})
+	}
+
+	subject := "No subject"
+	if email.Subject != nil {
+		subject = *email.Subject
+	}
+
+	from := email.FromAddress
+	if email.FromName != nil {
+		from = *email.FromName + " <" + email.FromAddress + ">"
+	}
+
+	c.HTML(http.StatusOK, "print.html", gin.H{
+		"Subject":     subject,
+		"From":        from,
+		"To":          headerValue(headers, "To", email.ToAddresses),
+		"Cc":          headerValue(headers, "Cc", nil),
+		"Bcc":         headerValue(headers, "Bcc", nil),
+		"ReplyTo":     headerValue(headers, "Reply-To", nil),
+		"Date":        email.Date.Format("2006-01-02 15:04:05 -0700"),
+		"MessageID":   email.MessageID,
+		"Headers":     headers,
+		"Attachments": files,
+		"Body":        template.HTML(body),
+	})
+}
+
+// readRawHeaders reads the header block of the stored original, in order
+// and with encoded words decoded
+func (s *Server) readRawHeaders(sha256 string) ([]headerField, error) {
+	obj, _, err := s.blobs.Open(attachments.BlobKey(sha256))
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	return parseHeaderBlock(io.LimitReader(obj, maxHeaderBytes))
+}
+
+func parseHeaderBlock(r io.Reader) ([]headerField, error) {
+	var fields []headerField
+	decoder := &mime.WordDecoder{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxHeaderBytes)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+
+		// Folded continuation of the previous field
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields = append(fields, headerField{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+	}
+	if err := scanner.Err(); err != nil {
+		return fields, err
+	}
+
+	for i := range fields {
+		if decoded, err := decoder.DecodeHeader(fields[i].Value); err == nil {
+			fields[i].Value = decoded
+		}
+	}
+	return fields, nil
+}
+
+// headerValue joins all values of a header, falling back to a stored value
+func headerValue(headers []headerField, name string, fallback *string) string {
+	var values []string
+	for _, field := range headers {
+		if strings.EqualFold(field.Name, name) {
+			values = append(values, field.Value)
+		}
+	}
+	if len(values) == 0 && fallback != nil {
+		return *fallback
+	}
+	return strings.Join(values, ", ")
+}
+
+// withheldAttachments returns the names of the email's attachments the
+// safety policy blocked or quarantined
+func withheldAttachments(email *models.EmailMessage) (blocked, quarantined []string) {
+	attachmentList, err := email.ParseAttachments()
+	if err != nil {
+		log.Warn().Err(err).Str("email_id", email.ID).Msg("Failed to parse attachments")
+	}
+	for _, attachment := range attachmentList {
+		switch attachment.Safety {
+		case models.SafetyBlocked:
+			blocked = append(blocked, attachment.Filename)
+		case models.SafetyQuarantined:
+			quarantined = append(quarantined, attachment.Filename)
+		}
+	}
+	return blocked, quarantined
+}
+
+func hasBlockedAttachments(email *models.EmailMessage) bool {
+	blocked, _ := withheldAttachments(email)
+	return len(blocked) > 0
+}
+
+func emlFilename(email *models.EmailMessage) string {
+	name := "message"
+	if email.Subject != nil && strings.TrimSpace(*email.Subject) != "" {
+		name = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+				return '_'
+			}
+			return r
+		}, strings.TrimSpace(*email.Subject))
+		if len([]rune(name)) > 100 {
+			name = string([]rune(name)[:100])
+		}
+	}
+	return name + ".eml"
+}
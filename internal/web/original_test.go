@@ -0,0 +1,65 @@
+package web
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kexi/mail-to-tg/pkg/models"
+)
+
+func TestParseHeaderBlock(t *testing.T) {
+	raw := "Received: from mx.example.com\r\n" +
+		"\tby mail.example.org; Mon, 2 Mar 2026 10:00:00 +0000\r\n" +
+		"From: =?UTF-8?B?5byg5LiJ?= <zhang@example.com>\r\n" +
+		"To: a@example.org\r\n" +
+		"To: b@example.org\r\n" +
+		"Subject: Hello\r\n" +
+		"\r\n" +
+		"Body: not a header\r\n"
+
+	headers, err := parseHeaderBlock(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(headers) != 5 {
+		t.Fatalf("got %d headers, want 5: %+v", len(headers), headers)
+	}
+	if headers[0].Value != "from mx.example.com by mail.example.org; Mon, 2 Mar 2026 10:00:00 +0000" {
+		t.Errorf("folded header = %q", headers[0].Value)
+	}
+	if headers[1].Value != "张三 <zhang@example.com>" {
+		t.Errorf("encoded header = %q", headers[1].Value)
+	}
+	if got := headerValue(headers, "to", nil); got != "a@example.org, b@example.org" {
+		t.Errorf("To = %q", got)
+	}
+}
+
+func TestEmlFilename(t *testing.T) {
+	subject := `Invoice 2026/03: "final"`
+	email := &models.EmailMessage{Subject: &subject}
+	if got := emlFilename(email); got != "Invoice 2026_03_ _final_.eml" {
+		t.Errorf("emlFilename() = %q", got)
+	}
+
+	if got := emlFilename(&models.EmailMessage{}); got != "message.eml" {
+		t.Errorf("emlFilename() = %q", got)
+	}
+}
+
+func TestWithheldAttachments(t *testing.T) {
+	attachments := `[{"filename":"report.pdf"},{"filename":"setup.exe","safety":"blocked"},{"filename":"budget.xlsm","safety":"quarantined"}]`
+	email := &models.EmailMessage{Attachments: &attachments}
+
+	blocked, quarantined := withheldAttachments(email)
+	if len(blocked) != 1 || blocked[0] != "setup.exe" {
+		t.Errorf("blocked = %v", blocked)
+	}
+	if len(quarantined) != 1 || quarantined[0] != "budget.xlsm" {
+		t.Errorf("quarantined = %v", quarantined)
+	}
+	if !hasBlockedAttachments(email) {
+		t.Error("hasBlockedAttachments() = false")
+	}
+}
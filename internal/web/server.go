@@ -2,10 +2,14 @@ package web
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/kexi/mail-to-tg/internal/blobstore"
 	"github.com/kexi/mail-to-tg/internal/storage"
 	"github.com/kexi/mail-to-tg/pkg/config"
+	"github.com/kexi/mail-to-tg/pkg/crypto"
+	"github.com/kexi/mail-to-tg/pkg/netutil"
 	"github.com/rs/zerolog/log"
 )
 
@@ -13,9 +17,14 @@ type Server struct {
 	router *gin.Engine
 	cfg    *config.WebConfig
 	db     *storage.MariaDB
+	blobs  blobstore.Store
+	signer *crypto.Signer
+
+	images      *imageCache
+	imageClient *http.Client
 }
 
-func NewServer(cfg *config.WebConfig, db *storage.MariaDB) *Server {
+func NewServer(cfg *config.WebConfig, db *storage.MariaDB, blobs blobstore.Store, signer *crypto.Signer) *Server {
 	if cfg.TLSEnabled {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -29,6 +38,11 @@ func NewServer(cfg *config.WebConfig, db *storage.MariaDB) *Server {
 		router: router,
 		cfg:    cfg,
 		db:     db,
+		blobs:  blobs,
+		signer: signer,
+
+		images:      newImageCache(int64(cfg.ImageCacheSizeMB) << 20),
+		imageClient: netutil.NewPublicClient(imageFetchTimeout),
 	}
 
 	s.setupRoutes()
@@ -39,6 +53,10 @@ func NewServer(cfg *config.WebConfig, db *storage.MariaDB) *Server {
 func (s *Server) setupRoutes() {
 	s.router.GET("/health", s.handleHealth)
 	s.router.GET("/email/:token", s.handleViewEmail)
+	s.router.GET("/email/:token/raw", s.handleRawEmail)
+	s.router.GET("/email/:token/print", s.handlePrintEmail)
+	s.router.GET("/attachment/:token", s.handleDownloadAttachment)
+	s.router.GET("/image/:token", s.handleImageProxy)
 }
 
 func (s *Server) Start() error {
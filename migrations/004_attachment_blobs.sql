@@ -0,0 +1,30 @@
+-- Content-addressed attachment storage
+-- Migration: 004_attachment_blobs
+
+-- One row per unique attachment content, keyed by SHA-256
+CREATE TABLE IF NOT EXISTS attachment_blobs (
+    sha256 CHAR(64) PRIMARY KEY,
+    size BIGINT NOT NULL,
+    content_type VARCHAR(255),
+    ref_count INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_referenced_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_unreferenced (ref_count, last_referenced_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- References from emails to blobs
+CREATE TABLE IF NOT EXISTS email_attachments (
+    id CHAR(36) PRIMARY KEY,
+    email_id CHAR(36) NOT NULL,
+    blob_sha256 CHAR(64) NOT NULL,
+    filename VARCHAR(500) NOT NULL,
+    content_type VARCHAR(255),
+    size BIGINT NOT NULL,
+    position INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (email_id) REFERENCES email_messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (blob_sha256) REFERENCES attachment_blobs(sha256),
+    UNIQUE KEY unique_email_position (email_id, position),
+    INDEX idx_blob (blob_sha256)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,9 @@
+-- Calendar invitations (iMIP) and user timezones
+-- Migration: 005_calendar_invitations
+
+ALTER TABLE email_messages
+ADD COLUMN calendar_event JSON NULL COMMENT 'Parsed iCalendar event of an invitation',
+ADD COLUMN calendar_rsvp VARCHAR(20) NULL COMMENT 'PARTSTAT sent in reply: ACCEPTED, TENTATIVE or DECLINED';
+
+ALTER TABLE users
+ADD COLUMN timezone VARCHAR(64) NULL COMMENT 'IANA timezone used to display times';
@@ -0,0 +1,19 @@
+-- S/MIME and OpenPGP keys and per-email verification results
+-- Migration: 006_secure_mail
+
+CREATE TABLE IF NOT EXISTS user_crypto_keys (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    kind VARCHAR(10) NOT NULL COMMENT 'smime or pgp',
+    fingerprint VARCHAR(128) NOT NULL,
+    identity VARCHAR(255) NOT NULL,
+    has_private BOOLEAN NOT NULL DEFAULT FALSE,
+    key_data_encrypted MEDIUMTEXT NOT NULL COMMENT 'PEM or armored key, encrypted',
+    passphrase_encrypted TEXT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE KEY uk_user_fingerprint (user_id, fingerprint)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE email_messages
+ADD COLUMN crypto_status JSON NULL COMMENT 'Signature and decryption results';
@@ -0,0 +1,5 @@
+-- Sender authentication (SPF/DKIM/DMARC) verdicts
+-- Migration: 007_auth_verdict
+
+ALTER TABLE email_messages
+ADD COLUMN auth_verdict JSON NULL COMMENT 'SPF, DKIM and DMARC results and alignment';
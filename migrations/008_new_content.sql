@@ -0,0 +1,5 @@
+-- New content of each email, without quoted replies and signatures
+-- Migration: 008_new_content
+
+ALTER TABLE email_messages
+ADD COLUMN new_content MEDIUMTEXT NULL COMMENT 'Body without quoted text and signature';
@@ -0,0 +1,5 @@
+-- Plain text rendering of HTML bodies
+-- Migration: 009_html_text
+
+ALTER TABLE email_messages
+ADD COLUMN html_text MEDIUMTEXT NULL COMMENT 'HTML body rendered as plain text';
@@ -0,0 +1,24 @@
+-- Mailing list headers and one-click unsubscribe
+-- Migration: 010_list_unsubscribe
+
+ALTER TABLE email_messages
+ADD COLUMN list_id VARCHAR(255) NULL COMMENT 'List-Id, without the description',
+ADD COLUMN list_unsubscribe TEXT NULL COMMENT 'List-Unsubscribe header',
+ADD COLUMN list_unsubscribe_post VARCHAR(255) NULL COMMENT 'List-Unsubscribe-Post header',
+ADD INDEX idx_list_id (list_id);
+
+CREATE TABLE IF NOT EXISTS list_unsubscribes (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    list_key VARCHAR(255) NOT NULL COMMENT 'List-Id, or the sender address',
+    name VARCHAR(255) NOT NULL,
+    method VARCHAR(20) NOT NULL COMMENT 'one_click or mailto',
+    target TEXT NOT NULL,
+    success BOOLEAN NOT NULL DEFAULT FALSE,
+    error TEXT NULL,
+    muted BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE KEY uk_user_list (user_id, list_key)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,5 @@
+-- Forwarded messages attached to an email
+-- Migration: 011_embedded_messages
+
+ALTER TABLE email_messages
+ADD COLUMN embedded_messages JSON NULL COMMENT 'Parsed message/rfc822 attachments';
@@ -0,0 +1,6 @@
+-- Original messages kept in the blob store for .eml downloads
+-- Migration: 012_raw_messages
+
+ALTER TABLE email_messages
+ADD COLUMN raw_sha256 CHAR(64) NULL COMMENT 'Blob of the original message',
+ADD INDEX idx_raw_sha256 (raw_sha256);
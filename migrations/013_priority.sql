@@ -0,0 +1,5 @@
+-- Notification priority computed at ingestion
+-- Migration: 013_priority
+
+ALTER TABLE email_messages
+ADD COLUMN priority VARCHAR(10) NOT NULL DEFAULT 'normal' COMMENT 'high, normal or low queue lane';
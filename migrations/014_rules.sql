@@ -0,0 +1,19 @@
+-- Per-user notification rules and email tags
+-- Migration: 014_rules
+
+CREATE TABLE IF NOT EXISTS notification_rules (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    position INT NOT NULL DEFAULT 0 COMMENT 'Rules are evaluated in ascending order',
+    definition TEXT NOT NULL COMMENT 'Rule as the user wrote it',
+    conditions TEXT NOT NULL COMMENT 'JSON object',
+    actions TEXT NOT NULL COMMENT 'JSON object',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_user_position (user_id, position)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE email_messages
+ADD COLUMN tags TEXT NULL COMMENT 'JSON array of tags set by rules';
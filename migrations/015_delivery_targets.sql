@@ -0,0 +1,7 @@
+-- Per-account delivery to groups, channels and forum topics
+-- Migration: 015_delivery_targets
+
+ALTER TABLE email_accounts
+ADD COLUMN delivery_chat_id BIGINT NULL COMMENT 'Chat notifications go to, the owner''s private chat when NULL',
+ADD COLUMN delivery_thread_id INT NULL COMMENT 'Forum topic in delivery_chat_id',
+ADD COLUMN delivery_verified_at TIMESTAMP NULL COMMENT 'When the owner was verified as an admin of the chat';
@@ -0,0 +1,18 @@
+-- Telegram messages sent for each email, so they can be edited later
+-- Migration: 016_telegram_messages
+
+CREATE TABLE IF NOT EXISTS telegram_messages (
+    id CHAR(36) PRIMARY KEY,
+    email_id CHAR(36) NOT NULL,
+    chat_id BIGINT NOT NULL,
+    message_id INT NOT NULL,
+    thread_id INT NULL COMMENT 'Forum topic the message is in',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (email_id) REFERENCES email_messages(id) ON DELETE CASCADE,
+    UNIQUE KEY uk_chat_message (chat_id, message_id),
+    INDEX idx_email_id (email_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE email_messages
+ADD COLUMN replied_at TIMESTAMP NULL COMMENT 'Last reply sent from Telegram',
+ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT FALSE COMMENT 'Deleted or trashed on the mail server';
@@ -0,0 +1,12 @@
+-- Threads for IMAP mail
+-- Migration: 017_imap_threads
+
+-- IMAP servers have no thread IDs; emails fetched from now on join the
+-- thread of the mail they reference. Earlier ones start their own.
+UPDATE email_messages
+SET thread_id = message_id
+WHERE thread_id IS NULL AND imap_uid IS NOT NULL;
+
+-- Finding the previous notification of a thread
+ALTER TABLE email_messages
+ADD INDEX idx_account_thread (account_id, thread_id);
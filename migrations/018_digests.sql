@@ -0,0 +1,18 @@
+-- Digests of low-priority mail
+-- Migration: 018_digests
+
+ALTER TABLE users
+ADD COLUMN digest_schedule ENUM('off', 'hourly', 'daily') NOT NULL DEFAULT 'off' COMMENT 'Low-priority mail goes to the digest unless off',
+ADD COLUMN digest_time CHAR(5) NOT NULL DEFAULT '08:00' COMMENT 'Local time of the daily digest, HH:MM';
+
+-- Emails waiting for their user's next digest
+CREATE TABLE IF NOT EXISTS digest_items (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    email_id CHAR(36) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (email_id) REFERENCES email_messages(id) ON DELETE CASCADE,
+    UNIQUE KEY uk_email_id (email_id),
+    INDEX idx_user_created (user_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
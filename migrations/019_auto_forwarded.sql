@@ -0,0 +1,7 @@
+-- Mark automatically forwarded mail
+-- Migration: 019_auto_forwarded
+
+-- Set from Auto-Submitted: auto-forwarded and the marker rule forwarding
+-- adds; rules don't forward such mail again
+ALTER TABLE email_messages
+ADD COLUMN auto_forwarded BOOLEAN NOT NULL DEFAULT FALSE COMMENT 'Forwarded automatically, not forwarded again by rules';
@@ -0,0 +1,7 @@
+-- Remember the UIDVALIDITY of IMAP inboxes
+-- Migration: 020_imap_uid_validity
+
+-- Stored UIDs are only compared with the server while INBOX keeps the
+-- UIDVALIDITY they were seen with
+ALTER TABLE email_accounts
+ADD COLUMN imap_uid_validity BIGINT NULL COMMENT 'UIDVALIDITY of INBOX the stored imap_uid values belong to';
@@ -10,6 +10,7 @@ type Config struct {
 	Environment string            `json:"environment"`
 	Database    DatabaseConfig    `json:"database"`
 	Redis       RedisConfig       `json:"redis"`
+	Queue       QueueConfig       `json:"queue"`
 	MailFetcher MailFetcherConfig `json:"mail_fetcher"`
 	Telegram    TelegramConfig    `json:"telegram"`
 	Web         WebConfig         `json:"web"`
@@ -36,6 +37,25 @@ type RedisConfig struct {
 	DB       int    `json:"db"`
 }
 
+// QueueConfig controls the notification stream consumer
+type QueueConfig struct {
+	ConsumerName     string `json:"consumer_name"`      // Unique per telegram-service instance, defaults to the hostname
+	ClaimIdleSeconds int    `json:"claim_idle_seconds"` // Unacknowledged events older than this are taken over
+	MaxAttempts      int    `json:"max_attempts"`       // Failed deliveries before an event is dead-lettered
+	RetryBaseSeconds int    `json:"retry_base_seconds"` // First retry delay, doubled on every attempt
+	RetryMaxSeconds  int    `json:"retry_max_seconds"`  // Upper bound for the retry delay
+	Workers          int    `json:"workers"`            // Notifications sent concurrently, in order per chat
+
+	// Emails left unnotified, e.g. because publishing failed, are queued
+	// again once they are older than the grace period
+	SweepIntervalSeconds int `json:"sweep_interval_seconds"`
+	SweepGraceMinutes    int `json:"sweep_grace_minutes"`
+	SweepMaxAgeHours     int `json:"sweep_max_age_hours"` // Older emails are left alone
+
+	// Senders whose mail skips ahead of the queue, addresses or @domain
+	VIPSenders []string `json:"vip_senders"`
+}
+
 type MailFetcherConfig struct {
 	Workers          int         `json:"workers"`
 	IMAPPollInterval int         `json:"imap_poll_interval"`
@@ -50,8 +70,16 @@ type GmailConfig struct {
 }
 
 type TelegramConfig struct {
-	BotToken   string `json:"bot_token"`
-	WebhookURL string `json:"webhook_url"`
+	BotToken        string  `json:"bot_token"`
+	WebhookURL      string  `json:"webhook_url"`
+	SendAttachments bool    `json:"send_attachments"`
+	MaxUploadSizeMB int     `json:"max_upload_size_mb"`
+	AdminIDs        []int64 `json:"admin_ids"` // Telegram user IDs allowed to use admin commands such as /dlq
+
+	// Send rates kept below Telegram's limits
+	GlobalRatePerSecond float64 `json:"global_rate_per_second"`
+	ChatRatePerSecond   float64 `json:"chat_rate_per_second"`
+	GroupRatePerMinute  float64 `json:"group_rate_per_minute"`
 }
 
 type WebConfig struct {
@@ -61,15 +89,47 @@ type WebConfig struct {
 	TLSEnabled bool   `json:"tls_enabled"`
 	TLSCert    string `json:"tls_cert"`
 	TLSKey     string `json:"tls_key"`
+
+	AttachmentLinkTTLHours int `json:"attachment_link_ttl_hours"`
+
+	// Remote images in the email view are loaded through this server
+	ImageProxyMaxSizeMB int `json:"image_proxy_max_size_mb"`
+	ImageCacheSizeMB    int `json:"image_cache_size_mb"`
 }
 
 type SecurityConfig struct {
-	EncryptionKey string `json:"encryption_key"`
-	JWTSecret     string `json:"jwt_secret"`
+	EncryptionKey    string                 `json:"encryption_key"`
+	JWTSecret        string                 `json:"jwt_secret"`
+	AttachmentPolicy AttachmentPolicyConfig `json:"attachment_policy"`
+
+	// Authentication-Results headers are only trusted from these servers,
+	// when empty only the topmost header is used
+	TrustedAuthServIDs []string `json:"trusted_authserv_ids"`
+	VerifyDKIM         bool     `json:"verify_dkim"`
+}
+
+type AttachmentPolicyConfig struct {
+	BlockedExtensions    []string `json:"blocked_extensions"`
+	QuarantineExtensions []string `json:"quarantine_extensions"`
 }
 
 type StorageConfig struct {
-	AttachmentsPath string `json:"attachments_path"`
+	Backend           string   `json:"backend"` // "local" or "s3"
+	AttachmentsPath   string   `json:"attachments_path"`
+	S3                S3Config `json:"s3"`
+	QuotaMB           int      `json:"quota_mb"`       // Per-user attachment quota, 0 = unlimited
+	RetentionDays     int      `json:"retention_days"` // Drop attachments of older emails, 0 = keep forever
+	GCIntervalMinutes int      `json:"gc_interval_minutes"`
+}
+
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	UseSSL    bool   `json:"use_ssl"`
+	Prefix    string `json:"prefix"`
 }
 
 type LoggingConfig struct {
@@ -117,6 +177,45 @@ func Load(configPath string) (*Config, error) {
 	if cfg.Database.MaxIdleConns == 0 {
 		cfg.Database.MaxIdleConns = 5
 	}
+	if cfg.Queue.ConsumerName == "" {
+		cfg.Queue.ConsumerName, _ = os.Hostname()
+		if cfg.Queue.ConsumerName == "" {
+			cfg.Queue.ConsumerName = "telegram-service"
+		}
+	}
+	if cfg.Queue.ClaimIdleSeconds == 0 {
+		cfg.Queue.ClaimIdleSeconds = 120
+	}
+	if cfg.Queue.MaxAttempts == 0 {
+		cfg.Queue.MaxAttempts = 8
+	}
+	if cfg.Queue.RetryBaseSeconds == 0 {
+		cfg.Queue.RetryBaseSeconds = 5
+	}
+	if cfg.Queue.RetryMaxSeconds == 0 {
+		cfg.Queue.RetryMaxSeconds = 900
+	}
+	if cfg.Queue.Workers == 0 {
+		cfg.Queue.Workers = 8
+	}
+	if cfg.Queue.SweepIntervalSeconds == 0 {
+		cfg.Queue.SweepIntervalSeconds = 60
+	}
+	if cfg.Queue.SweepGraceMinutes == 0 {
+		cfg.Queue.SweepGraceMinutes = 10
+	}
+	if cfg.Queue.SweepMaxAgeHours == 0 {
+		cfg.Queue.SweepMaxAgeHours = 24
+	}
+	if cfg.Telegram.GlobalRatePerSecond == 0 {
+		cfg.Telegram.GlobalRatePerSecond = 30
+	}
+	if cfg.Telegram.ChatRatePerSecond == 0 {
+		cfg.Telegram.ChatRatePerSecond = 1
+	}
+	if cfg.Telegram.GroupRatePerMinute == 0 {
+		cfg.Telegram.GroupRatePerMinute = 20
+	}
 	if cfg.MailFetcher.Workers == 0 {
 		cfg.MailFetcher.Workers = 3
 	}
@@ -135,6 +234,35 @@ func Load(configPath string) (*Config, error) {
 	if cfg.Web.Port == 0 {
 		cfg.Web.Port = 8080
 	}
+	if cfg.Storage.Backend == "" {
+		cfg.Storage.Backend = "local"
+	}
+	if cfg.Storage.GCIntervalMinutes == 0 {
+		cfg.Storage.GCIntervalMinutes = 60
+	}
+	if cfg.Security.AttachmentPolicy.BlockedExtensions == nil {
+		cfg.Security.AttachmentPolicy.BlockedExtensions = []string{
+			"exe", "scr", "com", "pif", "bat", "cmd", "vbs", "vbe", "js", "jse",
+			"wsf", "wsh", "ps1", "msi", "msp", "hta", "cpl", "jar", "lnk", "reg",
+		}
+	}
+	if cfg.Security.AttachmentPolicy.QuarantineExtensions == nil {
+		cfg.Security.AttachmentPolicy.QuarantineExtensions = []string{
+			"docm", "xlsm", "pptm", "dotm", "xltm", "xlam", "ppam", "iso", "img", "vhd",
+		}
+	}
+	if cfg.Telegram.MaxUploadSizeMB == 0 {
+		cfg.Telegram.MaxUploadSizeMB = 20
+	}
+	if cfg.Web.AttachmentLinkTTLHours == 0 {
+		cfg.Web.AttachmentLinkTTLHours = 168
+	}
+	if cfg.Web.ImageProxyMaxSizeMB == 0 {
+		cfg.Web.ImageProxyMaxSizeMB = 5
+	}
+	if cfg.Web.ImageCacheSizeMB == 0 {
+		cfg.Web.ImageCacheSizeMB = 64
+	}
 	if cfg.LLM.TimeoutSeconds == 0 {
 		cfg.LLM.TimeoutSeconds = 10
 	}
@@ -0,0 +1,46 @@
+package crypto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignerRoundTrip(t *testing.T) {
+	signer := NewSigner([]byte("test-secret"))
+
+	token := signer.Sign("att:email-1:0", time.Now().Add(time.Hour))
+
+	payload, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+
+	if payload != "att:email-1:0" {
+		t.Errorf("Expected payload att:email-1:0, got %s", payload)
+	}
+}
+
+func TestSignerExpired(t *testing.T) {
+	signer := NewSigner([]byte("test-secret"))
+
+	token := signer.Sign("att:email-1:0", time.Now().Add(-time.Minute))
+
+	if _, err := signer.Verify(token); err != ErrTokenExpired {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestSignerTampered(t *testing.T) {
+	signer := NewSigner([]byte("test-secret"))
+	other := NewSigner([]byte("other-secret"))
+
+	token := other.Sign("att:email-1:0", time.Now().Add(time.Hour))
+
+	if _, err := signer.Verify(token); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for foreign signature, got %v", err)
+	}
+
+	if _, err := signer.Verify("not-a-token"); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for malformed token, got %v", err)
+	}
+}
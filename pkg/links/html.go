@@ -0,0 +1,177 @@
+package links
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// MismatchClass marks links whose text shows another domain than the href
+const MismatchClass = "link-mismatch"
+
+// AnnotateHTML unwraps redirector links in sanitized HTML and records the
+// real destination: every link gets a title with its domain, and links
+// whose text shows a different domain get MismatchClass and a
+// data-destination attribute. Running it again gives the same result.
+func AnnotateHTML(content string) string {
+	var out bytes.Buffer
+	z := html.NewTokenizer(strings.NewReader(content))
+
+	// The start tag is written once the link text is known
+	var anchor *html.Token
+	var inner bytes.Buffer
+	var text strings.Builder
+
+	flush := func() {
+		if anchor == nil {
+			return
+		}
+		out.WriteString(annotateAnchor(anchor, text.String()).String())
+		out.Write(inner.Bytes())
+		anchor = nil
+		inner.Reset()
+		text.Reset()
+	}
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				return content
+			}
+			flush()
+			return out.String()
+		}
+
+		// Raw is only valid until the next call to Next
+		raw := append([]byte(nil), z.Raw()...)
+		if tt == html.StartTagToken || tt == html.EndTagToken {
+			if token := z.Token(); token.Data == "a" {
+				flush()
+				if tt == html.StartTagToken {
+					anchor = &token
+				} else {
+					out.Write(raw)
+				}
+				continue
+			}
+		}
+
+		if anchor == nil {
+			out.Write(raw)
+			continue
+		}
+
+		inner.Write(raw)
+		if tt == html.TextToken {
+			text.WriteString(html.UnescapeString(string(raw)))
+		}
+	}
+}
+
+func annotateAnchor(token *html.Token, text string) *html.Token {
+	href := ""
+	for _, attr := range token.Attr {
+		if attr.Key == "href" {
+			href = attr.Val
+		}
+	}
+	if !isWebURL(href) {
+		return token
+	}
+
+	href = Unwrap(href)
+	domain := Domain(href)
+
+	var classes []string
+	var attrs []html.Attribute
+	for _, attr := range token.Attr {
+		switch attr.Key {
+		case "href", "title", "data-destination":
+			// Replaced below
+		case "class":
+			for _, class := range strings.Fields(attr.Val) {
+				if class != MismatchClass {
+					classes = append(classes, class)
+				}
+			}
+		default:
+			attrs = append(attrs, attr)
+		}
+	}
+	attrs = append(attrs, html.Attribute{Key: "href", Val: href})
+
+	if shown, ok := Mismatch(text, href); ok {
+		classes = append(classes, MismatchClass)
+		attrs = append(attrs,
+			html.Attribute{Key: "title", Val: fmt.Sprintf("Shows %s but goes to %s", shown, domain)},
+			html.Attribute{Key: "data-destination", Val: domain})
+	} else {
+		attrs = append(attrs, html.Attribute{Key: "title", Val: "Goes to " + domain})
+	}
+
+	if len(classes) > 0 {
+		attrs = append(attrs, html.Attribute{Key: "class", Val: strings.Join(classes, " ")})
+	}
+	token.Attr = attrs
+	return token
+}
+
+// LinkMismatch is a link whose text shows another domain than it goes to
+type LinkMismatch struct {
+	Shown       string
+	Destination string
+}
+
+// Mismatches lists the distinct links in HTML whose text shows a domain
+// of another organization than their real, unwrapped destination
+func Mismatches(content string) []LinkMismatch {
+	var found []LinkMismatch
+	seen := make(map[LinkMismatch]bool)
+
+	href, inAnchor := "", false
+	var text strings.Builder
+	check := func() {
+		if !inAnchor || !isWebURL(href) {
+			return
+		}
+		href = Unwrap(href)
+		if shown, ok := Mismatch(text.String(), href); ok {
+			mismatch := LinkMismatch{Shown: shown, Destination: Domain(href)}
+			if !seen[mismatch] {
+				seen[mismatch] = true
+				found = append(found, mismatch)
+			}
+		}
+	}
+
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch tt := z.Next(); tt {
+		case html.ErrorToken:
+			check()
+			return found
+		case html.StartTagToken, html.EndTagToken:
+			token := z.Token()
+			if token.Data != "a" {
+				continue
+			}
+			check()
+			inAnchor = tt == html.StartTagToken
+			href = ""
+			text.Reset()
+			for _, attr := range token.Attr {
+				if attr.Key == "href" {
+					href = attr.Val
+				}
+			}
+		case html.TextToken:
+			if inAnchor {
+				text.Write(z.Text())
+			}
+		}
+	}
+}
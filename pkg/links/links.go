@@ -0,0 +1,175 @@
+package links
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Redirectors can wrap each other, e.g. a click tracker behind SafeLinks
+const maxUnwrapDepth = 3
+
+// redirector pulls the real destination out of a wrapped link
+type redirector struct {
+	host   func(host string) bool
+	path   string // Required path prefix, empty for any
+	params []string
+	decode func(string) string
+}
+
+func hostIs(names ...string) func(string) bool {
+	return func(host string) bool {
+		for _, name := range names {
+			if host == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func hostEndsWith(suffix string) func(string) bool {
+	return func(host string) bool {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+}
+
+var redirectors = []redirector{
+	// Microsoft Defender SafeLinks
+	{host: hostEndsWith("safelinks.protection.outlook.com"), params: []string{"url"}},
+	// Google redirects in Gmail and search results
+	{host: hostIs("www.google.com", "google.com"), path: "/url", params: []string{"q", "url"}},
+	{host: hostIs("www.youtube.com", "youtube.com"), path: "/redirect", params: []string{"q"}},
+	{host: hostIs("l.facebook.com", "lm.facebook.com"), path: "/l.php", params: []string{"u"}},
+	{host: hostIs("slack-redir.net"), path: "/link", params: []string{"url"}},
+	// Barracuda and FireEye gateways
+	{host: hostIs("linkprotect.cudasvc.com"), path: "/url", params: []string{"a"}},
+	{host: hostEndsWith("fireeye.com"), path: "/url", params: []string{"u"}},
+	// Proofpoint URL Defense v2 encodes "%" as "-" and "/" as "_"
+	{host: hostIs("urldefense.proofpoint.com"), path: "/v2/url", params: []string{"u"}, decode: decodeProofpointV2},
+}
+
+// Proofpoint URL Defense v3: https://urldefense.com/v3/__<url>__;<tokens>
+var proofpointV3Pattern = regexp.MustCompile(`^https://urldefense\.com/v3/__(.+?)__;`)
+
+// Bare URLs in plain text
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+`)
+
+// Link text that is itself a URL or domain, e.g. "www.paypal.com/login"
+var domainTextPattern = regexp.MustCompile(`(?i)^(?:https?://)?((?:[a-z0-9-]+\.)+[a-z]{2,})(?:[/:?#]\S*)?$`)
+
+// Unwrap returns the destination of a link wrapped by a known redirector,
+// or the link unchanged
+func Unwrap(rawURL string) string {
+	for i := 0; i < maxUnwrapDepth; i++ {
+		next := unwrapOnce(rawURL)
+		if next == "" || next == rawURL {
+			break
+		}
+		rawURL = next
+	}
+	return rawURL
+}
+
+func unwrapOnce(rawURL string) string {
+	if m := proofpointV3Pattern.FindStringSubmatch(rawURL); m != nil {
+		// Characters Proofpoint replaced with "*" can't be restored
+		if !strings.Contains(m[1], "*") && isWebURL(m[1]) {
+			return m[1]
+		}
+		return ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || !isWebURL(rawURL) {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+
+	for _, r := range redirectors {
+		if !r.host(host) || (r.path != "" && !strings.HasPrefix(u.Path, r.path)) {
+			continue
+		}
+
+		query := u.Query()
+		for _, param := range r.params {
+			target := query.Get(param)
+			if r.decode != nil {
+				target = r.decode(target)
+			}
+			if isWebURL(target) {
+				return target
+			}
+		}
+	}
+	return ""
+}
+
+func decodeProofpointV2(value string) string {
+	value = strings.NewReplacer("-", "%", "_", "/").Replace(value)
+	decoded, err := url.PathUnescape(value)
+	if err != nil {
+		return ""
+	}
+	return decoded
+}
+
+// UnwrapText unwraps the redirector links in plain text
+func UnwrapText(text string) string {
+	return urlPattern.ReplaceAllStringFunc(text, Unwrap)
+}
+
+// Domain returns the host a link goes to, without "www."
+func Domain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// TextDomain returns the domain link text claims to go to, if the text is
+// a URL or a domain name
+func TextDomain(text string) string {
+	m := domainTextPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return ""
+	}
+
+	domain := strings.ToLower(m[1])
+	if _, icann := publicsuffix.PublicSuffix(domain); !icann {
+		return ""
+	}
+	return strings.TrimPrefix(domain, "www.")
+}
+
+// Mismatch reports the domain link text shows when it belongs to a
+// different organization than where the link goes
+func Mismatch(text, href string) (string, bool) {
+	shown := TextDomain(text)
+	actual := Domain(href)
+	if shown == "" || actual == "" {
+		return "", false
+	}
+	if organization(shown) == organization(actual) {
+		return "", false
+	}
+	return shown, true
+}
+
+func organization(domain string) string {
+	if org, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil {
+		return org
+	}
+	return domain
+}
+
+func isWebURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
@@ -0,0 +1,108 @@
+package links
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUnwrap(t *testing.T) {
+	dest := "https://example.com/path?a=1&b=2"
+	escaped := url.QueryEscape(dest)
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"safelinks", "https://nam12.safelinks.protection.outlook.com/?url=" + escaped + "&data=05%7C01", dest},
+		{"google", "https://www.google.com/url?q=" + escaped + "&sa=D", dest},
+		{"facebook", "https://l.facebook.com/l.php?u=" + escaped + "&h=AT0", dest},
+		{"proofpoint v2", "https://urldefense.proofpoint.com/v2/url?u=https-3A__example.com_path&d=DwMF", "https://example.com/path"},
+		{"proofpoint v3", "https://urldefense.com/v3/__https://example.com/path__;!!abc$", "https://example.com/path"},
+		{"nested", "https://nam12.safelinks.protection.outlook.com/?url=" +
+			url.QueryEscape("https://www.google.com/url?q="+escaped), dest},
+		{"not a redirector", "https://shop.example.com/login?redirect=" + escaped, "https://shop.example.com/login?redirect=" + escaped},
+		{"javascript target", "https://www.google.com/url?q=javascript:alert(1)", "https://www.google.com/url?q=javascript:alert(1)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unwrap(tt.in); got != tt.want {
+				t.Errorf("Unwrap() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	tests := []struct {
+		text, href string
+		want       bool
+	}{
+		{"www.paypal.com", "https://evil.example.net/login", true},
+		{"https://paypal.com/signin", "https://paypal-secure.example.net/", true},
+		{"paypal.com", "https://www.paypal.com/signin", false},
+		{"login.paypal.com", "https://paypal.com/", false},
+		{"Click here", "https://evil.example.net/", false},
+		{"Version 1.2", "https://example.net/", false},
+	}
+
+	for _, tt := range tests {
+		if _, got := Mismatch(tt.text, tt.href); got != tt.want {
+			t.Errorf("Mismatch(%q, %q) = %v, want %v", tt.text, tt.href, got, tt.want)
+		}
+	}
+}
+
+func TestAnnotateHTML(t *testing.T) {
+	in := `<p>Log in at <a href="https://nam12.safelinks.protection.outlook.com/?url=https%3A%2F%2Fevil.example.net%2Flogin">` +
+		`<b>www.paypal.com</b></a> or <a href="https://example.org/help" class="btn">help</a> <a href="mailto:a@example.org">mail</a></p>`
+
+	out := AnnotateHTML(in)
+
+	for _, want := range []string{
+		`href="https://evil.example.net/login"`,
+		`data-destination="evil.example.net"`,
+		`class="` + MismatchClass + `"`,
+		`title="Shows paypal.com but goes to evil.example.net"`,
+		`<b>www.paypal.com</b></a>`,
+		`title="Goes to example.org" class="btn"`,
+		`<a href="mailto:a@example.org">mail</a>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "safelinks") {
+		t.Errorf("link not unwrapped:\n%s", out)
+	}
+
+	if again := AnnotateHTML(out); again != out {
+		t.Errorf("annotating twice changed the result:\n%s\n%s", out, again)
+	}
+}
+
+func TestUnwrapText(t *testing.T) {
+	in := "Track it: https://www.google.com/url?q=https%3A%2F%2Fexample.com%2Ft%2F1 (today)"
+	want := "Track it: https://example.com/t/1 (today)"
+	if got := UnwrapText(in); got != want {
+		t.Errorf("UnwrapText() = %q, want %q", got, want)
+	}
+}
+
+func TestMismatches(t *testing.T) {
+	in := `<a href="https://nam12.safelinks.protection.outlook.com/?url=https%3A%2F%2Fevil.example.net%2Flogin"><b>www.paypal.com</b></a>` +
+		` <a href="https://evil.example.net/again">paypal.com</a>` +
+		` <a href="https://www.paypal.com/signin">paypal.com</a> <a href="https://example.org/">Click here</a>`
+
+	got := Mismatches(in)
+	if len(got) != 1 || got[0] != (LinkMismatch{Shown: "paypal.com", Destination: "evil.example.net"}) {
+		t.Errorf("Mismatches() = %+v", got)
+	}
+
+	// Links annotated for the email view are found the same
+	if again := Mismatches(AnnotateHTML(in)); len(again) != 1 {
+		t.Errorf("Mismatches(AnnotateHTML()) = %+v", again)
+	}
+}
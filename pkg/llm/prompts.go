@@ -3,9 +3,11 @@ package llm
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"text/template"
 
 	"github.com/kexi/mail-to-tg/pkg/models"
+	"github.com/kexi/mail-to-tg/pkg/textutil"
 )
 
 // EmailSummaryPrompt is the template for email summarization
@@ -25,7 +27,8 @@ Provide JSON with:
     "amounts": ["$49.99"],
     "due_dates": ["2024-02-15"],
     "action_items": ["Click verification link"],
-    "tracking_numbers": ["1Z999AA10123456784"]
+    "tracking_numbers": ["1Z999AA10123456784"],
+    "category": "finance"
   }
 }
 
@@ -34,7 +37,8 @@ Be concise and highlight the most important information.
 For verification codes, extract any numeric or alphanumeric codes.
 For amounts, include currency symbols.
 For dates, use ISO format (YYYY-MM-DD) when possible.
-For action items, be specific about what the user needs to do.`
+For action items, be specific about what the user needs to do.
+For category, pick exactly one of: {{.Categories}}.`
 
 // EmailPromptData contains data for template rendering
 type EmailPromptData struct {
@@ -42,6 +46,7 @@ type EmailPromptData struct {
 	FromName    string
 	Subject     string
 	Body        string
+	Categories  string
 }
 
 // BuildEmailPrompt builds the email summarization prompt from an email message
@@ -50,6 +55,7 @@ func BuildEmailPrompt(email *models.EmailMessage) (string, error) {
 		FromAddress: email.FromAddress,
 		Subject:     "No subject",
 		Body:        "",
+		Categories:  strings.Join(Categories, ", "),
 	}
 
 	if email.FromName != nil && *email.FromName != "" {
@@ -60,19 +66,21 @@ func BuildEmailPrompt(email *models.EmailMessage) (string, error) {
 		data.Subject = *email.Subject
 	}
 
-	// Prefer text body, fallback to HTML body
-	if email.TextBody != nil && *email.TextBody != "" {
+	// Prefer the new content of a reply, then text body, fallback to the
+	// plain rendering of the HTML body
+	if email.NewContent != nil && *email.NewContent != "" {
+		data.Body = *email.NewContent
+	} else if email.TextBody != nil && *email.TextBody != "" {
 		data.Body = *email.TextBody
+	} else if email.HTMLText != nil && *email.HTMLText != "" {
+		data.Body = *email.HTMLText
 	} else if email.HTMLBody != nil && *email.HTMLBody != "" {
-		// For HTML, we'll use it as-is (the LLM can handle HTML)
-		// In a production system, you might want to strip HTML tags
-		data.Body = *email.HTMLBody
+		// Stored before the plain rendering existed
+		data.Body = textutil.HTMLToText(*email.HTMLBody)
 	}
 
 	// Limit body length to prevent excessive token usage (max ~4000 chars)
-	if len(data.Body) > 4000 {
-		data.Body = data.Body[:4000] + "... (truncated)"
-	}
+	data.Body = textutil.Truncate(data.Body, 4000, "... (truncated)")
 
 	tmpl, err := template.New("prompt").Parse(EmailSummaryPrompt)
 	if err != nil {
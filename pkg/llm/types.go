@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var (
@@ -17,6 +18,12 @@ var (
 	ErrAPIError = errors.New("llm api error")
 )
 
+// Categories the summary sorts emails into, for rules to match on
+var Categories = []string{
+	"personal", "work", "finance", "shopping", "travel",
+	"social", "newsletter", "promotion", "notification", "security",
+}
+
 // ParseExtractedData parses the extracted data JSON string into a map
 func ParseExtractedData(jsonData string) (map[string]interface{}, error) {
 	if jsonData == "" {
@@ -74,3 +81,16 @@ func GetStringSlice(data map[string]interface{}, key string) []string {
 
 	return nil
 }
+
+// GetCategory returns the category of the extracted data, empty when the
+// model didn't give a known one
+func GetCategory(data map[string]interface{}) string {
+	category, _ := data["category"].(string)
+	category = strings.ToLower(strings.TrimSpace(category))
+	for _, known := range Categories {
+		if category == known {
+			return category
+		}
+	}
+	return ""
+}
@@ -23,6 +23,10 @@ type EmailAccount struct {
 	IsActive                   bool       `db:"is_active" json:"is_active"`
 	LastFetchAt                *time.Time `db:"last_fetch_at" json:"last_fetch_at,omitempty"`
 	LastError                  *string    `db:"last_error" json:"last_error,omitempty"`
+	IMAPUIDValidity            *int64     `db:"imap_uid_validity" json:"imap_uid_validity,omitempty"`   // Of INBOX, when the stored UIDs were seen
+	DeliveryChatID             *int64     `db:"delivery_chat_id" json:"delivery_chat_id,omitempty"`     // Group or channel instead of the private chat
+	DeliveryThreadID           *int       `db:"delivery_thread_id" json:"delivery_thread_id,omitempty"` // Forum topic in DeliveryChatID
+	DeliveryVerifiedAt         *time.Time `db:"delivery_verified_at" json:"delivery_verified_at,omitempty"`
 	CreatedAt                  time.Time  `db:"created_at" json:"created_at"`
 	UpdatedAt                  time.Time  `db:"updated_at" json:"updated_at"`
 }
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+type AttachmentBlob struct {
+	SHA256           string    `db:"sha256" json:"sha256"`
+	Size             int64     `db:"size" json:"size"`
+	ContentType      *string   `db:"content_type" json:"content_type,omitempty"`
+	RefCount         int       `db:"ref_count" json:"ref_count"`
+	CreatedAt        time.Time `db:"created_at" json:"created_at"`
+	LastReferencedAt time.Time `db:"last_referenced_at" json:"last_referenced_at"`
+}
+
+type EmailAttachment struct {
+	ID          string    `db:"id" json:"id"`
+	EmailID     string    `db:"email_id" json:"email_id"`
+	BlobSHA256  string    `db:"blob_sha256" json:"blob_sha256"`
+	Filename    string    `db:"filename" json:"filename"`
+	ContentType *string   `db:"content_type" json:"content_type,omitempty"`
+	Size        int64     `db:"size" json:"size"`
+	Position    int       `db:"position" json:"position"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
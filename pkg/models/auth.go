@@ -0,0 +1,37 @@
+package models
+
+import "encoding/json"
+
+// Overall sender authentication verdicts
+const (
+	AuthPass = "pass" // Aligned SPF or DKIM, or DMARC pass
+	AuthFail = "fail" // DMARC failed, or nothing aligned passed
+	AuthNone = "none" // No trusted authentication results
+)
+
+// AuthVerdict is the sender authentication result of an email, stored as
+// JSON in the auth_verdict column
+type AuthVerdict struct {
+	Verdict    string   `json:"verdict"`
+	FromDomain string   `json:"from_domain"`
+	SPF        string   `json:"spf,omitempty"`
+	SPFDomain  string   `json:"spf_domain,omitempty"`
+	DKIM       string   `json:"dkim,omitempty"`
+	DKIMDomain string   `json:"dkim_domain,omitempty"`
+	DMARC      string   `json:"dmarc,omitempty"`
+	Aligned    bool     `json:"aligned"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// ParseAuthVerdict decodes the auth_verdict column
+func (e *EmailMessage) ParseAuthVerdict() (*AuthVerdict, error) {
+	if e.AuthVerdict == nil || *e.AuthVerdict == "" {
+		return nil, nil
+	}
+
+	var verdict AuthVerdict
+	if err := json.Unmarshal([]byte(*e.AuthVerdict), &verdict); err != nil {
+		return nil, err
+	}
+	return &verdict, nil
+}
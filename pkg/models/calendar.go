@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// iTIP methods carried by calendar invitations
+const (
+	CalendarMethodRequest = "REQUEST"
+	CalendarMethodCancel  = "CANCEL"
+	CalendarMethodReply   = "REPLY"
+)
+
+// CalendarEvent is the event of an iMIP invitation, stored as JSON in the
+// calendar_event column of email_messages
+type CalendarEvent struct {
+	Method        string    `json:"method"`
+	UID           string    `json:"uid"`
+	Sequence      int       `json:"sequence"`
+	RecurrenceID  string    `json:"recurrence_id,omitempty"`
+	RecurrenceTZ  string    `json:"recurrence_tz,omitempty"`
+	Summary       string    `json:"summary,omitempty"`
+	Description   string    `json:"description,omitempty"`
+	Location      string    `json:"location,omitempty"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end,omitempty"`
+	AllDay        bool      `json:"all_day,omitempty"`
+	Organizer     string    `json:"organizer,omitempty"`
+	OrganizerName string    `json:"organizer_name,omitempty"`
+	Attendees     []string  `json:"attendees,omitempty"`
+	Status        string    `json:"status,omitempty"`
+}
+
+// IsUpdate reports whether a request changes an event sent before
+func (e *CalendarEvent) IsUpdate() bool {
+	return e.Method == CalendarMethodRequest && e.Sequence > 0
+}
+
+// ParseCalendarEvent decodes the calendar_event column
+func (e *EmailMessage) ParseCalendarEvent() (*CalendarEvent, error) {
+	if e.CalendarEvent == nil || *e.CalendarEvent == "" {
+		return nil, nil
+	}
+
+	var event CalendarEvent
+	if err := json.Unmarshal([]byte(*e.CalendarEvent), &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Kinds of user key material
+const (
+	CryptoKeySMIME = "smime"
+	CryptoKeyPGP   = "pgp"
+)
+
+// Signature verdicts
+const (
+	SignatureVerified   = "verified"   // Valid and bound to the sender
+	SignatureUnverified = "unverified" // Intact, but the signer can't be trusted or is unknown
+	SignatureInvalid    = "invalid"    // The content doesn't match the signature
+)
+
+// UserCryptoKey is an S/MIME certificate or OpenPGP key a user imported.
+// Key data and passphrase are encrypted under security.encryption_key.
+type UserCryptoKey struct {
+	ID                  string    `db:"id" json:"id"`
+	UserID              string    `db:"user_id" json:"user_id"`
+	Kind                string    `db:"kind" json:"kind"`
+	Fingerprint         string    `db:"fingerprint" json:"fingerprint"`
+	Identity            string    `db:"identity" json:"identity"`
+	HasPrivate          bool      `db:"has_private" json:"has_private"`
+	KeyDataEncrypted    string    `db:"key_data_encrypted" json:"-"`
+	PassphraseEncrypted *string   `db:"passphrase_encrypted" json:"-"`
+	CreatedAt           time.Time `db:"created_at" json:"created_at"`
+}
+
+// CryptoStatus records what the parser found out about a signed or
+// encrypted email, stored as JSON in the crypto_status column
+type CryptoStatus struct {
+	Protocol       string `json:"protocol"` // "smime" or "pgp"
+	Signed         bool   `json:"signed,omitempty"`
+	Signature      string `json:"signature,omitempty"`
+	Signer         string `json:"signer,omitempty"`
+	SignatureError string `json:"signature_error,omitempty"`
+	Encrypted      bool   `json:"encrypted,omitempty"`
+	Decrypted      bool   `json:"decrypted,omitempty"`
+	DecryptError   string `json:"decrypt_error,omitempty"`
+}
+
+// ParseCryptoStatus decodes the crypto_status column
+func (e *EmailMessage) ParseCryptoStatus() (*CryptoStatus, error) {
+	if e.CryptoStatus == nil || *e.CryptoStatus == "" {
+		return nil, nil
+	}
+
+	var status CryptoStatus
+	if err := json.Unmarshal([]byte(*e.CryptoStatus), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
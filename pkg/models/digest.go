@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// DigestSchedule is how often a user's digest is sent
+type DigestSchedule string
+
+const (
+	DigestOff    DigestSchedule = "off"    // Low-priority mail is notified, rule digests are daily
+	DigestHourly DigestSchedule = "hourly" // At the top of each hour
+	DigestDaily  DigestSchedule = "daily"  // Once a day at DigestTime
+)
+
+// DefaultDigestTime is when daily digests are sent unless the user picked
+// another time
+const DefaultDigestTime = "08:00"
+
+// DigestItem is an email waiting for the user's next digest
+type DigestItem struct {
+	ID        string    `db:"id" json:"id"`
+	UserID    string    `db:"user_id" json:"user_id"`
+	EmailID   string    `db:"email_id" json:"email_id"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// PendingDigest is a user with mail waiting for a digest, and since when
+type PendingDigest struct {
+	UserID string    `db:"user_id"`
+	Since  time.Time `db:"since"`
+}
+
+// Valid reports whether s is one of the known schedules
+func (s DigestSchedule) Valid() bool {
+	return s == DigestOff || s == DigestHourly || s == DigestDaily
+}
+
+// NextDigest returns when the digest holding mail collected at since is
+// sent, in the user's timezone
+func (u *User) NextDigest(since time.Time) time.Time {
+	loc := u.Location()
+	t := since.In(loc)
+
+	if u.DigestSchedule == DigestHourly {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(time.Hour)
+	}
+
+	clock, err := time.Parse("15:04", u.DigestTime)
+	if err != nil {
+		clock, _ = time.Parse("15:04", DefaultDigestTime)
+	}
+
+	next := time.Date(t.Year(), t.Month(), t.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
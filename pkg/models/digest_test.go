@@ -0,0 +1,34 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDigest(t *testing.T) {
+	berlin := "Europe/Berlin"
+	loc, err := time.LoadLocation(berlin)
+	if err != nil {
+		t.Skip("no timezone data")
+	}
+
+	tests := []struct {
+		name  string
+		user  *User
+		since time.Time
+		want  time.Time
+	}{
+		{"hourly", &User{DigestSchedule: DigestHourly}, time.Date(2026, 3, 2, 9, 40, 0, 0, time.UTC), time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)},
+		{"daily later today", &User{DigestSchedule: DigestDaily, DigestTime: "18:30", Timezone: &berlin}, time.Date(2026, 3, 2, 9, 0, 0, 0, loc), time.Date(2026, 3, 2, 18, 30, 0, 0, loc)},
+		{"daily tomorrow", &User{DigestSchedule: DigestDaily, DigestTime: "08:00", Timezone: &berlin}, time.Date(2026, 3, 2, 8, 0, 0, 0, loc), time.Date(2026, 3, 3, 8, 0, 0, 0, loc)},
+		{"rule digest without schedule", &User{DigestSchedule: DigestOff}, time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 3, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.user.NextDigest(tt.since); !got.Equal(tt.want) {
+				t.Errorf("NextDigest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,16 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RemoteImageAttr holds the URL of a remote image the sanitizer blocked. The
+// web view turns it back into a proxied src when the user loads images.
+const RemoteImageAttr = "data-remote-src"
 
 type EmailMessage struct {
 	ID             string     `db:"id" json:"id"`
@@ -15,7 +25,9 @@ type EmailMessage struct {
 	Subject        *string    `db:"subject" json:"subject,omitempty"`
 	Date           time.Time  `db:"date" json:"date"`
 	TextBody       *string    `db:"text_body" json:"text_body,omitempty"`
+	NewContent     *string    `db:"new_content" json:"new_content,omitempty"` // Body without quotes and signature
 	HTMLBody       *string    `db:"html_body" json:"html_body,omitempty"`
+	HTMLText       *string    `db:"html_text" json:"html_text,omitempty"` // HTML body rendered as plain text
 	SanitizedHTML  *string    `db:"sanitized_html" json:"sanitized_html,omitempty"`
 	HasAttachments bool       `db:"has_attachments" json:"has_attachments"`
 	Attachments    *string    `db:"attachments" json:"attachments,omitempty"` // JSON array
@@ -24,11 +36,25 @@ type EmailMessage struct {
 	IsRead           bool       `db:"is_read" json:"is_read"`
 	IsNotified       bool       `db:"is_notified" json:"is_notified"`
 	NotifiedAt       *time.Time `db:"notified_at" json:"notified_at,omitempty"`
+	RepliedAt        *time.Time `db:"replied_at" json:"replied_at,omitempty"`
+	IsDeleted        bool       `db:"is_deleted" json:"is_deleted"` // Deleted or moved out of INBOX on the mail server
 	AISummary        *string    `db:"ai_summary" json:"ai_summary,omitempty"`
 	AIExtractedData  *string    `db:"ai_extracted_data" json:"ai_extracted_data,omitempty"` // JSON object
 	AISummaryModel   *string    `db:"ai_summary_model" json:"ai_summary_model,omitempty"`
 	AISummaryAt      *time.Time `db:"ai_summary_at" json:"ai_summary_at,omitempty"`
 	AISummaryError   *string    `db:"ai_summary_error" json:"ai_summary_error,omitempty"`
+	CalendarEvent    *string    `db:"calendar_event" json:"calendar_event,omitempty"` // JSON object
+	CalendarRSVP     *string    `db:"calendar_rsvp" json:"calendar_rsvp,omitempty"`
+	CryptoStatus     *string    `db:"crypto_status" json:"crypto_status,omitempty"` // JSON object
+	AuthVerdict      *string    `db:"auth_verdict" json:"auth_verdict,omitempty"`   // JSON object
+	ListID           *string    `db:"list_id" json:"list_id,omitempty"`
+	ListUnsubscribe  *string    `db:"list_unsubscribe" json:"list_unsubscribe,omitempty"`
+	ListUnsubscribePost *string `db:"list_unsubscribe_post" json:"list_unsubscribe_post,omitempty"`
+	EmbeddedMessages *string    `db:"embedded_messages" json:"embedded_messages,omitempty"` // JSON array
+	RawSHA256        *string    `db:"raw_sha256" json:"raw_sha256,omitempty"`               // Original message in the blob store
+	Priority         Priority   `db:"priority" json:"priority"`
+	Tags             *string    `db:"tags" json:"tags,omitempty"` // JSON array
+	AutoForwarded    bool       `db:"auto_forwarded" json:"auto_forwarded"`
 	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
 	UpdatedAt        time.Time  `db:"updated_at" json:"updated_at"`
 }
@@ -55,9 +81,84 @@ type SentReply struct {
 	Error           *string    `db:"error" json:"error,omitempty"`
 }
 
+// Attachment safety verdicts set by the parser's attachment policy
+const (
+	SafetyBlocked     = "blocked"
+	SafetyQuarantined = "quarantined"
+)
+
+// Skipped reason of attachments dropped by the retention period
+const SkippedExpired = "expired"
+
 type Attachment struct {
-	Filename    string `json:"filename"`
-	ContentType string `json:"content_type"`
-	Size        int64  `json:"size"`
-	Path        string `json:"path"`
+	Filename            string `json:"filename"`
+	ContentType         string `json:"content_type"`
+	DeclaredContentType string `json:"declared_content_type,omitempty"` // Set when sniffing disagreed
+	Size                int64  `json:"size"`
+	SHA256              string `json:"sha256,omitempty"`
+	Path                string `json:"path"`
+	Skipped             string `json:"skipped,omitempty"` // Why the content was not stored
+	Safety              string `json:"safety,omitempty"`  // "blocked" or "quarantined"
+	SafetyReason        string `json:"safety_reason,omitempty"`
+	Parent              string `json:"parent,omitempty"` // Forwarded message or winmail.dat it came out of
+	Content             []byte `json:"-"`
+}
+
+// ParseAttachments decodes the attachments JSON column
+func (e *EmailMessage) ParseAttachments() ([]*Attachment, error) {
+	if e.Attachments == nil || *e.Attachments == "" {
+		return nil, nil
+	}
+
+	var attachments []*Attachment
+	if err := json.Unmarshal([]byte(*e.Attachments), &attachments); err != nil {
+		return nil, fmt.Errorf("failed to parse attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// ExpireAttachments marks the stored attachments as dropped by the
+// retention period, so they are no longer offered for download
+func (e *EmailMessage) ExpireAttachments() error {
+	attachments, err := e.ParseAttachments()
+	if err != nil || len(attachments) == 0 {
+		return err
+	}
+
+	for _, attachment := range attachments {
+		if attachment.Path == "" {
+			continue
+		}
+		attachment.Path = ""
+		attachment.Skipped = SkippedExpired
+	}
+
+	data, err := json.Marshal(attachments)
+	if err != nil {
+		return fmt.Errorf("failed to encode attachments: %w", err)
+	}
+	encoded := string(data)
+	e.Attachments = &encoded
+	return nil
+}
+
+// AttachmentRef builds the payload used in signed attachment download links
+func AttachmentRef(emailID string, index int) string {
+	return fmt.Sprintf("att:%s:%d", emailID, index)
+}
+
+// ParseAttachmentRef splits a payload built by AttachmentRef
+func ParseAttachmentRef(ref string) (string, int, error) {
+	parts := strings.Split(ref, ":")
+	if len(parts) != 3 || parts[0] != "att" {
+		return "", 0, fmt.Errorf("invalid attachment reference")
+	}
+
+	index, err := strconv.Atoi(parts[2])
+	if err != nil || index < 0 {
+		return "", 0, fmt.Errorf("invalid attachment index")
+	}
+
+	return parts[1], index, nil
 }
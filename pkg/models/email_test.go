@@ -0,0 +1,31 @@
+package models
+
+import "testing"
+
+func TestExpireAttachments(t *testing.T) {
+	email := &EmailMessage{Attachments: strPtr(`[
+		{"filename":"a.pdf","size":10,"path":"blobs/aa","sha256":"aa"},
+		{"filename":"big.iso","size":99,"path":"","skipped":"storage quota exceeded"}
+	]`)}
+
+	if err := email.ExpireAttachments(); err != nil {
+		t.Fatalf("ExpireAttachments: %v", err)
+	}
+
+	attachments, err := email.ParseAttachments()
+	if err != nil || len(attachments) != 2 {
+		t.Fatalf("ParseAttachments = %v, %v", attachments, err)
+	}
+	if attachments[0].Path != "" || attachments[0].Skipped != SkippedExpired || attachments[0].Filename != "a.pdf" {
+		t.Errorf("stored attachment = %+v", attachments[0])
+	}
+	if attachments[1].Skipped != "storage quota exceeded" {
+		t.Errorf("skipped attachment = %+v", attachments[1])
+	}
+
+	// Emails without an attachment list are left alone
+	empty := &EmailMessage{}
+	if err := empty.ExpireAttachments(); err != nil || empty.Attachments != nil {
+		t.Errorf("ExpireAttachments without attachments = %v, %v", empty.Attachments, err)
+	}
+}
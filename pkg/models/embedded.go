@@ -0,0 +1,33 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EmbeddedMessage is an email attached to another one, e.g. forwarded as
+// an attachment. Stored as JSON in the embedded_messages column.
+type EmbeddedMessage struct {
+	From          string             `json:"from"`
+	To            string             `json:"to,omitempty"`
+	Cc            string             `json:"cc,omitempty"`
+	Subject       string             `json:"subject,omitempty"`
+	Date          *time.Time         `json:"date,omitempty"`
+	TextBody      string             `json:"text_body,omitempty"`
+	SanitizedHTML string             `json:"sanitized_html,omitempty"`
+	Attachments   []string           `json:"attachments,omitempty"` // Filenames, stored with the outer email's attachments
+	Embedded      []*EmbeddedMessage `json:"embedded,omitempty"`
+}
+
+// ParseEmbeddedMessages decodes the embedded_messages column
+func (e *EmailMessage) ParseEmbeddedMessages() ([]*EmbeddedMessage, error) {
+	if e.EmbeddedMessages == nil || *e.EmbeddedMessages == "" {
+		return nil, nil
+	}
+
+	var messages []*EmbeddedMessage
+	if err := json.Unmarshal([]byte(*e.EmbeddedMessages), &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Ways a list was unsubscribed from
+const (
+	UnsubscribeOneClick = "one_click" // RFC 8058 POST
+	UnsubscribeMailto   = "mailto"
+)
+
+// ListUnsubscribe records an unsubscribe from a mailing list. Later mail
+// from a muted list is stored but not notified.
+type ListUnsubscribe struct {
+	ID        string    `db:"id" json:"id"`
+	UserID    string    `db:"user_id" json:"user_id"`
+	ListKey   string    `db:"list_key" json:"list_key"` // List-Id, or the sender without one
+	Name      string    `db:"name" json:"name"`
+	Method    string    `db:"method" json:"method"`
+	Target    string    `db:"target" json:"target"`
+	Success   bool      `db:"success" json:"success"`
+	Error     *string   `db:"error" json:"error,omitempty"`
+	Muted     bool      `db:"muted" json:"muted"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// ListKey identifies the mailing list an email came from
+func (e *EmailMessage) ListKey() string {
+	if e.ListID != nil && *e.ListID != "" {
+		return *e.ListID
+	}
+	return strings.ToLower(e.FromAddress)
+}
+
+// UnsubscribeOptions reads the List-Unsubscribe (RFC 2369) and
+// List-Unsubscribe-Post (RFC 8058) headers. oneClick is only set for
+// https URLs the sender marked for one-click POST.
+func (e *EmailMessage) UnsubscribeOptions() (oneClick string, mailto *url.URL) {
+	if e.ListUnsubscribe == nil {
+		return "", nil
+	}
+
+	postAllowed := e.ListUnsubscribePost != nil &&
+		strings.EqualFold(strings.TrimSpace(*e.ListUnsubscribePost), "List-Unsubscribe=One-Click")
+
+	// <https://example.com/unsub?id=1>, <mailto:unsub@example.com?subject=stop>
+	for _, part := range strings.Split(*e.ListUnsubscribe, ",") {
+		part = strings.TrimSpace(part)
+		if !strings.HasPrefix(part, "<") || !strings.HasSuffix(part, ">") {
+			continue
+		}
+
+		u, err := url.Parse(strings.TrimSpace(part[1 : len(part)-1]))
+		if err != nil {
+			continue
+		}
+
+		switch strings.ToLower(u.Scheme) {
+		case "https":
+			if postAllowed && oneClick == "" && u.Host != "" {
+				oneClick = u.String()
+			}
+		case "mailto":
+			if mailto == nil && u.Opaque != "" {
+				mailto = u
+			}
+		}
+	}
+
+	return oneClick, mailto
+}
@@ -0,0 +1,70 @@
+package models
+
+import "testing"
+
+func strPtr(s string) *string { return &s }
+
+func TestUnsubscribeOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     *string
+		post       *string
+		wantURL    string
+		wantMailto string
+	}{
+		{
+			name:       "one-click and mailto",
+			header:     strPtr("<https://news.example.com/unsub?id=1>, <mailto:unsub@example.com?subject=stop>"),
+			post:       strPtr("List-Unsubscribe=One-Click"),
+			wantURL:    "https://news.example.com/unsub?id=1",
+			wantMailto: "mailto:unsub@example.com?subject=stop",
+		},
+		{
+			name:       "URL without one-click post",
+			header:     strPtr("<https://news.example.com/unsub?id=1>, <mailto:unsub@example.com>"),
+			wantMailto: "mailto:unsub@example.com",
+		},
+		{
+			name:   "plain http is not posted to",
+			header: strPtr("<http://news.example.com/unsub>"),
+			post:   strPtr("List-Unsubscribe=One-Click"),
+		},
+		{
+			name:   "no angle brackets",
+			header: strPtr("mailto:unsub@example.com"),
+		},
+		{
+			name: "no header",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := &EmailMessage{ListUnsubscribe: tt.header, ListUnsubscribePost: tt.post}
+			oneClick, mailto := email.UnsubscribeOptions()
+
+			if oneClick != tt.wantURL {
+				t.Errorf("oneClick = %q, want %q", oneClick, tt.wantURL)
+			}
+			gotMailto := ""
+			if mailto != nil {
+				gotMailto = mailto.String()
+			}
+			if gotMailto != tt.wantMailto {
+				t.Errorf("mailto = %q, want %q", gotMailto, tt.wantMailto)
+			}
+		})
+	}
+}
+
+func TestListKey(t *testing.T) {
+	email := &EmailMessage{FromAddress: "News@Example.com"}
+	if got := email.ListKey(); got != "news@example.com" {
+		t.Errorf("ListKey() = %q, want sender address", got)
+	}
+
+	email.ListID = strPtr("weekly.news.example.com")
+	if got := email.ListKey(); got != "weekly.news.example.com" {
+		t.Errorf("ListKey() = %q, want list id", got)
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+// Priority decides which queue lane an email's notification goes through
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"   // One-time passwords, VIP senders
+	PriorityNormal Priority = "normal" // Everything else
+	PriorityLow    Priority = "low"    // Newsletters and other bulk mail
+)
+
+// Priorities lists the priorities from highest to lowest
+var Priorities = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// Valid reports whether p is one of the known priorities
+func (p Priority) Valid() bool {
+	for _, known := range Priorities {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
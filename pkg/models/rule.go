@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Rule filters or routes a user's notifications. All conditions set must
+// match for the actions to apply.
+type Rule struct {
+	ID         string    `db:"id" json:"id"`
+	UserID     string    `db:"user_id" json:"user_id"`
+	Position   int       `db:"position" json:"position"`
+	Definition string    `db:"definition" json:"definition"` // As the user wrote it
+	Conditions string    `db:"conditions" json:"conditions"` // JSON object
+	Actions    string    `db:"actions" json:"actions"`       // JSON object
+	Enabled    bool      `db:"enabled" json:"enabled"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type RuleConditions struct {
+	AccountID     string `json:"account_id,omitempty"`
+	From          string `json:"from,omitempty"`    // Address, or @domain including subdomains
+	Subject       string `json:"subject,omitempty"` // Regular expression
+	Body          string `json:"body,omitempty"`    // Regular expression
+	HasAttachment *bool  `json:"has_attachment,omitempty"`
+	ListID        string `json:"list_id,omitempty"`
+	Category      string `json:"category,omitempty"` // Category from the AI summary
+}
+
+// Headers on mail forwarded by a rule. Mail carrying them is not forwarded
+// again, so rules forwarding between accounts can't loop.
+const (
+	ForwardedByHeader = "X-Forwarded-By"
+	ForwardedByValue  = "mail-to-tg"
+)
+
+type RuleActions struct {
+	Skip      bool     `json:"skip,omitempty"`   // No notification
+	Silent    bool     `json:"silent,omitempty"` // Notify without sound
+	Digest    bool     `json:"digest,omitempty"` // Collect for the digest instead
+	ChatID    int64    `json:"chat_id,omitempty"`
+	TopicID   int      `json:"topic_id,omitempty"` // Forum topic in ChatID
+	Priority  Priority `json:"priority,omitempty"`
+	MarkRead  bool     `json:"mark_read,omitempty"` // On the mail server
+	ForwardTo string   `json:"forward_to,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Stop      bool     `json:"stop,omitempty"` // Later rules are not evaluated
+}
+
+func (r *Rule) ParseConditions() (*RuleConditions, error) {
+	var conditions RuleConditions
+	if err := json.Unmarshal([]byte(r.Conditions), &conditions); err != nil {
+		return nil, err
+	}
+	return &conditions, nil
+}
+
+func (r *Rule) ParseActions() (*RuleActions, error) {
+	var actions RuleActions
+	if err := json.Unmarshal([]byte(r.Actions), &actions); err != nil {
+		return nil, err
+	}
+	return &actions, nil
+}
+
+// ParseTags returns the tags rules put on the email
+func (e *EmailMessage) ParseTags() []string {
+	if e.Tags == nil || *e.Tags == "" {
+		return nil
+	}
+
+	var tags []string
+	if err := json.Unmarshal([]byte(*e.Tags), &tags); err != nil {
+		return nil
+	}
+	return tags
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// TelegramMessage is a notification sent for an email, kept so it can be
+// edited when the email changes
+type TelegramMessage struct {
+	ID        string    `db:"id" json:"id"`
+	EmailID   string    `db:"email_id" json:"email_id"`
+	ChatID    int64     `db:"chat_id" json:"chat_id"`
+	MessageID int       `db:"message_id" json:"message_id"`
+	ThreadID  *int      `db:"thread_id" json:"thread_id,omitempty"` // Forum topic
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
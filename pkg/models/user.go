@@ -3,12 +3,28 @@ package models
 import "time"
 
 type User struct {
-	ID         string    `db:"id" json:"id"`
-	TelegramID int64     `db:"telegram_id" json:"telegram_id"`
-	Username   *string   `db:"username" json:"username,omitempty"`
-	FirstName  *string   `db:"first_name" json:"first_name,omitempty"`
-	LastName   *string   `db:"last_name" json:"last_name,omitempty"`
-	IsActive   bool      `db:"is_active" json:"is_active"`
-	CreatedAt  time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt  time.Time `db:"updated_at" json:"updated_at"`
+	ID             string         `db:"id" json:"id"`
+	TelegramID     int64          `db:"telegram_id" json:"telegram_id"`
+	Username       *string        `db:"username" json:"username,omitempty"`
+	FirstName      *string        `db:"first_name" json:"first_name,omitempty"`
+	LastName       *string        `db:"last_name" json:"last_name,omitempty"`
+	IsActive       bool           `db:"is_active" json:"is_active"`
+	Timezone       *string        `db:"timezone" json:"timezone,omitempty"` // IANA name, e.g. Europe/Berlin
+	DigestSchedule DigestSchedule `db:"digest_schedule" json:"digest_schedule"`
+	DigestTime     string         `db:"digest_time" json:"digest_time"` // HH:MM, local time of the daily digest
+	CreatedAt      time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time      `db:"updated_at" json:"updated_at"`
+}
+
+// Location returns the user's timezone, UTC when unset or unknown
+func (u *User) Location() *time.Location {
+	if u.Timezone == nil || *u.Timezone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(*u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
 }
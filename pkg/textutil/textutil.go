@@ -0,0 +1,57 @@
+package textutil
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jaytaylor/html2text"
+)
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// HTMLToText renders an HTML body as readable plain text. Links are kept as
+// "text ( url )" and tables are drawn as ASCII tables.
+func HTMLToText(htmlText string) string {
+	text, err := html2text.FromString(htmlText, html2text.Options{PrettyTables: true})
+	if err != nil {
+		// Tables are the fragile part, retry without them
+		text, err = html2text.FromString(htmlText)
+		if err != nil {
+			return ""
+		}
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.TrimSpace(blankLines.ReplaceAllString(text, "\n\n"))
+}
+
+// Truncate shortens s to at most max characters, never splitting a UTF-8
+// sequence, and appends suffix when something was cut
+func Truncate(s string, max int, suffix string) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+
+	count := 0
+	for i := range s {
+		if count == max {
+			return s[:i] + suffix
+		}
+		count++
+	}
+	return s
+}
+
+// FormatSize renders a byte count for people, e.g. "1.5 MB"
+func FormatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
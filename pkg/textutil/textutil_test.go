@@ -0,0 +1,48 @@
+package textutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello world", 5, "hello..."},
+		{"你好世界", 2, "你好..."},
+		{"😀😀😀", 1, "😀..."},
+	}
+
+	for _, tt := range tests {
+		if got := Truncate(tt.in, tt.max, "..."); got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	html := `<html><head><style>p { color: red; }</style></head><body>
+		<p>Your order has shipped.</p>
+		<p><a href="https://shop.example.com/track/1">Track package</a></p>
+		<table><tr><th>Item</th><th>Qty</th></tr><tr><td>Book</td><td>2</td></tr></table>
+		<script>track()</script>
+	</body></html>`
+
+	text := HTMLToText(html)
+
+	for _, want := range []string{"Your order has shipped.", "https://shop.example.com/track/1", "Book", "| "} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+	for _, unwanted := range []string{"color: red", "track()", "<p>"} {
+		if strings.Contains(text, unwanted) {
+			t.Errorf("unexpected %q in:\n%s", unwanted, text)
+		}
+	}
+}